@@ -0,0 +1,306 @@
+// Package dupes finds images that look alike, such as the same photo
+// imported twice under another name or at another resolution.
+package dupes
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/image-archive/indexer"
+	"github.com/image-archive/state"
+	"github.com/spf13/cobra"
+)
+
+var (
+	similarity float64 // --similarity
+	algorithm  string  // --hash
+	format     string  // --format
+	output     string  // --output
+)
+
+// hashBucket is the state bucket caching perceptual hashes by slash path.
+const hashBucket = "phash"
+
+// cached is the hashes of an image along with the file version they
+// were computed from.
+type cached struct {
+	Size    int64
+	ModTime time.Time
+	hashes
+}
+
+// Image is an image of a duplicate group.
+type Image struct {
+	Path       string  `json:"path"` // Slash path relative to the archive root
+	Width      int     `json:"width"`
+	Height     int     `json:"height"`
+	Size       int64   `json:"size"`
+	Similarity float64 `json:"similarity"` // Percent similarity to the first image of the group
+}
+
+// Group is a set of images that look alike, largest first, or of exact
+// copies, oldest first.
+type Group struct {
+	Images []Image `json:"images"`
+	SHA256 string  `json:"sha256,omitempty"` // Digest shared by exact copies
+}
+
+// NewCommand returns the dupes subcommand.
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dupes [directory]",
+		Short: "Find near-duplicate images using perceptual hashes",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run(cmd.OutOrStdout(), filepath.Clean(args[0]))
+		},
+	}
+	cmd.Flags().Float64Var(&similarity, "similarity", 90, "Minimum similarity in percent for images to be grouped")
+	cmd.Flags().StringVar(&algorithm, "hash", "phash", "Perceptual hash to compare: dhash|phash")
+	cmd.Flags().StringVar(&format, "format", "text", "Report format: text|json|html")
+	cmd.Flags().StringVar(&output, "output", "", "Write the report to this file (default: stdout, or .ima/dupes.html for html)")
+	cmd.Flags().BoolVar(&exact, "exact", false, "Find byte-identical copies by SHA-256 instead of look-alikes")
+	cmd.Flags().StringVar(&action, "action", "report", "With --exact, what to do with the copies: report|hardlink|move-to-trash")
+	cmd.Flags().BoolVar(&apply, "apply", false, "Make the changes of --action instead of a dry run")
+	cmd.Flags().BoolVar(&undo, "undo", false, "Revert the last applied --action")
+	return cmd
+}
+
+func run(stdout io.Writer, root string) error {
+	if undo {
+		return runUndo(stdout, root)
+	}
+	if exact {
+		return runExact(stdout, root)
+	}
+	if action != "report" {
+		return fmt.Errorf("--action %s requires --exact", action)
+	}
+	if algorithm != "dhash" && algorithm != "phash" {
+		return fmt.Errorf("unknown hash %q, want dhash or phash", algorithm)
+	}
+	if similarity < 0 || similarity > 100 {
+		return fmt.Errorf("similarity must be between 0 and 100, got %v", similarity)
+	}
+
+	images, err := hashImages(root)
+	if err != nil {
+		return err
+	}
+	groups := group(images, maxDistance())
+
+	switch format {
+	case "text":
+		return report(stdout, output, func(w io.Writer) error { return writeText(w, groups) })
+	case "json":
+		return report(stdout, output, func(w io.Writer) error {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(groups)
+		})
+	case "html":
+		path := output
+		if path == "" {
+			path = state.Path(root, "dupes.html")
+		}
+		if err := writeHTML(path, root, "near-duplicates", "The largest image of each group comes first.", groups); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Wrote %d groups to %s\n", len(groups), path)
+		return nil
+	}
+	return fmt.Errorf("unknown format %q, want text, json or html", format)
+}
+
+// maxDistance converts the similarity threshold to a number of differing
+// hash bits.
+func maxDistance() int {
+	return int((100 - similarity) / 100 * 64)
+}
+
+// hashed is an image of the archive together with its hashes.
+type hashed struct {
+	path string
+	size int64
+	hashes
+}
+
+func (h hashed) hash() uint64 {
+	if algorithm == "dhash" {
+		return h.DHash
+	}
+	return h.PHash
+}
+
+// hashImages returns the hashes of every image of the archive, reusing the
+// cached hashes of files that did not change since the last run.
+func hashImages(root string) ([]hashed, error) {
+	cache := make(map[string]cached)
+	store, err := state.Open(root)
+	if err != nil {
+		log.Printf("Ignoring unreadable state of %s: %v", root, err)
+	} else if err := store.Get(hashBucket, &cache); err != nil {
+		log.Printf("Ignoring unreadable hash cache of %s: %v", root, err)
+	}
+
+	fresh := make(map[string]cached)
+	var todo []string
+	sizes := make(map[string]cached)
+	err = indexer.WalkImages(root, func(rel string, info fs.FileInfo) error {
+		c, ok := cache[rel]
+		if ok && c.Size == info.Size() && c.ModTime.Equal(info.ModTime()) {
+			fresh[rel] = c
+			return nil
+		}
+		sizes[rel] = cached{Size: info.Size(), ModTime: info.ModTime()}
+		todo = append(todo, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Decoding dominates, so hash on every core.
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	jobs := make(chan string)
+	for range runtime.NumCPU() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rel := range jobs {
+				h, err := hashFile(filepath.Join(root, filepath.FromSlash(rel)))
+				if err != nil {
+					log.Printf("Skipping %s: %v", rel, err)
+					continue
+				}
+				mu.Lock()
+				c := sizes[rel]
+				c.hashes = h
+				fresh[rel] = c
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, rel := range todo {
+		jobs <- rel
+	}
+	close(jobs)
+	wg.Wait()
+
+	if store != nil {
+		if err := store.Put(hashBucket, fresh); err != nil {
+			log.Printf("Failed to store hashes: %v", err)
+		} else if err := store.Save(); err != nil {
+			log.Printf("Failed to save state of %s: %v", root, err)
+		}
+	}
+
+	images := make([]hashed, 0, len(fresh))
+	for rel, c := range fresh {
+		images = append(images, hashed{path: rel, size: c.Size, hashes: c.hashes})
+	}
+	slices.SortFunc(images, func(a, b hashed) int { return cmp.Compare(a.path, b.path) })
+	return images, nil
+}
+
+// group gathers images around representatives, and returns the groups with
+// more than one image. Images are taken largest first, as the largest is the
+// one most worth keeping; each image not yet in a group starts one and takes
+// in the remaining images within maxDist bits of it. Every image of a group
+// is thus similar to its first, not merely to some other member.
+func group(images []hashed, maxDist int) []Group {
+	sorted := slices.Clone(images)
+	slices.SortFunc(sorted, func(a, b hashed) int {
+		if c := cmp.Compare(b.Width*b.Height, a.Width*a.Height); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(b.size, a.size); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.path, b.path)
+	})
+
+	grouped := make([]bool, len(sorted))
+	var groups []Group
+	for i, rep := range sorted {
+		if grouped[i] {
+			continue
+		}
+		m := []hashed{rep}
+		for j := i + 1; j < len(sorted); j++ {
+			if !grouped[j] && distance(rep.hash(), sorted[j].hash()) <= maxDist {
+				grouped[j] = true
+				m = append(m, sorted[j])
+			}
+		}
+		if len(m) < 2 {
+			continue
+		}
+
+		var g Group
+		for _, img := range m {
+			g.Images = append(g.Images, Image{
+				Path:       img.path,
+				Width:      img.Width,
+				Height:     img.Height,
+				Size:       img.size,
+				Similarity: 100 * float64(64-distance(rep.hash(), img.hash())) / 64,
+			})
+		}
+		groups = append(groups, g)
+	}
+	slices.SortFunc(groups, func(a, b Group) int { return cmp.Compare(a.Images[0].Path, b.Images[0].Path) })
+	return groups
+}
+
+// report writes a report to the output file if one is set, or to stdout.
+func report(stdout io.Writer, path string, write func(io.Writer) error) error {
+	if path == "" {
+		return write(stdout)
+	}
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return err
+	}
+	return state.WriteFile(path, buf.Bytes())
+}
+
+func writeText(w io.Writer, groups []Group) error {
+	if len(groups) == 0 {
+		_, err := fmt.Fprintln(w, "No duplicates found.")
+		return err
+	}
+	for i, g := range groups {
+		fmt.Fprintf(w, "Group %d (%d images):\n", i+1, len(g.Images))
+		for _, img := range g.Images {
+			fmt.Fprintf(w, "  %s  %dx%d  %s  %.0f%%\n", img.Path, img.Width, img.Height, formatSize(img.Size), img.Similarity)
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d groups of near-duplicates\n", len(groups))
+	return err
+}
+
+// formatSize returns a human readable file size.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
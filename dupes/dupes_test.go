@@ -0,0 +1,147 @@
+package dupes
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/draw"
+)
+
+// scene draws a picture with enough structure for the hashes to tell it
+// apart from others.
+func scene(w, h int, flip bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			fx, fy := float64(x)/float64(w), float64(y)/float64(h)
+			if flip {
+				fx, fy = fy, 1-fx
+			}
+			// A bright sun over a darker horizon.
+			v := 80 + 100*fy
+			if dx, dy := fx-0.3, fy-0.35; dx*dx+dy*dy < 0.03 {
+				v = 240
+			}
+			if fy > 0.7+0.1*fx {
+				v = 40
+			}
+			img.Set(x, y, color.Gray{uint8(v)})
+		}
+	}
+	return img
+}
+
+func writeImage(t *testing.T, path string, img image.Image) {
+	t.Helper()
+	os.MkdirAll(filepath.Dir(path), 0755)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create %s: %v", path, err)
+	}
+	defer f.Close()
+	if filepath.Ext(path) == ".png" {
+		err = png.Encode(f, img)
+	} else {
+		err = jpeg.Encode(f, img, &jpeg.Options{Quality: 80})
+	}
+	if err != nil {
+		t.Fatalf("Failed to encode %s: %v", path, err)
+	}
+}
+
+func TestHashesSurviveResizing(t *testing.T) {
+	big := scene(400, 300, false)
+	small := image.NewRGBA(image.Rect(0, 0, 120, 90))
+	draw.CatmullRom.Scale(small, small.Bounds(), big, big.Bounds(), draw.Src, nil)
+	other := scene(400, 300, true)
+
+	for name, hash := range map[string]func(image.Image) uint64{"dHash": dHash, "pHash": pHash} {
+		if d := distance(hash(big), hash(small)); d > 6 {
+			t.Errorf("%s distance between sizes = %d; want at most 6", name, d)
+		}
+		if d := distance(hash(big), hash(other)); d < 16 {
+			t.Errorf("%s distance between different images = %d; want at least 16", name, d)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	root := t.TempDir()
+	writeImage(t, filepath.Join(root, "2023", "IMG_0001.jpg"), scene(400, 300, false))
+	writeImage(t, filepath.Join(root, "imports", "copy of IMG_0001.png"), scene(200, 150, false))
+	writeImage(t, filepath.Join(root, "2023", "other.jpg"), scene(400, 300, true))
+	// Generated and hidden directories are not part of the archive.
+	writeImage(t, filepath.Join(root, "2023", ".thumbs", "IMG_0001.jpg"), scene(150, 150, false))
+
+	oldFormat, oldAlgorithm, oldSimilarity, oldAction := format, algorithm, similarity, action
+	t.Cleanup(func() { format, algorithm, similarity, action = oldFormat, oldAlgorithm, oldSimilarity, oldAction })
+	format, algorithm, similarity, action = "json", "phash", 90, "report"
+
+	var out bytes.Buffer
+	if err := run(&out, root); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	var groups []Group
+	if err := json.Unmarshal(out.Bytes(), &groups); err != nil {
+		t.Fatalf("Invalid JSON report: %v\n%s", err, out.String())
+	}
+	if len(groups) != 1 || len(groups[0].Images) != 2 {
+		t.Fatalf("groups = %+v; want one pair", groups)
+	}
+	if got := groups[0].Images[0].Path; got != "2023/IMG_0001.jpg" {
+		t.Errorf("first image = %s; want the larger 2023/IMG_0001.jpg", got)
+	}
+	if got := groups[0].Images[1].Path; got != "imports/copy of IMG_0001.png" {
+		t.Errorf("second image = %s; want the smaller copy", got)
+	}
+
+	// The second run reuses cached hashes and writes the review page.
+	format = "html"
+	out.Reset()
+	if err := run(&out, root); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	page, err := os.ReadFile(filepath.Join(root, ".ima", "dupes.html"))
+	if err != nil {
+		t.Fatalf("Failed to read review page: %v", err)
+	}
+	for _, want := range []string{
+		`src="../2023/.thumbs/IMG_0001.jpg"`,
+		`src="../imports/copy%20of%20IMG_0001.png"`,
+		"400&times;300",
+		"1 group of near-duplicates. The largest image",
+	} {
+		if !strings.Contains(string(page), want) {
+			t.Errorf("review page does not contain %q", want)
+		}
+	}
+}
+
+// A chain of similar images does not join images far apart from each other.
+func TestGroupChain(t *testing.T) {
+	oldAlgorithm := algorithm
+	t.Cleanup(func() { algorithm = oldAlgorithm })
+	algorithm = "phash"
+
+	// Each hash is 4 bits away from the next, and a is 8 bits away from c.
+	images := []hashed{
+		{path: "a.jpg", size: 3, hashes: hashes{Width: 30, Height: 30, PHash: 0x00}},
+		{path: "b.jpg", size: 2, hashes: hashes{Width: 20, Height: 20, PHash: 0x0f}},
+		{path: "c.jpg", size: 1, hashes: hashes{Width: 10, Height: 10, PHash: 0xff}},
+	}
+	groups := group(images, 4)
+	if len(groups) != 1 || len(groups[0].Images) != 2 {
+		t.Fatalf("group = %+v; want a single group of a.jpg and b.jpg", groups)
+	}
+	if got := groups[0].Images; got[0].Path != "a.jpg" || got[1].Path != "b.jpg" {
+		t.Errorf("group holds %s and %s; want a.jpg and b.jpg", got[0].Path, got[1].Path)
+	}
+}
@@ -0,0 +1,499 @@
+package dupes
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/image-archive/indexer"
+	"github.com/image-archive/state"
+)
+
+var (
+	exact  bool   // --exact
+	action string // --action
+	apply  bool   // --apply
+	undo   bool   // --undo
+)
+
+// TrashDir is the hidden directory under the archive root receiving
+// removed duplicates and images trashed from the served gallery, laid out
+// like the archive itself.
+const TrashDir = ".trash"
+
+// undoLogFile is the log of applied actions, one JSON logEntry per line,
+// kept in the state directory.
+const undoLogFile = "dedup-log.jsonl"
+
+// logEntry records one applied action so that it can be undone.
+type logEntry struct {
+	Run    string // Time of the run, shared by all its entries
+	Action string // "hardlink" or "move-to-trash"
+	Path   string // Slash path of the duplicate
+	Keep   string // Slash path of the copy that was kept
+	Trash  string `json:",omitempty"` // Slash path of the duplicate in the trash
+	SHA256 string
+}
+
+// file is an image of the archive considered for exact deduplication.
+type file struct {
+	path string
+	info fs.FileInfo
+	sum  string
+}
+
+// exactGroups returns the groups of byte-identical images. Only images
+// sharing their size with another are hashed, and files that are already
+// hard links of each other count once.
+func exactGroups(root string, hashes *state.Hashes) ([]Group, error) {
+	bySize := make(map[int64][]file)
+	err := indexer.WalkImages(root, func(rel string, info fs.FileInfo) error {
+		bySize[info.Size()] = append(bySize[info.Size()], file{path: rel, info: info})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []*file
+	for _, files := range bySize {
+		files = distinctFiles(files)
+		if len(files) < 2 {
+			continue
+		}
+		for i := range files {
+			candidates = append(candidates, &files[i])
+		}
+	}
+
+	var wg sync.WaitGroup
+	jobs := make(chan *file)
+	for range runtime.NumCPU() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range jobs {
+				sum, err := hashes.Sum(f.path, f.info.Size(), f.info.ModTime())
+				if err != nil {
+					log.Printf("Skipping %s: %v", f.path, err)
+					continue
+				}
+				f.sum = sum
+			}
+		}()
+	}
+	for _, f := range candidates {
+		jobs <- f
+	}
+	close(jobs)
+	wg.Wait()
+
+	bySum := make(map[string][]*file)
+	for _, f := range candidates {
+		if f.sum != "" {
+			bySum[f.sum] = append(bySum[f.sum], f)
+		}
+	}
+
+	var groups []Group
+	for sum, files := range bySum {
+		if len(files) < 2 {
+			continue
+		}
+		// Keep the oldest copy, which is usually the original import.
+		slices.SortFunc(files, func(a, b *file) int {
+			if c := a.info.ModTime().Compare(b.info.ModTime()); c != 0 {
+				return c
+			}
+			return cmp.Compare(a.path, b.path)
+		})
+		g := Group{SHA256: sum}
+		for _, f := range files {
+			g.Images = append(g.Images, Image{Path: f.path, Size: f.info.Size(), Similarity: 100})
+		}
+		groups = append(groups, g)
+	}
+	slices.SortFunc(groups, func(a, b Group) int { return cmp.Compare(a.Images[0].Path, b.Images[0].Path) })
+	return groups, nil
+}
+
+// distinctFiles drops files that are hard links of a file listed before.
+func distinctFiles(files []file) []file {
+	var distinct []file
+	for _, f := range files {
+		if !slices.ContainsFunc(distinct, func(d file) bool { return os.SameFile(d.info, f.info) }) {
+			distinct = append(distinct, f)
+		}
+	}
+	return distinct
+}
+
+func runExact(stdout io.Writer, root string) error {
+	if action != "report" && action != "hardlink" && action != "move-to-trash" {
+		return fmt.Errorf("unknown action %q, want report, hardlink or move-to-trash", action)
+	}
+
+	store, err := state.Open(root)
+	if err != nil {
+		log.Printf("Ignoring unreadable state of %s: %v", root, err)
+		store = nil
+	}
+	hashes := state.NewHashes(store, root)
+	defer func() {
+		if err := hashes.Save(); err != nil {
+			log.Printf("Failed to save hashes of %s: %v", root, err)
+		}
+	}()
+
+	groups, err := exactGroups(root, hashes)
+	if err != nil {
+		return err
+	}
+
+	if action == "report" {
+		switch format {
+		case "text":
+			return report(stdout, output, func(w io.Writer) error { return writeExactText(w, groups) })
+		case "json":
+			return report(stdout, output, func(w io.Writer) error {
+				enc := json.NewEncoder(w)
+				enc.SetIndent("", "  ")
+				return enc.Encode(groups)
+			})
+		case "html":
+			path := output
+			if path == "" {
+				path = state.Path(root, "dupes.html")
+			}
+			if err := writeHTML(path, root, "identical copies", "The oldest copy of each group comes first, and is the one kept.", groups); err != nil {
+				return err
+			}
+			fmt.Fprintf(stdout, "Wrote %d groups to %s\n", len(groups), path)
+			return nil
+		}
+		return fmt.Errorf("unknown format %q, want text, json or html", format)
+	}
+
+	return dedupe(stdout, root, groups, hashes)
+}
+
+func writeExactText(w io.Writer, groups []Group) error {
+	if len(groups) == 0 {
+		_, err := fmt.Fprintln(w, "No duplicates found.")
+		return err
+	}
+	var wasted int64
+	for i, g := range groups {
+		fmt.Fprintf(w, "Group %d (%d copies, %s each, sha256 %s):\n", i+1, len(g.Images), formatSize(g.Images[0].Size), g.SHA256[:12])
+		for _, img := range g.Images {
+			fmt.Fprintf(w, "  %s\n", img.Path)
+		}
+		wasted += int64(len(g.Images)-1) * g.Images[0].Size
+	}
+	_, err := fmt.Fprintf(w, "%d groups of exact duplicates, %s reclaimable\n", len(groups), formatSize(wasted))
+	return err
+}
+
+// dedupe replaces every copy but the first of each group by a hard link to
+// it, or moves it to the trash. Nothing changes on disk without --apply.
+func dedupe(stdout io.Writer, root string, groups []Group, hashes *state.Hashes) error {
+	run := time.Now().Format(time.RFC3339Nano)
+	var entries []logEntry
+	var reclaimed int64
+	touched := make(map[string]bool)
+
+	for _, g := range groups {
+		keep := g.Images[0].Path
+		for _, img := range g.Images[1:] {
+			e := logEntry{Run: run, Action: action, Path: img.Path, Keep: keep, SHA256: g.SHA256}
+			if !apply {
+				fmt.Fprintf(stdout, "Would %s %s (same as %s)\n", describe(action), img.Path, keep)
+				reclaimed += img.Size
+				continue
+			}
+
+			// Digests are cached by size and mtime, which miss edits made
+			// within the same second, so the copies are compared again.
+			same, err := sameContent(root, keep, img.Path)
+			if err != nil || !same {
+				if err == nil {
+					err = fmt.Errorf("no longer identical to %s", keep)
+				}
+				log.Printf("Skipping %s: %v", img.Path, err)
+				hashes.Forget(keep)
+				hashes.Forget(img.Path)
+				continue
+			}
+			if action == "hardlink" {
+				err = hardlink(root, keep, img.Path)
+			} else {
+				e.Trash, err = MoveToTrash(root, img.Path)
+				hashes.Forget(img.Path)
+			}
+			if err != nil {
+				log.Printf("Failed to %s %s: %v", describe(action), img.Path, err)
+				continue
+			}
+			fmt.Fprintf(stdout, "%s %s (same as %s)\n", past(action), img.Path, keep)
+			entries = append(entries, e)
+			reclaimed += img.Size
+			touched[path.Dir(img.Path)] = true
+		}
+	}
+
+	if !apply {
+		fmt.Fprintf(stdout, "Dry run: %s would be reclaimed. Run again with --apply to make the changes.\n", formatSize(reclaimed))
+		return nil
+	}
+	if err := appendLog(root, entries); err != nil {
+		return fmt.Errorf("writing undo log: %w", err)
+	}
+	fmt.Fprintf(stdout, "Reclaimed %s. Run with --undo to revert.\n", formatSize(reclaimed))
+
+	// Hard links keep every page intact; moved files must leave their pages.
+	if action == "move-to-trash" {
+		reindex(root, touched)
+	}
+	return nil
+}
+
+func describe(action string) string {
+	if action == "hardlink" {
+		return "hard link"
+	}
+	return "move to trash"
+}
+
+func past(action string) string {
+	if action == "hardlink" {
+		return "Linked"
+	}
+	return "Trashed"
+}
+
+// sameContent reports whether the files at the slash paths a and b hold the
+// same bytes.
+func sameContent(root, a, b string) (bool, error) {
+	fa, err := os.Open(filepath.Join(root, filepath.FromSlash(a)))
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := os.Open(filepath.Join(root, filepath.FromSlash(b)))
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	ra, rb := bufio.NewReader(fa), bufio.NewReader(fb)
+	bufA, bufB := make([]byte, 64*1024), make([]byte, 64*1024)
+	for {
+		na, errA := io.ReadFull(ra, bufA)
+		nb, errB := io.ReadFull(rb, bufB)
+		if !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+		endA := errA == io.EOF || errA == io.ErrUnexpectedEOF
+		endB := errB == io.EOF || errB == io.ErrUnexpectedEOF
+		if endA || endB {
+			return endA && endB, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil {
+			return false, errB
+		}
+	}
+}
+
+// hardlink replaces dup by a hard link to keep. The link is made under a
+// temporary name first, so dup is never missing.
+func hardlink(root, keep, dup string) error {
+	keepPath := filepath.Join(root, filepath.FromSlash(keep))
+	dupPath := filepath.Join(root, filepath.FromSlash(dup))
+
+	tmp := filepath.Join(filepath.Dir(dupPath), ".ima-link-"+filepath.Base(dupPath))
+	os.Remove(tmp)
+	if err := os.Link(keepPath, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dupPath); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// MoveToTrash moves the file at the slash path rel of the archive at root
+// into the trash directory and returns its new slash path. A file already
+// trashed under that path is kept, and the new one numbered as
+// "name~2.jpg".
+func MoveToTrash(root, rel string) (string, error) {
+	dst := path.Join(TrashDir, rel)
+	for i := 2; ; i++ {
+		if _, err := os.Lstat(filepath.Join(root, filepath.FromSlash(dst))); os.IsNotExist(err) {
+			break
+		}
+		ext := path.Ext(rel)
+		dst = path.Join(TrashDir, fmt.Sprintf("%s~%d%s", rel[:len(rel)-len(ext)], i, ext))
+	}
+
+	dstPath := filepath.Join(root, filepath.FromSlash(dst))
+	if err := os.MkdirAll(filepath.Dir(dstPath), os.ModePerm); err != nil {
+		return "", err
+	}
+	return dst, os.Rename(filepath.Join(root, filepath.FromSlash(rel)), dstPath)
+}
+
+// appendLog adds the entries of a run to the undo log.
+func appendLog(root string, entries []logEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	p := state.Path(root, undoLogFile)
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// readLog returns the entries of the undo log.
+func readLog(root string) ([]logEntry, error) {
+	f, err := os.Open(state.Path(root, undoLogFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []logEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e logEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// runUndo reverts the last applied run: trashed files are moved back and
+// hard links are replaced by copies of their own.
+func runUndo(stdout io.Writer, root string) error {
+	entries, err := readLog(root)
+	if os.IsNotExist(err) || err == nil && len(entries) == 0 {
+		fmt.Fprintln(stdout, "Nothing to undo.")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading undo log: %w", err)
+	}
+
+	last := entries[len(entries)-1].Run
+	var kept []logEntry
+	touched := make(map[string]bool)
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if e.Run != last {
+			kept = append(kept, e)
+			continue
+		}
+
+		var err error
+		switch e.Action {
+		case "hardlink":
+			err = unlink(root, e.Path)
+		case "move-to-trash":
+			err = restore(root, e)
+			touched[path.Dir(e.Path)] = true
+		}
+		if err != nil {
+			// Keep the entry so that the undo can be retried.
+			log.Printf("Failed to undo %s of %s: %v", e.Action, e.Path, err)
+			kept = append(kept, e)
+			continue
+		}
+		fmt.Fprintf(stdout, "Restored %s\n", e.Path)
+	}
+	slices.Reverse(kept)
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range kept {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	if err := state.WriteFile(state.Path(root, undoLogFile), buf.Bytes()); err != nil {
+		return fmt.Errorf("writing undo log: %w", err)
+	}
+
+	reindex(root, touched)
+	return nil
+}
+
+// unlink gives the hard link at rel a content of its own again.
+func unlink(root, rel string) error {
+	p := filepath.Join(root, filepath.FromSlash(rel))
+	info, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	if err := state.WriteFile(p, data); err != nil {
+		return err
+	}
+	return os.Chmod(p, info.Mode().Perm())
+}
+
+// restore moves a trashed file back to where it was.
+func restore(root string, e logEntry) error {
+	dst := filepath.Join(root, filepath.FromSlash(e.Path))
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("%s already exists", e.Path)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(root, filepath.FromSlash(e.Trash)), dst)
+}
+
+// reindex regenerates the pages of the directories whose images changed,
+// unless the archive has not been indexed.
+func reindex(root string, dirs map[string]bool) {
+	if _, err := os.Stat(filepath.Join(root, "index.html")); err != nil {
+		return
+	}
+	paths := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		paths = append(paths, filepath.Join(root, filepath.FromSlash(dir)))
+	}
+	indexer.Update(root, paths...)
+}
@@ -0,0 +1,112 @@
+package dupes
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExact(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"2023/a.jpg":           "same bytes",
+		"backup/a copy.jpg":    "same bytes",
+		"backup/b.jpg":         "same size!", // Same size, different content
+		"backup/.thumbs/a.jpg": "same bytes", // Hidden, not part of the archive
+	}
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		os.WriteFile(p, []byte(content), 0644)
+	}
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(root, "2023", "a.jpg"), old, old)
+
+	oldExact, oldAction, oldApply, oldUndo, oldFormat := exact, action, apply, undo, format
+	t.Cleanup(func() { exact, action, apply, undo, format = oldExact, oldAction, oldApply, oldUndo, oldFormat })
+	exact, format = true, "text"
+
+	dedupe := func() string {
+		t.Helper()
+		var out bytes.Buffer
+		if err := run(&out, root); err != nil {
+			t.Fatalf("run failed: %v", err)
+		}
+		return out.String()
+	}
+
+	action, apply = "report", false
+	out := dedupe()
+	if !strings.Contains(out, "2023/a.jpg\n  backup/a copy.jpg\n") || strings.Contains(out, "b.jpg") {
+		t.Errorf("report lists the wrong copies:\n%s", out)
+	}
+
+	format = "html"
+	dedupe()
+	page, _ := os.ReadFile(filepath.Join(root, ".ima", "dupes.html"))
+	if !strings.Contains(string(page), "1 group of identical copies. The oldest copy") {
+		t.Errorf("review page does not describe identical copies:\n%s", page)
+	}
+	format = "text"
+
+	// Dry run by default.
+	action = "move-to-trash"
+	dedupe()
+	if _, err := os.Stat(filepath.Join(root, "backup", "a copy.jpg")); err != nil {
+		t.Fatalf("dry run moved the copy: %v", err)
+	}
+
+	apply = true
+	dedupe()
+	if _, err := os.Stat(filepath.Join(root, ".trash", "backup", "a copy.jpg")); err != nil {
+		t.Errorf("copy was not moved to the trash: %v", err)
+	}
+
+	apply, undo = false, true
+	dedupe()
+	if _, err := os.Stat(filepath.Join(root, "backup", "a copy.jpg")); err != nil {
+		t.Errorf("undo did not restore the copy: %v", err)
+	}
+
+	undo, action, apply = false, "hardlink", true
+	dedupe()
+	a, _ := os.Stat(filepath.Join(root, "2023", "a.jpg"))
+	b, _ := os.Stat(filepath.Join(root, "backup", "a copy.jpg"))
+	if !os.SameFile(a, b) {
+		t.Errorf("copy was not replaced by a hard link")
+	}
+	if out := dedupe(); !strings.Contains(out, "Reclaimed 0 B") {
+		t.Errorf("linked copies are reported again:\n%s", out)
+	}
+
+	apply, undo = false, true
+	dedupe()
+	a, _ = os.Stat(filepath.Join(root, "2023", "a.jpg"))
+	b, _ = os.Stat(filepath.Join(root, "backup", "a copy.jpg"))
+	if os.SameFile(a, b) {
+		t.Errorf("undo did not break the hard link")
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "backup", "a copy.jpg")); string(data) != "same bytes" {
+		t.Errorf("undo changed the content to %q", data)
+	}
+
+	// A copy edited since its digest was cached, keeping its size and
+	// mtime, is left alone.
+	copyPath := filepath.Join(root, "backup", "a copy.jpg")
+	info, _ := os.Stat(copyPath)
+	os.WriteFile(copyPath, []byte("SAME BYTES"), 0644)
+	os.Chtimes(copyPath, info.ModTime(), info.ModTime())
+	undo, apply = false, true
+	dedupe()
+	a, _ = os.Stat(filepath.Join(root, "2023", "a.jpg"))
+	b, _ = os.Stat(copyPath)
+	if os.SameFile(a, b) {
+		t.Errorf("edited copy was replaced by a hard link")
+	}
+	if data, _ := os.ReadFile(copyPath); string(data) != "SAME BYTES" {
+		t.Errorf("edited copy now holds %q", data)
+	}
+}
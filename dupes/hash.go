@@ -0,0 +1,112 @@
+package dupes
+
+import (
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"math/bits"
+	"os"
+	"slices"
+
+	"golang.org/x/image/draw"
+)
+
+// hashes holds the perceptual hashes of one image.
+type hashes struct {
+	Width  int
+	Height int
+	DHash  uint64
+	PHash  uint64
+}
+
+// hashFile decodes the image at path and computes its hashes.
+func hashFile(path string) (hashes, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return hashes{}, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return hashes{}, err
+	}
+	b := img.Bounds()
+	return hashes{
+		Width:  b.Dx(),
+		Height: b.Dy(),
+		DHash:  dHash(img),
+		PHash:  pHash(img),
+	}, nil
+}
+
+// gray scales img down to a w by h grayscale image.
+func gray(img image.Image, w, h int) *image.Gray {
+	dst := image.NewGray(image.Rect(0, 0, w, h))
+	draw.BiLinear.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return dst
+}
+
+// dHash is the difference hash: each bit tells whether a pixel of a 9x8
+// grayscale thumbnail is brighter than its right neighbour.
+func dHash(img image.Image) uint64 {
+	g := gray(img, 9, 8)
+	var h uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			h <<= 1
+			if g.GrayAt(x, y).Y > g.GrayAt(x+1, y).Y {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+// pHash is the DCT hash: each bit tells whether one of the 8x8 lowest
+// frequencies of a 32x32 grayscale thumbnail is above their median.
+func pHash(img image.Image) uint64 {
+	const n = 32
+	g := gray(img, n, n)
+
+	var cos [8][n]float64
+	for u := range cos {
+		for x := range cos[u] {
+			cos[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * n))
+		}
+	}
+
+	var coeffs [64]float64
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for y := 0; y < n; y++ {
+				for x := 0; x < n; x++ {
+					sum += float64(g.GrayAt(x, y).Y) * cos[u][x] * cos[v][y]
+				}
+			}
+			coeffs[v*8+u] = sum
+		}
+	}
+
+	// The DC term only reflects overall brightness; leave it out of the median.
+	sorted := slices.Clone(coeffs[1:])
+	slices.Sort(sorted)
+	median := sorted[len(sorted)/2]
+
+	var h uint64
+	for _, c := range coeffs {
+		h <<= 1
+		if c > median {
+			h |= 1
+		}
+	}
+	return h
+}
+
+// distance returns the number of differing bits of two hashes.
+func distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -0,0 +1,128 @@
+package dupes
+
+import (
+	"bytes"
+	"html/template"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/image-archive/state"
+)
+
+// htmlImage is an image of the review page with links relative to it.
+type htmlImage struct {
+	Image
+	Src      string
+	Thumb    string
+	FileSize string
+}
+
+var reviewTemplate = template.Must(template.New("dupes").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8">
+  <title>Duplicates in {{.Root}}</title>
+  <style>
+    * { box-sizing: border-box; margin: 0; padding: 0; }
+    body { font-family: Arial, sans-serif; padding: 20px; }
+    h1 { margin-bottom: 10px; }
+    section { margin: 20px 0; padding-bottom: 20px; border-bottom: 1px solid #ccc; }
+    .row { display: flex; gap: 15px; overflow-x: auto; margin-top: 10px; }
+    figure { width: 200px; flex: none; }
+    figure img { width: 200px; height: 200px; object-fit: contain; background: #f0f0f0; display: block; }
+    figcaption { font-size: 13px; color: #333; word-break: break-all; margin-top: 5px; }
+    figcaption small { display: block; color: #666; }
+    .keep img { outline: 3px solid #3a3; }
+  </style>
+</head>
+<body>
+  <h1>Duplicates in {{.Root}}</h1>
+  <p>{{len .Groups}} group{{if ne (len .Groups) 1}}s{{end}} of {{.Kind}}. {{.Order}}</p>
+  {{range $i, $g := .Groups}}
+  <section>
+    <h2>Group {{$g.Number}}</h2>
+    <div class="row">
+      {{range $j, $img := $g.Images}}
+      <figure{{if not $j}} class="keep"{{end}}>
+        <a href="{{$img.Src}}"><img loading="lazy" src="{{$img.Thumb}}" alt="{{$img.Path}}"></a>
+        <figcaption>
+          {{$img.Path}}
+          <small>{{if $img.Width}}{{$img.Width}}&times;{{$img.Height}} &middot; {{end}}{{$img.FileSize}}{{if $j}} &middot; {{printf "%.0f" $img.Similarity}}% similar{{end}}</small>
+        </figcaption>
+      </figure>
+      {{end}}
+    </div>
+  </section>
+  {{end}}
+</body>
+</html>
+`))
+
+// writeHTML writes a review page showing each group side by side, with
+// kind naming what the groups are and order saying which image of a group
+// comes first. Links are relative to the page, so it can be opened
+// straight from disk.
+func writeHTML(file, root, kind, order string, groups []Group) error {
+	base := rootLink(file, root)
+
+	type htmlGroup struct {
+		Number int
+		Images []htmlImage
+	}
+	data := struct {
+		Root   string
+		Kind   string
+		Order  string
+		Groups []htmlGroup
+	}{Root: filepath.Base(absPath(root)), Kind: kind, Order: order}
+
+	for i, g := range groups {
+		hg := htmlGroup{Number: i + 1}
+		for _, img := range g.Images {
+			dir, name := path.Split(img.Path)
+			thumb := img.Path
+			if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(dir), ".thumbs", name)); err == nil {
+				thumb = path.Join(dir, ".thumbs", name)
+			}
+			hg.Images = append(hg.Images, htmlImage{
+				Image:    img,
+				Src:      escapePath(path.Join(base, img.Path)),
+				Thumb:    escapePath(path.Join(base, thumb)),
+				FileSize: formatSize(img.Size),
+			})
+		}
+		data.Groups = append(data.Groups, hg)
+	}
+
+	var buf bytes.Buffer
+	if err := reviewTemplate.Execute(&buf, data); err != nil {
+		return err
+	}
+	return state.WriteFile(file, buf.Bytes())
+}
+
+// rootLink returns the slash path from the directory of file to root.
+func rootLink(file, root string) string {
+	rel, err := filepath.Rel(filepath.Dir(absPath(file)), absPath(root))
+	if err != nil {
+		return filepath.ToSlash(absPath(root))
+	}
+	return filepath.ToSlash(rel)
+}
+
+func absPath(p string) string {
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return p
+}
+
+// escapePath percent-encodes a slash path for use in a link, keeping
+// characters such as '#' and '?' in file names part of the path. ':' is
+// encoded so that no segment can be mistaken for a URL scheme.
+func escapePath(p string) string {
+	return strings.ReplaceAll((&url.URL{Path: p}).EscapedPath(), ":", "%3A")
+}
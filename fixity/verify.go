@@ -0,0 +1,152 @@
+// Package fixity checks the images of an archive against the digests
+// recorded in its manifest, to detect bit rot and silent truncation.
+package fixity
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/image-archive/indexer"
+	"github.com/image-archive/state"
+	"github.com/spf13/cobra"
+)
+
+var (
+	quiet  bool // --quiet
+	accept bool // --accept
+)
+
+// Report lists the differences between an archive and its manifest.
+type Report struct {
+	Verified int      // Files whose digest matches
+	Changed  []string // Files whose digest differs
+	Missing  []string // Files in the manifest but not on disk
+	New      []string // Images on disk but not in the manifest
+}
+
+// Corrupt reports whether recorded files changed or disappeared. New
+// files are expected between runs of the indexer and are not corruption.
+func (r Report) Corrupt() bool {
+	return len(r.Changed) > 0 || len(r.Missing) > 0
+}
+
+// NewCommand returns the verify subcommand.
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify [directory]",
+		Short: "Re-hash every image and compare it with " + indexer.ManifestFile,
+		Long: "Re-hash every image of the archive and compare it with the digests recorded\n" +
+			"by indexing with --fixity. Exits with a non-zero status if any recorded file\n" +
+			"changed or is missing. With --accept, the digests of changed and new files\n" +
+			"are recorded and missing files dropped from the manifest instead.",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			root := filepath.Clean(args[0])
+			r, err := Verify(root)
+			if err != nil {
+				return err
+			}
+			r.Print(cmd.OutOrStdout())
+			if accept {
+				return Accept(root, r)
+			}
+			if r.Corrupt() {
+				return fmt.Errorf("%d changed and %d missing files in %s", len(r.Changed), len(r.Missing), root)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Only print problems")
+	cmd.Flags().BoolVar(&accept, "accept", false, "Record the reported changes in the manifest")
+	return cmd
+}
+
+// Verify reads every image of the archive at root in full and compares it
+// with the manifest. Cached digests are deliberately not used.
+func Verify(root string) (Report, error) {
+	var r Report
+
+	recorded, err := indexer.ReadManifest(root)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, fmt.Errorf("%s has no %s; index it with --fixity first", root, indexer.ManifestFile)
+	}
+	if err != nil {
+		return r, err
+	}
+
+	seen := make(map[string]bool, len(recorded))
+	err = indexer.WalkImages(root, func(rel string, _ fs.FileInfo) error {
+		seen[rel] = true
+		want, ok := recorded[rel]
+		if !ok {
+			r.New = append(r.New, rel)
+			return nil
+		}
+		got, err := state.FileSHA256(filepath.Join(root, filepath.FromSlash(rel)))
+		if err != nil {
+			return err
+		}
+		if got == want {
+			r.Verified++
+		} else {
+			r.Changed = append(r.Changed, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return r, err
+	}
+
+	for rel := range recorded {
+		if !seen[rel] {
+			r.Missing = append(r.Missing, rel)
+		}
+	}
+	slices.Sort(r.Missing)
+	return r, nil
+}
+
+// Accept records the differences of the report in the manifest of the
+// archive at root: changed and new files are hashed again and missing files
+// are dropped. Indexing never replaces a recorded digest by itself.
+func Accept(root string, r Report) error {
+	sums, err := indexer.ReadManifest(root)
+	if err != nil {
+		return err
+	}
+	for _, rel := range slices.Concat(r.Changed, r.New) {
+		sum, err := state.FileSHA256(filepath.Join(root, filepath.FromSlash(rel)))
+		if err != nil {
+			return err
+		}
+		sums[rel] = sum
+	}
+	for _, rel := range r.Missing {
+		delete(sums, rel)
+	}
+	return indexer.WriteManifest(root, sums)
+}
+
+// Print writes the report, one problem per line, followed by a summary.
+func (r Report) Print(w io.Writer) {
+	for _, p := range r.Changed {
+		fmt.Fprintf(w, "CHANGED  %s\n", p)
+	}
+	for _, p := range r.Missing {
+		fmt.Fprintf(w, "MISSING  %s\n", p)
+	}
+	if !quiet {
+		for _, p := range r.New {
+			fmt.Fprintf(w, "NEW      %s\n", p)
+		}
+	}
+	if !quiet || r.Corrupt() {
+		fmt.Fprintf(w, "%d verified, %d changed, %d missing, %d new\n", r.Verified, len(r.Changed), len(r.Missing), len(r.New))
+	}
+}
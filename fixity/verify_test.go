@@ -0,0 +1,99 @@
+package fixity
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/image-archive/indexer"
+	"github.com/image-archive/state"
+	"github.com/spf13/cobra"
+)
+
+func TestVerify(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"2023/ok.jpg":     "intact",
+		"2023/rotten.jpg": "original",
+		"2023/gone.jpg":   "deleted later",
+	}
+	sums := make(map[string]string)
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		os.WriteFile(p, []byte(content), 0644)
+		sums[name], _ = state.FileSHA256(p)
+	}
+	if err := indexer.WriteManifest(root, sums); err != nil {
+		t.Fatalf("WriteManifest failed: %v", err)
+	}
+
+	r, err := Verify(root)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if r.Verified != 3 || r.Corrupt() {
+		t.Errorf("Verify of an intact archive = %+v", r)
+	}
+
+	os.WriteFile(filepath.Join(root, "2023", "rotten.jpg"), []byte("originaL"), 0644)
+	os.Remove(filepath.Join(root, "2023", "gone.jpg"))
+	os.WriteFile(filepath.Join(root, "2023", "new.png"), []byte("new"), 0644)
+
+	r, err = Verify(root)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if !r.Corrupt() || r.Verified != 1 ||
+		!slices.Equal(r.Changed, []string{"2023/rotten.jpg"}) ||
+		!slices.Equal(r.Missing, []string{"2023/gone.jpg"}) ||
+		!slices.Equal(r.New, []string{"2023/new.png"}) {
+		t.Errorf("Verify = %+v", r)
+	}
+
+	if err := Accept(root, r); err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	r, err = Verify(root)
+	if err != nil || r.Corrupt() || r.Verified != 3 || len(r.New) != 0 {
+		t.Errorf("Verify after Accept = %+v, %v", r, err)
+	}
+}
+
+func TestVerifyWithoutManifest(t *testing.T) {
+	if _, err := Verify(t.TempDir()); err == nil {
+		t.Errorf("Verify without a manifest succeeded")
+	}
+}
+
+func TestVerifyAfterReindexing(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "2023"), 0755)
+	for _, name := range []string{"kept.jpg", "lost.jpg"} {
+		os.WriteFile(filepath.Join(root, "2023", name), []byte(name), 0644)
+	}
+
+	cmd := &cobra.Command{}
+	indexer.AddFlags(cmd)
+	flags := cmd.PersistentFlags()
+	t.Cleanup(func() {
+		flags.Set("fixity", "false")
+		flags.Set("nothumb", "false")
+	})
+	flags.Set("fixity", "true")
+	flags.Set("nothumb", "true")
+
+	indexer.SplitCreate(root)
+	os.Remove(filepath.Join(root, "2023", "lost.jpg"))
+	indexer.Update(root, filepath.Join(root, "2023"))
+
+	// Re-indexing does not hide the loss.
+	r, err := Verify(root)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if !r.Corrupt() || r.Verified != 1 || !slices.Equal(r.Missing, []string{"2023/lost.jpg"}) {
+		t.Errorf("Verify = %+v", r)
+	}
+}
@@ -0,0 +1,327 @@
+// Package importer copies images from a camera card or any other folder
+// into the archive, filed by capture date.
+package importer
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/image-archive/indexer"
+	"github.com/image-archive/metadata"
+	"github.com/image-archive/naming"
+	"github.com/image-archive/state"
+	"github.com/spf13/cobra"
+)
+
+var (
+	layout string // --layout
+	move   bool   // --move
+	dryRun bool   // --dry-run
+)
+
+// NewCommand returns the import subcommand.
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import <source> [root]",
+		Short: "Copy images into the archive, organised by capture date",
+		Long: "Copy (or move) the images below source into the archive at root, the current\n" +
+			"directory by default. Each copy is verified by checksum, images already in the\n" +
+			"archive are skipped, and the pages of the folders that received images are\n" +
+			"regenerated.",
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			root := "."
+			if len(args) == 2 {
+				root = args[1]
+			}
+			pattern, err := naming.Parse(layout)
+			if err != nil {
+				return err
+			}
+			return Import(cmd.OutOrStdout(), filepath.Clean(args[0]), filepath.Clean(root), pattern)
+		},
+	}
+	cmd.Flags().StringVar(&layout, "layout", "{year}/{year}-{month}-{day}", "Folder of each image below the root, from {year} {month} {day} {hour} {minute} {second} {date:layout} {camera} {name} {ext}")
+	cmd.Flags().BoolVar(&move, "move", false, "Remove each source file once its copy is verified")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only print where each image would go")
+	return cmd
+}
+
+// source is an image found in the import source.
+type source struct {
+	path string
+	info fs.FileInfo
+}
+
+// Import files the images below src into the archive at root, following
+// layout.
+func Import(w io.Writer, src, root string, layout naming.Pattern) error {
+	if within(root, src) {
+		return fmt.Errorf("cannot import %s into %s, which lies inside it", src, root)
+	}
+
+	sources, err := findImages(src)
+	if err != nil {
+		return err
+	}
+	if len(sources) == 0 {
+		fmt.Fprintf(w, "No images found in %s\n", src)
+		return nil
+	}
+
+	store, err := state.Open(root)
+	if err != nil {
+		log.Printf("Ignoring unreadable state of %s: %v", root, err)
+		store = nil
+	}
+	hashes := state.NewHashes(store, root)
+	defer func() {
+		if err := hashes.Save(); err != nil {
+			log.Printf("Failed to save hashes of %s: %v", root, err)
+		}
+	}()
+
+	known, err := archivedSums(root, sources, hashes)
+	if err != nil {
+		return err
+	}
+
+	touched := make(map[string]bool)
+	planned := make(map[string]bool)
+	var imported, skipped, failed int
+	for _, s := range sources {
+		sum, err := state.FileSHA256(s.path)
+		if err != nil {
+			log.Printf("Failed to read %s: %v", s.path, err)
+			failed++
+			continue
+		}
+		if existing, ok := known[sum]; ok {
+			fmt.Fprintf(w, "Skipping %s: already in the archive as %s\n", s.path, existing)
+			skipped++
+			continue
+		}
+
+		dir, err := destDir(layout, s)
+		if err != nil {
+			return err
+		}
+		rel := freeName(root, dir, s.info.Name(), planned)
+		planned[rel] = true
+		known[sum] = rel // Also skips copies within the source itself
+
+		if dryRun {
+			fmt.Fprintf(w, "Would %s %s to %s\n", verb(), s.path, rel)
+			imported++
+			continue
+		}
+		dst := filepath.Join(root, filepath.FromSlash(rel))
+		if err := copyVerified(s.path, dst, sum, s.info.ModTime()); err != nil {
+			log.Printf("Failed to import %s: %v", s.path, err)
+			delete(known, sum)
+			failed++
+			continue
+		}
+		hashes.Record(rel, s.info.Size(), s.info.ModTime(), sum)
+		if move {
+			if err := os.Remove(s.path); err != nil {
+				log.Printf("Failed to remove %s after copying: %v", s.path, err)
+			}
+		}
+		fmt.Fprintf(w, "Imported %s to %s\n", s.path, rel)
+		touched[dir] = true
+		imported++
+	}
+
+	if dryRun {
+		fmt.Fprintf(w, "Dry run: %d to import, %d already in the archive\n", imported, skipped)
+		return nil
+	}
+	fmt.Fprintf(w, "%d imported, %d already in the archive, %d failed\n", imported, skipped, failed)
+
+	reindex(root, touched)
+
+	if failed > 0 {
+		return fmt.Errorf("%d images could not be imported", failed)
+	}
+	return nil
+}
+
+// reindex regenerates the pages of the folders that received images, unless
+// the archive has not been indexed.
+func reindex(root string, touched map[string]bool) {
+	if _, err := os.Stat(filepath.Join(root, "index.html")); err != nil {
+		return
+	}
+	dirs := make([]string, 0, len(touched))
+	for dir := range touched {
+		dirs = append(dirs, filepath.Join(root, filepath.FromSlash(dir)))
+	}
+	indexer.Update(root, dirs...)
+}
+
+func verb() string {
+	if move {
+		return "move"
+	}
+	return "copy"
+}
+
+// within reports whether dir is parent or lies inside it.
+func within(dir, parent string) bool {
+	d, err1 := filepath.Abs(dir)
+	p, err2 := filepath.Abs(parent)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	rel, err := filepath.Rel(p, d)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// findImages returns the images below dir, skipping hidden directories.
+func findImages(dir string) ([]source, error) {
+	var sources []source
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != dir && strings.HasPrefix(d.Name(), ".") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !indexer.IsImageFile(d.Name()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		sources = append(sources, source{path: p, info: info})
+		return nil
+	})
+	return sources, err
+}
+
+// archivedSums returns the slash paths of the archive's images by digest.
+// Only images sharing their size with an image to import can be copies of
+// it, so no others are hashed.
+func archivedSums(root string, sources []source, hashes *state.Hashes) (map[string]string, error) {
+	sizes := make(map[int64]bool, len(sources))
+	for _, s := range sources {
+		sizes[s.info.Size()] = true
+	}
+
+	known := make(map[string]string)
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return known, nil // A new archive
+	}
+	err := indexer.WalkImages(root, func(rel string, info fs.FileInfo) error {
+		if !sizes[info.Size()] {
+			return nil
+		}
+		sum, err := hashes.Sum(rel, info.Size(), info.ModTime())
+		if err != nil {
+			return err
+		}
+		known[sum] = rel
+		return nil
+	})
+	return known, err
+}
+
+// destDir returns the slash path of the folder an image is filed in.
+func destDir(layout naming.Pattern, s source) (string, error) {
+	info, err := metadata.Read(s.path)
+	if err != nil {
+		log.Printf("Failed to read metadata of %s: %v", s.path, err)
+	}
+	taken := info.DateTimeOriginal
+	if taken.IsZero() {
+		taken = s.info.ModTime()
+	}
+
+	ext := path.Ext(s.info.Name())
+	dir := path.Clean(layout.Expand(naming.Fields{
+		Time:   taken,
+		Name:   strings.TrimSuffix(s.info.Name(), ext),
+		Ext:    strings.TrimPrefix(ext, "."),
+		Camera: info.Camera(),
+	}))
+	if dir == ".." || strings.HasPrefix(dir, "../") || path.IsAbs(dir) {
+		return "", fmt.Errorf("layout %q leads outside the archive: %s", layout, dir)
+	}
+	return dir, nil
+}
+
+// freeName returns the slash path of name in dir, numbered as "name-2.jpg"
+// and so on if a file already has that name or another image of this
+// import is planned to.
+func freeName(root, dir, name string, planned map[string]bool) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 2; ; i++ {
+		rel := path.Join(dir, candidate)
+		if _, err := os.Lstat(filepath.Join(root, filepath.FromSlash(rel))); os.IsNotExist(err) && !planned[rel] {
+			return rel
+		}
+		candidate = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+}
+
+// copyVerified copies src to dst through a temporary file, which is only
+// renamed into place once its checksum matches want. The copy keeps the
+// modification time of the source.
+func copyVerified(src, dst, want string, modTime time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".ima-import-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp.Name(), modTime, modTime); err != nil {
+		return err
+	}
+
+	got, err := state.FileSHA256(tmp.Name())
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("checksum mismatch after copying to %s", dst)
+	}
+	return os.Rename(tmp.Name(), dst)
+}
@@ -0,0 +1,94 @@
+package importer
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/image-archive/indexer"
+	"github.com/image-archive/naming"
+)
+
+func writeJPEG(t *testing.T, path string, shade uint8, mtime time.Time) {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = shade
+	}
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, img, nil)
+	os.MkdirAll(filepath.Dir(path), 0755)
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+	os.Chtimes(path, mtime, mtime)
+}
+
+func TestImport(t *testing.T) {
+	card, root := t.TempDir(), t.TempDir()
+	march := time.Date(2023, 3, 14, 10, 0, 0, 0, time.Local)
+	june := time.Date(2023, 6, 1, 10, 0, 0, 0, time.Local)
+
+	writeJPEG(t, filepath.Join(card, "DCIM", "100CANON", "IMG_0001.JPG"), 10, march)
+	writeJPEG(t, filepath.Join(card, "DCIM", "100CANON", "IMG_0002.JPG"), 20, june)
+	writeJPEG(t, filepath.Join(card, "DCIM", "101CANON", "IMG_0001.JPG"), 30, march) // Same name, other image
+	writeJPEG(t, filepath.Join(card, "DCIM", "101CANON", "copy.jpg"), 10, march)     // Same image, other name
+	writeJPEG(t, filepath.Join(root, "old", "already.jpg"), 20, june)                // Imported before
+	indexer.SplitCreate(root)
+
+	layout, err := naming.Parse("{year}/{year}-{month}-{day}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := Import(&out, card, root, layout); err != nil {
+		t.Fatalf("Import failed: %v\n%s", err, out.String())
+	}
+	if !strings.Contains(out.String(), "2 imported, 2 already in the archive, 0 failed") {
+		t.Errorf("unexpected summary:\n%s", out.String())
+	}
+
+	for _, rel := range []string{"2023/2023-03-14/IMG_0001.JPG", "2023/2023-03-14/IMG_0001-2.JPG"} {
+		info, err := os.Stat(filepath.Join(root, filepath.FromSlash(rel)))
+		if err != nil {
+			t.Errorf("%s was not imported: %v", rel, err)
+			continue
+		}
+		if !info.ModTime().Equal(march) {
+			t.Errorf("%s has mtime %v; want the source's %v", rel, info.ModTime(), march)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "2023", "2023-06-01")); !os.IsNotExist(err) {
+		t.Errorf("an image already in the archive was imported again")
+	}
+
+	// The touched folders and their ancestors are indexed.
+	for _, rel := range []string{"index.html", "2023/index.html", "2023/2023-03-14/index.html"} {
+		if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(rel))); err != nil {
+			t.Errorf("%s was not generated: %v", rel, err)
+		}
+	}
+
+	// Importing the card again finds nothing new.
+	out.Reset()
+	if err := Import(&out, card, root, layout); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "0 imported, 4 already in the archive") {
+		t.Errorf("second import was not skipped:\n%s", out.String())
+	}
+}
+
+func TestImportRefusesArchiveInsideSource(t *testing.T) {
+	card := t.TempDir()
+	layout, _ := naming.Parse("{year}")
+	if err := Import(&bytes.Buffer{}, card, filepath.Join(card, "archive"), layout); err == nil {
+		t.Errorf("Import into a folder of the source succeeded")
+	}
+}
@@ -0,0 +1,343 @@
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// albumsSource is the directory at the archive root holding album
+// definitions, one JSON file per album.
+const albumsSource = ".albums"
+
+// albumsDir is the root-level directory holding the album pages.
+const albumsDir = "albums"
+
+// Album is a virtual gallery collecting images from any folder of the
+// archive, defined in .albums/<name>.json. Its page is albums/<name>/. An
+// image is in the album if it is listed in Images or satisfies one of the
+// Match queries, unless an Exclude pattern matches its path.
+type Album struct {
+	Title       string       `json:"title"`
+	Description string       `json:"description,omitempty"`
+	Cover       string       `json:"cover,omitempty"`  // Slash path of the cover image
+	Images      []string     `json:"images,omitempty"` // Slash paths relative to the root
+	Match       []AlbumQuery `json:"match,omitempty"`
+	Exclude     []string     `json:"exclude,omitempty"` // Path patterns
+
+	slug string
+}
+
+// AlbumQuery selects the images satisfying all of its conditions.
+type AlbumQuery struct {
+	Path   string   `json:"path,omitempty"`   // Pattern such as "2024/**/*.jpg"; ** matches any number of folders
+	Tags   []string `json:"tags,omitempty"`   // Keywords the image must all carry
+	From   string   `json:"from,omitempty"`   // First capture date, as 2006-01-02
+	To     string   `json:"to,omitempty"`     // Last capture date, included
+	Camera string   `json:"camera,omitempty"` // Part of the camera name, in any case
+	Rating int      `json:"rating,omitempty"` // Minimum star rating
+}
+
+// loadAlbums reads the album definitions of the archive at root, in order
+// of file name. Broken definitions are logged and skipped.
+func loadAlbums(root string) []*Album {
+	files, _ := filepath.Glob(filepath.Join(root, albumsSource, "*.json"))
+	slices.Sort(files)
+
+	var albums []*Album
+	for _, file := range files {
+		album, err := readAlbum(file)
+		if err != nil {
+			log.Printf("Ignoring album %s: %v", file, err)
+			continue
+		}
+		albums = append(albums, album)
+	}
+	return albums
+}
+
+// readAlbum reads and checks the album definition in file.
+func readAlbum(file string) (*Album, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var album Album
+	if err := json.Unmarshal(data, &album); err != nil {
+		return nil, err
+	}
+
+	album.slug = strings.TrimSuffix(filepath.Base(file), ".json")
+	if album.slug == "" || strings.HasPrefix(album.slug, ".") {
+		return nil, fmt.Errorf("album file names must not start with a dot")
+	}
+	if album.Title == "" {
+		album.Title = album.slug
+	}
+	for _, q := range album.Match {
+		for _, d := range []string{q.From, q.To} {
+			if _, err := time.ParseInLocation(time.DateOnly, d, time.Local); d != "" && err != nil {
+				return nil, fmt.Errorf("date %q is not formatted as 2006-01-02", d)
+			}
+		}
+		if _, err := path.Match(q.Path, ""); err != nil {
+			return nil, fmt.Errorf("pattern %q: %w", q.Path, err)
+		}
+	}
+	for _, pattern := range album.Exclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("pattern %q: %w", pattern, err)
+		}
+	}
+	return &album, nil
+}
+
+// RenameAlbumImages points the images and covers that album definitions of
+// the archive at root list by path at their new names, given the new slash
+// path of every renamed image by its old one. Other fields of the
+// definitions are kept. It returns the names of the rewritten definitions.
+func RenameAlbumImages(root string, renamed map[string]string) ([]string, error) {
+	files, _ := filepath.Glob(filepath.Join(root, albumsSource, "*.json"))
+	slices.Sort(files)
+
+	var changed []string
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return changed, err
+		}
+		var def map[string]json.RawMessage
+		var album Album
+		if json.Unmarshal(data, &def) != nil || json.Unmarshal(data, &album) != nil {
+			continue // Reported when indexing
+		}
+
+		images := slices.Clone(album.Images)
+		for i, p := range images {
+			if to, ok := renamed[p]; ok {
+				images[i] = to
+			}
+		}
+		cover, coverRenamed := renamed[album.Cover]
+		if !coverRenamed && slices.Equal(images, album.Images) {
+			continue
+		}
+		if coverRenamed {
+			def["cover"], _ = json.Marshal(cover)
+		}
+		if len(images) > 0 {
+			def["images"], _ = json.Marshal(images)
+		}
+		data, err = json.MarshalIndent(def, "", "  ")
+		if err != nil {
+			return changed, err
+		}
+		if err := os.WriteFile(file, append(data, '\n'), 0644); err != nil {
+			return changed, err
+		}
+		changed = append(changed, filepath.Base(file))
+	}
+	return changed, nil
+}
+
+// matchPath reports whether the slash path p matches pattern, whose
+// segments follow path.Match and where a "**" segment matches any number
+// of folders.
+func matchPath(pattern, p string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(p, "/"))
+}
+
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
+
+// matches reports whether the image at the slash path p satisfies the
+// query.
+func (q AlbumQuery) matches(p string, e Entry) bool {
+	if q.Path != "" && !matchPath(q.Path, p) {
+		return false
+	}
+	for _, t := range q.Tags {
+		if !slices.ContainsFunc(e.Meta.Keywords, func(k string) bool { return tagSlug(k) == tagSlug(t) }) {
+			return false
+		}
+	}
+	taken := e.Taken()
+	if from, err := time.ParseInLocation(time.DateOnly, q.From, time.Local); err == nil && taken.Before(from) {
+		return false
+	}
+	if to, err := time.ParseInLocation(time.DateOnly, q.To, time.Local); err == nil && !taken.Before(to.AddDate(0, 0, 1)) {
+		return false
+	}
+	if q.Camera != "" && !strings.Contains(strings.ToLower(e.Meta.Camera()), strings.ToLower(q.Camera)) {
+		return false
+	}
+	return e.Meta.Rating >= q.Rating
+}
+
+// contents returns the catalogued images of the album in capture order.
+func (al *Album) contents(c catalog) []datedEntry {
+	listed := make(map[string]bool, len(al.Images))
+	for _, p := range al.Images {
+		listed[path.Clean(p)] = true
+	}
+
+	var entries []datedEntry
+	for dir, dirEntries := range c {
+		for _, e := range dirEntries {
+			p := path.Join(dir, e.Name)
+			in := listed[p] || slices.ContainsFunc(al.Match, func(q AlbumQuery) bool { return q.matches(p, e) })
+			if in && !slices.ContainsFunc(al.Exclude, func(pattern string) bool { return matchPath(pattern, p) }) {
+				entries = append(entries, datedEntry{dir: dir, entry: e, taken: e.Taken()})
+			}
+		}
+	}
+	slices.SortFunc(entries, func(x, y datedEntry) int {
+		if c := x.taken.Compare(y.taken); c != 0 {
+			return c
+		}
+		if c := naturalCompare(x.dir, y.dir); c != 0 {
+			return c
+		}
+		return naturalCompare(x.entry.Name, y.entry.Name)
+	})
+	return entries
+}
+
+// writeAlbums writes the album index and the pages of every album, and
+// removes the pages of albums that no longer exist.
+func (a *archive) writeAlbums() error {
+	if len(a.albums) == 0 {
+		if !isGeneratedDir(a.root, albumsDir) {
+			return nil // Possibly a folder of images called albums
+		}
+		// The last album was deleted.
+		dir := filepath.Join(a.root, albumsDir)
+		if err := removeStale(dir, nil); err != nil {
+			return err
+		}
+		os.Remove(filepath.Join(dir, generatedMarker))
+		os.Remove(dir) // Unless something else was put there
+		return nil
+	}
+	dir, err := claimDir(a.root, albumsDir)
+	if err != nil {
+		return err
+	}
+
+	written := make(map[string]bool)
+	prefix := rootPrefix(albumsDir)
+	var cards []SubDir
+	for _, al := range a.albums {
+		entries := al.contents(a.catalog)
+		files, err := a.writeAlbumPage(al, entries)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			written[f] = true
+		}
+
+		card := SubDir{Name: al.Title, Link: urlPath(al.slug), Count: len(entries)}
+		if len(entries) > 0 {
+			card.Dates = formatDateRange(entries[0].taken, entries[len(entries)-1].taken)
+			first := entries[0]
+			card.Cover = urlPath(thumbPath(path.Join(prefix, first.dir, first.entry.Name)))
+		}
+		if al.Cover != "" {
+			card.Cover = urlPath(thumbPath(path.Join(prefix, path.Clean(al.Cover))))
+		}
+		cards = append(cards, card)
+	}
+
+	file := filepath.Join(dir, "index.html")
+	data := PageData{
+		Title:       "Albums",
+		Root:        prefix,
+		Breadcrumbs: a.sectionCrumbs(albumsDir, []string{"Albums"}),
+		Sections:    a.sections(albumsDir),
+		SubDirs:     cards,
+		CurrentPath: dir,
+		Thumbs:      !noThumb,
+		Search:      searchEnabled,
+	}
+	if a.tree != nil {
+		data.Tree = []TreeItem{treeView(a.tree, albumsDir)}
+	}
+	if err := writePage(file, data); err != nil {
+		return err
+	}
+	written[file] = true
+	return removeStale(dir, written)
+}
+
+// writeAlbumPage writes the pages of one album and returns the paths
+// written.
+func (a *archive) writeAlbumPage(al *Album, entries []datedEntry) ([]string, error) {
+	rel := path.Join(albumsDir, al.slug)
+	dir := filepath.Join(a.root, filepath.FromSlash(rel))
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	prefix := rootPrefix(rel)
+	images := make([]Image, len(entries))
+	for i, de := range entries {
+		images[i] = a.entryImage(prefix, de.dir, de.entry)
+	}
+	if sortDesc {
+		slices.Reverse(images)
+	}
+
+	trail := a.sectionCrumbs(rel, []string{"Albums", al.Title})
+
+	var files []string
+	pages, pagers := paginate(images)
+	for i, pageImages := range pages {
+		data := PageData{
+			Title:       al.Title,
+			Description: al.Description,
+			Root:        prefix,
+			Breadcrumbs: trail,
+			Sections:    a.sections(rel),
+			Images:      pageImages,
+			CurrentPath: dir,
+			Thumbs:      !noThumb,
+			Search:      searchEnabled,
+			Pager:       pagers[i],
+		}
+		if a.tree != nil {
+			data.Tree = []TreeItem{treeView(a.tree, rel)}
+		}
+
+		file := filepath.Join(dir, pageFileName(i+1))
+		if err := writePage(file, data); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
@@ -0,0 +1,134 @@
+package indexer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"2024/*.jpg", "2024/a.jpg", true},
+		{"2024/*.jpg", "2024/trip/a.jpg", false},
+		{"2024/**/*.jpg", "2024/a.jpg", true},
+		{"2024/**/*.jpg", "2024/trip/day-1/a.jpg", true},
+		{"**/rejects/**", "2024/rejects/a.jpg", true},
+		{"**/rejects/**", "2024/a.jpg", false},
+		{"**", "a.jpg", true},
+	}
+	for _, test := range tests {
+		if got := matchPath(test.pattern, test.path); got != test.want {
+			t.Errorf("matchPath(%q, %q) = %v; want %v", test.pattern, test.path, got, test.want)
+		}
+	}
+}
+
+func TestAlbums(t *testing.T) {
+	tempDir := t.TempDir()
+	files := map[string]time.Time{
+		"2023/trip/a.jpg":         time.Date(2023, 8, 1, 9, 0, 0, 0, time.Local),
+		"2024/trip/b.jpg":         time.Date(2024, 3, 1, 9, 0, 0, 0, time.Local),
+		"2024/trip/rejects/c.jpg": time.Date(2024, 3, 2, 9, 0, 0, 0, time.Local),
+		"2024/home/d.jpg":         time.Date(2024, 12, 31, 23, 0, 0, 0, time.Local),
+		"2025/e.jpg":              time.Date(2025, 1, 1, 9, 0, 0, 0, time.Local),
+	}
+	for name, mtime := range files {
+		p := filepath.Join(tempDir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		os.WriteFile(p, []byte{}, 0644)
+		os.Chtimes(p, mtime, mtime)
+	}
+	writeKeywords(t, filepath.Join(tempDir, "2025", "e.jpg"), "Best")
+
+	albums := filepath.Join(tempDir, albumsSource)
+	os.MkdirAll(albums, 0755)
+	os.WriteFile(filepath.Join(albums, "best-of-2024.json"), []byte(`{
+		"title": "Best of 2024",
+		"description": "Our favourite shots",
+		"images": ["2023/trip/a.jpg"],
+		"match": [{"from": "2024-01-01", "to": "2024-12-31"}, {"tags": ["best"]}],
+		"exclude": ["**/rejects/**"]
+	}`), 0644)
+	os.WriteFile(filepath.Join(albums, "broken.json"), []byte(`{"title": `), 0644)
+
+	oldNoThumb := noThumb
+	t.Cleanup(func() { noThumb = oldNoThumb })
+	noThumb = true
+
+	SplitCreate(tempDir)
+
+	read := func(rel string) string {
+		t.Helper()
+		content, err := os.ReadFile(filepath.Join(tempDir, filepath.FromSlash(rel)))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", rel, err)
+		}
+		return string(content)
+	}
+
+	index := read("albums/index.html")
+	if !strings.Contains(index, `href="best-of-2024/index.html"`) || !strings.Contains(index, "4 photos") {
+		t.Errorf("albums/index.html does not list the album with 4 photos")
+	}
+	if strings.Contains(index, "broken") {
+		t.Errorf("albums/index.html lists a broken album")
+	}
+
+	album := read("albums/best-of-2024/index.html")
+	for _, want := range []string{
+		"<h1>Best of 2024</h1>",
+		"Our favourite shots",
+		`src="../../2023/trip/a.jpg"`,
+		`src="../../2024/trip/b.jpg"`,
+		`src="../../2024/home/d.jpg"`,
+		`src="../../2025/e.jpg"`,
+		`href="../../2024/home/index.html#modal-` + imageID("d.jpg") + `"`,
+	} {
+		if !strings.Contains(album, want) {
+			t.Errorf("album page does not contain %q", want)
+		}
+	}
+	if strings.Contains(album, "c.jpg") {
+		t.Errorf("album page lists an excluded image")
+	}
+	if !strings.Contains(read("index.html"), `<a href="albums/index.html">Albums</a>`) {
+		t.Errorf("root page does not link to the albums")
+	}
+
+	// Deleting the definition removes the album's pages on the next update.
+	os.Remove(filepath.Join(albums, "best-of-2024.json"))
+	Update(tempDir, albums)
+	if _, err := os.Stat(filepath.Join(tempDir, albumsDir, "best-of-2024")); !os.IsNotExist(err) {
+		t.Errorf("pages of a deleted album were not removed")
+	}
+	if _, err := os.Stat(filepath.Join(albums, "index.html")); !os.IsNotExist(err) {
+		t.Errorf("album definitions were indexed as a folder")
+	}
+}
+
+// Without album definitions, albums/ is an ordinary folder of images.
+func TestAlbumsFolderWithoutDefinitions(t *testing.T) {
+	tempDir := t.TempDir()
+	os.MkdirAll(filepath.Join(tempDir, albumsDir), 0755)
+	writeTestImage(t, filepath.Join(tempDir, albumsDir, "a.jpg"), 10, 10)
+
+	oldNoThumb := noThumb
+	t.Cleanup(func() { noThumb = oldNoThumb })
+	noThumb = true
+
+	SplitCreate(tempDir)
+	SplitCreate(tempDir)
+	root, _ := os.ReadFile(filepath.Join(tempDir, "index.html"))
+	if !strings.Contains(string(root), `href="albums/index.html"`) {
+		t.Errorf("root page does not list the albums folder")
+	}
+	page, err := os.ReadFile(filepath.Join(tempDir, albumsDir, "index.html"))
+	if err != nil || !strings.Contains(string(page), "a.jpg") {
+		t.Errorf("page of the albums folder was removed or replaced (%v)", err)
+	}
+}
@@ -0,0 +1,243 @@
+package indexer
+
+import (
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/image-archive/metadata"
+	"github.com/image-archive/state"
+)
+
+// catalogBucket is the state bucket holding the catalog.
+const catalogBucket = "catalog"
+
+// Entry is what the archive-wide pages know about one image.
+type Entry struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	Page    int      // Page of its directory the image is shown on
+	ID      string   // Modal ID on that page
+	Preview bool     // Shown through its screen-size preview
+	Formats []string `json:",omitempty"` // Additional formats of its thumbnail
+
+	// Data URI of its placeholder, kept until the image changes.
+	Placeholder string `json:",omitempty"`
+	Meta        metadata.Info
+	MetaVersion int `json:",omitempty"` // metadata.Version Meta was read with
+
+	// Modification time of the XMP sidecar Meta was read with, zero if
+	// the image had none.
+	SidecarTime time.Time `json:",omitzero"`
+}
+
+// Taken returns the capture time of the image, or its modification time
+// when the file has no EXIF date.
+func (e Entry) Taken() time.Time {
+	if !e.Meta.DateTimeOriginal.IsZero() {
+		return e.Meta.DateTimeOriginal
+	}
+	return e.ModTime
+}
+
+// catalog maps the slash path of each directory, relative to the archive
+// root, to the images shown on its pages. It is kept in the state store so
+// that archive-wide pages can be rebuilt after the watcher re-indexes a
+// single directory, and so that metadata is only read from files that
+// changed.
+type catalog map[string][]Entry
+
+// loadCatalog reads the catalog from the state store of the archive.
+func (a *archive) loadCatalog() {
+	a.catalog = make(catalog)
+	a.known = make(map[string]Entry)
+	a.visited = make(map[string]bool)
+
+	store, err := state.Open(a.root)
+	if err != nil {
+		log.Printf("Ignoring unreadable state of %s: %v", a.root, err)
+		return
+	}
+	a.store = store
+
+	if err := store.Get(catalogBucket, &a.catalog); err != nil {
+		log.Printf("Ignoring unreadable catalog of %s: %v", a.root, err)
+		a.catalog = make(catalog)
+	}
+	for rel, entries := range a.catalog {
+		for _, e := range entries {
+			a.known[path.Join(rel, e.Name)] = e
+		}
+	}
+}
+
+// CatalogedOfSize returns the slash paths of the images of the archive at
+// root that were size bytes long when last indexed, as candidates for
+// being the same as a file of that size without walking the archive.
+func CatalogedOfSize(root string, size int64) ([]string, error) {
+	store, err := state.Open(root)
+	if err != nil {
+		return nil, err
+	}
+	var c catalog
+	if err := store.Get(catalogBucket, &c); err != nil {
+		return nil, err
+	}
+	var paths []string
+	for dir, entries := range c {
+		for _, e := range entries {
+			if e.Size == size {
+				paths = append(paths, path.Join(dir, e.Name))
+			}
+		}
+	}
+	slices.Sort(paths)
+	return paths, nil
+}
+
+// imageInfo returns the metadata of an image in dir, read from the file
+// unless the catalog already holds it for the same size and mtime, and the
+// same XMP sidecar.
+func (a *archive) imageInfo(dir string, img Image) metadata.Info {
+	key := path.Join(relPath(a.root, dir), img.Name)
+	p := filepath.Join(dir, img.Name)
+	var sidecarTime time.Time
+	if sidecar := metadata.Sidecar(p); sidecar != "" {
+		if info, err := os.Stat(sidecar); err == nil {
+			sidecarTime = info.ModTime()
+		}
+	}
+	if e, ok := a.known[key]; ok && e.Size == img.Size && e.ModTime.Equal(img.ModTime) && e.SidecarTime.Equal(sidecarTime) && e.MetaVersion == metadata.Version {
+		return e.Meta
+	}
+
+	info, err := metadata.Read(p)
+	if err != nil {
+		log.Printf("Failed to read metadata of %s: %v", p, err)
+	}
+	a.known[key] = Entry{Name: img.Name, Size: img.Size, ModTime: img.ModTime, Meta: info, MetaVersion: metadata.Version, SidecarTime: sidecarTime}
+	return info
+}
+
+// captureTime returns the capture time of an image in dir, falling back to
+// its modification time.
+func (a *archive) captureTime(dir string, img Image) time.Time {
+	if t := a.imageInfo(dir, img).DateTimeOriginal; !t.IsZero() {
+		return t
+	}
+	return img.ModTime
+}
+
+// record stores the paged images of the directory rel in the catalog.
+func (a *archive) record(rel string, pages [][]Image) {
+	var entries []Entry
+	for i, images := range pages {
+		for _, img := range images {
+			e := a.known[path.Join(rel, img.Name)]
+			e.Page = i + 1
+			e.ID = img.ID
+			e.Preview = img.Preview != ""
+			e.Formats = img.Formats
+			entries = append(entries, e)
+		}
+	}
+	a.catalog[rel] = entries
+	a.visited[rel] = true
+}
+
+// prune drops catalog entries for directories at or below rel that the
+// last walk did not visit, because they have been removed or hidden.
+func (a *archive) prune(rel string) {
+	for dir := range a.catalog {
+		below := rel == "." || dir == rel || strings.HasPrefix(dir, rel+"/")
+		if below && !a.visited[dir] {
+			delete(a.catalog, dir)
+		}
+	}
+}
+
+// finish writes the archive-wide files derived from the catalog and saves
+// the catalog for the next run.
+func (a *archive) finish() {
+	// Archive-wide pages leave out protected folders; the fixity manifest
+	// still covers them.
+	full := a.catalog
+	a.catalog = a.publicCatalog()
+	if searchEnabled {
+		if err := a.writeSearchIndex(); err != nil {
+			log.Printf("Failed to write search index: %v", err)
+		}
+	}
+	if timelineEnabled {
+		if err := a.writeTimeline(); err != nil {
+			log.Printf("Failed to write timeline: %v", err)
+		}
+	}
+	if err := a.writeAlbums(); err != nil {
+		log.Printf("Failed to write albums: %v", err)
+	}
+	if tagsEnabled {
+		if err := a.writeTags(); err != nil {
+			log.Printf("Failed to write tags: %v", err)
+		}
+	}
+	if mapEnabled {
+		if err := a.writeMap(); err != nil {
+			log.Printf("Failed to write map: %v", err)
+		}
+	}
+	a.catalog = full
+	if fixityEnabled {
+		if err := a.writeManifest(); err != nil {
+			log.Printf("Failed to write fixity manifest: %v", err)
+		}
+	}
+	if err := a.writeExcludes(); err != nil {
+		log.Printf("Failed to write publishing exclusions: %v", err)
+	}
+
+	if a.store == nil {
+		return
+	}
+	if err := a.store.Put(catalogBucket, a.catalog); err != nil {
+		log.Printf("Failed to store catalog: %v", err)
+		return
+	}
+	if err := a.store.Save(); err != nil {
+		log.Printf("Failed to save state of %s: %v", a.root, err)
+	}
+}
+
+// entryImage returns the gallery entry for a catalogued image shown on a
+// page outside its own directory. prefix is the link from that page back
+// to the archive root, and the image links back to its folder page.
+func (a *archive) entryImage(prefix, dir string, e Entry) Image {
+	p := path.Join(prefix, dir, e.Name)
+	folder := dir
+	if dir == "." {
+		folder = a.name
+	}
+	img := Image{
+		ID:          imageID(path.Join(dir, e.Name)),
+		Name:        e.Name,
+		Src:         urlPath(p),
+		Thumb:       urlPath(thumbPath(p)),
+		Formats:     e.Formats,
+		Placeholder: e.Placeholder,
+		Size:        e.Size,
+		ModTime:     e.ModTime,
+		Folder:      urlPath(path.Join(prefix, dir, pageFileName(e.Page))) + "#modal-" + e.ID,
+		FolderName:  folder,
+	}
+	if e.Preview {
+		img.Preview = urlPath(previewPath(p))
+	}
+	img.describe(e.Meta)
+	img.Tags = tagLinks(prefix, e.Meta.Keywords)
+	return img
+}
@@ -0,0 +1,157 @@
+package indexer
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// coverMarker is the file that names a folder's cover image, relative to
+// the folder itself, e.g. "day-2/IMG_0042.jpg".
+const coverMarker = ".cover"
+
+// dirStats summarises the images in a directory and all its descendants.
+type dirStats struct {
+	count       int
+	first, last time.Time // Range of photoTime over all images
+	cover       string    // Slash path of the cover image relative to the directory
+}
+
+func (s *dirStats) addTime(t time.Time) {
+	if s.first.IsZero() || t.Before(s.first) {
+		s.first = t
+	}
+	if t.After(s.last) {
+		s.last = t
+	}
+}
+
+// dirStats returns the statistics for dir, computing and memoising them for
+// the whole subtree on first use. Subfolders protected by another password
+// than dir are left out, so that the pages showing the statistics do not
+// reveal them.
+func (a *archive) dirStats(dir string) dirStats {
+	if s, ok := a.stats[dir]; ok {
+		return s
+	}
+
+	var s dirStats
+	var images []Image
+	var subs []string
+	rel := relPath(a.root, dir)
+	lock := a.lockOf(rel)
+
+	items, _ := os.ReadDir(dir)
+	for _, item := range items {
+		if item.IsDir() {
+			if !skipDir(a.root, rel, item.Name()) && a.lockOf(path.Join(rel, item.Name())) == lock {
+				subs = append(subs, item.Name())
+			}
+			continue
+		}
+		if !IsImageFile(item.Name()) {
+			continue
+		}
+		info, err := item.Info()
+		if err != nil {
+			continue
+		}
+		img := Image{Name: item.Name(), Size: info.Size(), ModTime: info.ModTime()}
+		img.Taken = a.captureTime(dir, img)
+		images = append(images, img)
+		s.count++
+		s.addTime(img.Taken)
+	}
+
+	// The first image in page order is the default cover.
+	sortImages(dir, images)
+	if len(images) > 0 {
+		s.cover = images[0].Name
+	}
+
+	sortDirs(subs,
+		func(name string) string { return name },
+		func(name string) string { return filepath.Join(dir, name) },
+		a.newestPhoto)
+	for _, sub := range subs {
+		cs := a.dirStats(filepath.Join(dir, sub))
+		s.count += cs.count
+		if cs.count > 0 {
+			s.addTime(cs.first)
+			s.addTime(cs.last)
+		}
+		if s.cover == "" && cs.cover != "" {
+			s.cover = path.Join(sub, cs.cover)
+		}
+	}
+
+	if cover, ok := readCoverMarker(dir); ok && a.lockOf(path.Join(rel, path.Dir(cover))) == lock {
+		s.cover = cover
+	}
+
+	if a.stats == nil {
+		a.stats = make(map[string]dirStats)
+	}
+	a.stats[dir] = s
+	return s
+}
+
+// readCoverMarker returns the image named by the cover marker in dir, if
+// the marker exists and points at an image inside dir.
+func readCoverMarker(dir string) (string, bool) {
+	data, err := os.ReadFile(filepath.Join(dir, coverMarker))
+	if err != nil {
+		return "", false
+	}
+
+	cover := path.Clean(filepath.ToSlash(strings.TrimSpace(string(data))))
+	if cover == "." || path.IsAbs(cover) || strings.HasPrefix(cover, "../") || !IsImageFile(cover) {
+		return "", false
+	}
+	if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(cover))); err != nil {
+		return "", false
+	}
+	return cover, true
+}
+
+// fillSubDir adds the cover, image count and date range of a subdirectory.
+func (a *archive) fillSubDir(dir string, sub *SubDir) {
+	// Nothing is shown of a folder protected by another password than this
+	// page's.
+	rel := relPath(a.root, dir)
+	if l := a.lockOf(path.Join(rel, sub.Name)); l != "" && l != a.lockOf(rel) {
+		sub.Locked = true
+		return
+	}
+	s := a.dirStats(filepath.Join(dir, sub.Name))
+	sub.Count = s.count
+	sub.Dates = formatDateRange(s.first, s.last)
+	if s.cover != "" {
+		sub.Cover = urlPath(path.Join(sub.Name, thumbPath(s.cover)))
+	}
+}
+
+// thumbPath returns the link to the thumbnail of the image at the slash
+// path p, or to the image itself when thumbnails are disabled.
+func thumbPath(p string) string {
+	if noThumb {
+		return p
+	}
+	return path.Join(path.Dir(p), ".thumbs", path.Base(p))
+}
+
+// formatDateRange renders a compact human readable range such as
+// "Mar 2023" or "Mar 2023 – Jun 2024".
+func formatDateRange(first, last time.Time) string {
+	switch {
+	case first.IsZero():
+		return ""
+	case first.Year() == last.Year() && first.YearDay() == last.YearDay():
+		return first.Format("2 Jan 2006")
+	case first.Year() == last.Year() && first.Month() == last.Month():
+		return first.Format("Jan 2006")
+	}
+	return first.Format("Jan 2006") + " – " + last.Format("Jan 2006")
+}
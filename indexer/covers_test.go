@@ -0,0 +1,97 @@
+package indexer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDirStats(t *testing.T) {
+	tempDir := t.TempDir()
+	march := time.Date(2023, 3, 14, 12, 0, 0, 0, time.Local)
+	june := time.Date(2023, 6, 1, 12, 0, 0, 0, time.Local)
+
+	files := map[string]time.Time{
+		"2023/march/IMG_2.jpg":  march,
+		"2023/march/IMG_10.jpg": march,
+		"2023/june/a.png":       june,
+	}
+	for name, mtime := range files {
+		p := filepath.Join(tempDir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		os.WriteFile(p, []byte{}, 0644)
+		os.Chtimes(p, mtime, mtime)
+	}
+
+	withSort(t, SortNatural, false)
+
+	a := newArchive(tempDir)
+	s := a.dirStats(filepath.Join(tempDir, "2023"))
+	if s.count != 3 {
+		t.Errorf("count = %d; want 3", s.count)
+	}
+	if !s.first.Equal(march) || !s.last.Equal(june) {
+		t.Errorf("range = %v – %v; want %v – %v", s.first, s.last, march, june)
+	}
+	if s.cover != "june/a.png" {
+		t.Errorf("cover = %q; want %q", s.cover, "june/a.png")
+	}
+
+	// A marker file overrides the default cover.
+	os.WriteFile(filepath.Join(tempDir, "2023", coverMarker), []byte("march/IMG_10.jpg\n"), 0644)
+	a = newArchive(tempDir)
+	if s := a.dirStats(filepath.Join(tempDir, "2023")); s.cover != "march/IMG_10.jpg" {
+		t.Errorf("cover with marker = %q; want %q", s.cover, "march/IMG_10.jpg")
+	}
+
+	// The parent page shows a card with the cover thumbnail and totals.
+	if err := a.generateIndex(tempDir); err != nil {
+		t.Fatalf("generateIndex failed: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(tempDir, "index.html"))
+	if err != nil {
+		t.Fatalf("Failed to read index.html: %v", err)
+	}
+	for _, want := range []string{
+		`src="2023/march/.thumbs/IMG_10.jpg"`,
+		"3 photos &middot; Mar 2023 – Jun 2023",
+	} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("index.html does not contain %q", want)
+		}
+	}
+}
+
+func TestReadCoverMarkerRejectsEscapes(t *testing.T) {
+	tempDir := t.TempDir()
+	os.WriteFile(filepath.Join(tempDir, "outside.jpg"), []byte{}, 0644)
+	dir := filepath.Join(tempDir, "album")
+	os.Mkdir(dir, 0755)
+
+	for _, marker := range []string{"../outside.jpg", "/etc/passwd", "missing.jpg", "notes.txt"} {
+		os.WriteFile(filepath.Join(dir, coverMarker), []byte(marker), 0644)
+		if cover, ok := readCoverMarker(dir); ok {
+			t.Errorf("readCoverMarker accepted %q as %q", marker, cover)
+		}
+	}
+}
+
+func TestFormatDateRange(t *testing.T) {
+	day := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 0, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		first, last time.Time
+		want        string
+	}{
+		{time.Time{}, time.Time{}, ""},
+		{day(2023, 3, 14), day(2023, 3, 14), "14 Mar 2023"},
+		{day(2023, 3, 1), day(2023, 3, 31), "Mar 2023"},
+		{day(2023, 3, 1), day(2024, 6, 1), "Mar 2023 – Jun 2024"},
+	}
+	for _, test := range tests {
+		if got := formatDateRange(test.first, test.last); got != test.want {
+			t.Errorf("formatDateRange(%v, %v) = %q; want %q", test.first, test.last, got, test.want)
+		}
+	}
+}
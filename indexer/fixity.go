@@ -0,0 +1,102 @@
+package indexer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/image-archive/state"
+)
+
+var fixityEnabled bool // --fixity
+
+// ManifestFile is the BagIt-style payload manifest at the archive root,
+// listing the SHA-256 digest of every original image.
+const ManifestFile = "manifest-sha256.txt"
+
+// ReadManifest returns the digests of the manifest of the archive at root
+// by slash path. A missing manifest yields an error satisfying
+// os.IsNotExist.
+func ReadManifest(root string) (map[string]string, error) {
+	f, err := os.Open(filepath.Join(root, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		sum, p, ok := strings.Cut(line, " ")
+		if !ok || len(sum) != 64 {
+			return nil, fmt.Errorf("%s:%d: malformed line", ManifestFile, n)
+		}
+		sums[decodeManifestPath(strings.TrimLeft(p, " "))] = strings.ToLower(sum)
+	}
+	return sums, scanner.Err()
+}
+
+// WriteManifest replaces the manifest of the archive at root.
+func WriteManifest(root string, sums map[string]string) error {
+	paths := make([]string, 0, len(sums))
+	for p := range sums {
+		paths = append(paths, p)
+	}
+	slices.Sort(paths)
+
+	var b strings.Builder
+	for _, p := range paths {
+		fmt.Fprintf(&b, "%s  %s\n", sums[p], encodeManifestPath(p))
+	}
+	return writeIfChanged(filepath.Join(root, ManifestFile), []byte(b.String()))
+}
+
+// BagIt encodes line breaks and percent signs in manifest paths.
+var (
+	manifestEncoder = strings.NewReplacer("%", "%25", "\n", "%0A", "\r", "%0D")
+	manifestDecoder = strings.NewReplacer("%25", "%", "%0A", "\n", "%0D", "\r")
+)
+
+func encodeManifestPath(p string) string { return manifestEncoder.Replace(p) }
+func decodeManifestPath(p string) string { return manifestDecoder.Replace(p) }
+
+// writeManifest adds the new images of the directories indexed during this
+// run to the manifest. Recorded digests are never replaced or dropped, even
+// when the file looks modified or is gone, so that rot and losses show up
+// when verifying; changes are accepted with verify --accept.
+func (a *archive) writeManifest() error {
+	sums, err := ReadManifest(a.root)
+	if errors.Is(err, os.ErrNotExist) {
+		sums = make(map[string]string)
+	} else if err != nil {
+		return err
+	}
+
+	hashes := state.NewHashes(a.store, a.root)
+	for dir := range a.visited {
+		for _, e := range a.catalog[dir] {
+			p := path.Join(dir, e.Name)
+			if _, ok := sums[p]; ok {
+				continue
+			}
+			sum, err := hashes.Sum(p, e.Size, e.ModTime)
+			if err != nil {
+				return fmt.Errorf("hashing %s: %w", p, err)
+			}
+			sums[p] = sum
+		}
+	}
+	if err := hashes.Save(); err != nil {
+		return err
+	}
+	return WriteManifest(a.root, sums)
+}
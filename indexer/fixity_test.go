@@ -0,0 +1,59 @@
+package indexer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFixityManifest(t *testing.T) {
+	tempDir := t.TempDir()
+	os.MkdirAll(filepath.Join(tempDir, "2023"), 0755)
+	photo := filepath.Join(tempDir, "2023", "a 100%.jpg")
+	os.WriteFile(photo, []byte("hello"), 0644)
+	os.WriteFile(filepath.Join(tempDir, "notes.txt"), []byte("not an image"), 0644)
+
+	oldFixity, oldNoThumb := fixityEnabled, noThumb
+	t.Cleanup(func() { fixityEnabled, noThumb = oldFixity, oldNoThumb })
+	fixityEnabled, noThumb = true, true
+
+	SplitCreate(tempDir)
+
+	const hello = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	content, err := os.ReadFile(filepath.Join(tempDir, ManifestFile))
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	if want := hello + "  2023/a 100%25.jpg\n"; string(content) != want {
+		t.Errorf("manifest = %q; want %q", content, want)
+	}
+	sums, err := ReadManifest(tempDir)
+	if err != nil || sums["2023/a 100%.jpg"] != hello {
+		t.Errorf("ReadManifest = %v, %v; want the digest of a 100%%.jpg", sums, err)
+	}
+
+	// Content that changes behind an unchanged size and mtime is rot, and
+	// must not overwrite the recorded digest.
+	info, _ := os.Stat(photo)
+	os.WriteFile(photo, []byte("hellp"), 0644)
+	os.Chtimes(photo, info.ModTime(), info.ModTime())
+	SplitCreate(tempDir)
+	if sums, _ := ReadManifest(tempDir); sums["2023/a 100%.jpg"] != hello {
+		t.Errorf("re-indexing replaced the recorded digest of a rotten file")
+	}
+
+	// Nor does an edit that shows in the size and mtime.
+	os.WriteFile(photo, []byte("hello, world"), 0644)
+	SplitCreate(tempDir)
+	if sums, _ := ReadManifest(tempDir); sums["2023/a 100%.jpg"] != hello {
+		t.Errorf("re-indexing replaced the recorded digest of a modified file")
+	}
+
+	// Removed images stay in the manifest, for verify to report them.
+	os.Remove(photo)
+	Update(tempDir, filepath.Join(tempDir, "2023"))
+	SplitCreate(tempDir)
+	if sums, _ := ReadManifest(tempDir); sums["2023/a 100%.jpg"] != hello {
+		t.Errorf("re-indexing dropped the digest of a removed file: %v", sums)
+	}
+}
@@ -1,16 +1,22 @@
 package indexer
 
 import (
+	"bytes"
 	"html/template"
 	"image"
-	"image/jpeg"
 	_ "image/png"
+	"io/fs"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/image-archive/metadata"
+	"github.com/image-archive/state"
 	"github.com/spf13/cobra"
 	"golang.org/x/image/draw"
 )
@@ -18,16 +24,94 @@ import (
 // PageData holds the data for our HTML template.
 type PageData struct {
 	Title       string
+	Description string  // Shown below the title, such as the description of an album
+	Root        string  // Relative link to the archive root, "." at the root
+	Breadcrumbs []Crumb // Trail from the archive root to this directory
+	Sections    []Section
+	Tree        []TreeItem
 	SubDirs     []SubDir
-	Images      []string
+	Images      []Image
 	CurrentPath string
 	Thumbs      bool
+	Search      bool   // Show the archive search box
+	MapLink     string // Escaped link to this folder on the map, empty without geotagged images
+	Download    string // Link to the ZIP of the folder's images, empty without one
+	TagCloud    []TagLink
+	Pager       Pager
+}
+
+// Image represents an image entry in the gallery grid.
+type Image struct {
+	ID      string   // Stable identifier used for the modal anchor
+	Name    string   // File name within the directory
+	Src     string   // Escaped link to the original, relative to the page
+	Thumb   string   // Escaped link to the thumbnail, or the original without thumbnails
+	Preview string   // Escaped link to the screen-size preview, empty if the original is small enough
+	Formats []string // Additional formats the thumbnail is available in
+
+	// Tiny data URI shown in the grid until the thumbnail loads, empty if
+	// the image could not be read.
+	Placeholder string
+	Size        int64     // File size in bytes
+	ModTime     time.Time // Modification time
+	Taken       time.Time // Capture time, falling back to ModTime
+	Title       string    // Title from the image metadata
+	Caption     string    // Description from the image metadata
+	Rating      int       // Star rating from the image metadata
+	Tags        []TagLink // Keywords, linking to their tag pages with --tags
+
+	// Pages collecting images from many folders link back to the source.
+	Folder     string // Escaped link to the image on its folder page
+	FolderName string // Display name of the folder
+}
+
+// describe fills the capture time and captions of img from its metadata.
+func (img *Image) describe(meta metadata.Info) {
+	img.Taken = img.ModTime
+	if !meta.DateTimeOriginal.IsZero() {
+		img.Taken = meta.DateTimeOriginal
+	}
+	img.Title = meta.Title
+	img.Caption = meta.Description
+	img.Rating = meta.Rating
+}
+
+// Alt returns the text alternative of the image: its caption, its title or
+// else its file name.
+func (img Image) Alt() string {
+	switch {
+	case img.Caption != "":
+		return img.Caption
+	case img.Title != "":
+		return img.Title
+	}
+	return img.Name
+}
+
+// Stars returns the rating of the image as stars, such as "★★★☆☆", or ""
+// if it is unrated or rejected.
+func (img Image) Stars() string {
+	if img.Rating <= 0 {
+		return ""
+	}
+	return strings.Repeat("★", img.Rating) + strings.Repeat("☆", 5-img.Rating)
+}
+
+// Section is an archive-wide page, such as the timeline, linked from the
+// header of every page.
+type Section struct {
+	Name string
+	Link string // Escaped link relative to the page
 }
 
 // SubDir represents a subdirectory entry for the sidebar.
 type SubDir struct {
-	Name string // Display name
-	Link string // Relative link to the subdirectory's index.html
+	Name   string // Display name
+	Link   string // Escaped relative link to the subdirectory
+	Cover  string // Relative link to the cover thumbnail, empty if the folder has no images
+	Count  int    // Number of images in the folder and its subfolders
+	Dates  string // Date range of those images
+	Locked bool   // Protected by a password the page does not know
 }
 
 // HTML template for index.html pages.
@@ -78,10 +162,13 @@ var indexTemplate = `
       grid-template-columns: repeat(auto-fill, minmax(150px, 1fr));
       grid-gap: 15px;
     }
+    .grid picture { display: contents; }
     .grid img {
       width: 100%;
       height: 100%;
+      aspect-ratio: 1;
       object-fit: cover;
+      background-size: cover;
       display: block;
       cursor: pointer;
     }
@@ -105,36 +192,173 @@ var indexTemplate = `
     .modal:target {
       display: flex;
     }
+    .modal .caption {
+      position: absolute;
+      bottom: 0;
+      width: 100%;
+      padding: 10px 20px;
+      text-align: center;
+      color: #fff;
+      background: rgba(0, 0, 0, 0.5);
+    }
+    .modal .caption a { color: #fff; }
+    .modal .caption strong, .modal .caption p { display: block; margin-bottom: 4px; }
+    .modal .tags { margin: 4px 0; }
+    .modal .tags a {
+      display: inline-block;
+      margin: 0 3px;
+      padding: 1px 8px;
+      border-radius: 10px;
+      background: rgba(255, 255, 255, 0.2);
+      text-decoration: none;
+    }
+    .tagcloud { line-height: 2; max-width: 800px; }
+    .tagcloud a { margin-right: 12px; color: #333; text-decoration: none; white-space: nowrap; }
+    .tagcloud a:hover { text-decoration: underline; }
+    .tagcloud small { color: #666; }
+    .tagcloud .weight-1 { font-size: 0.8em; }
+    .tagcloud .weight-2 { font-size: 1em; }
+    .tagcloud .weight-3 { font-size: 1.3em; }
+    .tagcloud .weight-4 { font-size: 1.6em; }
+    .tagcloud .weight-5 { font-size: 2em; }
+    .sections { margin-bottom: 10px; }
+    .sections a { margin-right: 15px; color: #333; }
+    .folders {
+      display: grid;
+      grid-template-columns: repeat(auto-fill, minmax(180px, 1fr));
+      grid-gap: 15px;
+      margin-bottom: 20px;
+    }
+    .folder {
+      display: flex;
+      flex-direction: column;
+      text-decoration: none;
+      color: #333;
+      background: #f0f0f0;
+      border-radius: 4px;
+      overflow: hidden;
+    }
+    .folder img, .folder .nocover {
+      width: 100%;
+      aspect-ratio: 4 / 3;
+      object-fit: cover;
+      background: #ddd;
+    }
+    .folder strong, .folder small { padding: 4px 8px; }
+    .folder small { color: #666; padding-top: 0; }
+    .search { position: relative; margin-bottom: 15px; max-width: 500px; }
+    .search input { width: 100%; padding: 6px 10px; }
+    #search-results {
+      position: absolute;
+      z-index: 1;
+      width: 100%;
+      max-height: 60vh;
+      overflow-y: auto;
+      list-style: none;
+      background: #fff;
+      box-shadow: 0 2px 6px rgba(0, 0, 0, 0.3);
+    }
+    #search-results a { display: block; padding: 5px 10px; color: #333; text-decoration: none; }
+    #search-results a:hover { background: #eee; }
+    #search-results small { display: block; color: #666; }
+    .breadcrumbs { margin-bottom: 10px; color: #666; }
+    .breadcrumbs a { color: #333; }
+    .tree ul { list-style: none; padding-left: 12px; }
+    .tree li { margin-bottom: 2px; }
+    .tree summary { cursor: pointer; }
+    .tree summary a { display: inline-block; }
+    .maplink { display: inline-block; margin-bottom: 10px; color: #333; }
+    .download { display: inline-block; margin: 0 15px 10px 0; color: #333; }
+    .description { margin: 5px 0 15px; color: #444; max-width: 800px; }
+    .pager {
+      display: flex;
+      gap: 15px;
+      align-items: center;
+      margin: 15px 0;
+    }
   </style>
 </head>
 <body>
-  {{if .SubDirs}}
+  {{if or .Tree .SubDirs}}
   <div class="sidebar">
+    {{if .Tree}}
+    <ul class="tree">
+      {{range .Tree}}{{template "tree" .}}{{end}}
+    </ul>
+    {{else}}
     <ul>
       {{range .SubDirs}}
-      <li><a href="{{.Link}}/index.html">{{.Name}}</a></li>
+      <li><a href="{{.Link}}/index.html">{{.Name}}{{if .Count}} <small>({{.Count}})</small>{{end}}</a></li>
       {{end}}
     </ul>
+    {{end}}
   </div>
   {{end}}
   <div class="content">
+    {{if .Search}}
+    <div class="search">
+      <input type="search" id="search" placeholder="Search the archive" autocomplete="off" data-root="{{.Root}}">
+      <ul id="search-results"></ul>
+    </div>
+    {{end}}
+    {{if .Sections}}
+    <nav class="sections">
+      {{range .Sections}}<a href="{{.Link}}">{{.Name}}</a>{{end}}
+    </nav>
+    {{end}}
+    {{if gt (len .Breadcrumbs) 1}}
+    <nav class="breadcrumbs">
+      {{range $i, $c := .Breadcrumbs}}
+      {{if $i}}<span>&rsaquo;</span>{{end}}
+      {{if $c.Link}}<a href="{{$c.Link}}">{{$c.Name}}</a>{{else}}<span>{{$c.Name}}</span>{{end}}
+      {{end}}
+    </nav>
+    {{end}}
     <h1>{{.Title}}</h1>
+    {{if .Description}}<p class="description">{{.Description}}</p>{{end}}
+    {{if .Download}}<a class="download" href="{{.Download}}" download="{{.Title}}.zip">Download all</a>{{end}}
+    {{if .MapLink}}<a class="maplink" href="{{.MapLink}}">Show on map</a>{{end}}
+    {{if .SubDirs}}
+    <div class="folders">
+      {{range .SubDirs}}{{if ne .Link ".."}}
+      <a class="folder" href="{{.Link}}/index.html">
+        {{if .Cover}}<img loading="lazy" src="{{.Cover}}" alt="">{{else}}<div class="nocover"></div>{{end}}
+        <strong>{{.Name}}{{if .Locked}} &#128274;{{end}}</strong>
+        {{if not .Locked}}<small>{{.Count}} photo{{if ne .Count 1}}s{{end}}{{if .Dates}} &middot; {{.Dates}}{{end}}</small>{{end}}
+      </a>
+      {{end}}{{end}}
+    </div>
+    {{end}}
+    {{if .TagCloud}}
+    <div class="tagcloud">
+      {{range .TagCloud}}<a class="weight-{{.Weight}}" href="{{.Link}}">{{.Name}} <small>({{.Count}})</small></a> {{end}}
+    </div>
+    {{end}}
     {{if .Images}}
-    <div class="grid">
+    {{template "pager" .Pager}}
+    <div class="grid" data-prev="{{.Pager.Prev}}" data-next="{{.Pager.Next}}"
+      data-prev-last="{{.Pager.PrevLast}}" data-next-first="{{.Pager.NextFirst}}">
       {{range .Images}}
-      <a href="#modal-{{.}}">
-      {{if $.Thumbs}}
-        <img loading="lazy" src=".thumbs/{{.}}" alt="">
-      {{else}}
-        <img loading="lazy" src="{{.}}" alt="">
-      {{end}}
+      <a href="#modal-{{.ID}}">
+        {{with .Sources}}<picture>{{range .}}<source srcset="{{.Srcset}}" type="{{.Type}}">{{end}}{{end}}<img loading="lazy" src="{{.Thumb}}" alt="{{.Alt}}"{{if .Placeholder}} style="background-image: url({{.PlaceholderURL}})"{{end}}>{{if .Sources}}</picture>{{end}}
       </a>
-      <div id="modal-{{.}}" class="modal">
-        <img src="{{.}}" alt="">
+      <div id="modal-{{.ID}}" class="modal">
+        <img src="{{if .Preview}}{{.Preview}}{{else}}{{.Src}}{{end}}" data-original="{{.Src}}" alt="{{.Alt}}">
+        {{if or .Title .Caption .Stars .Tags .Folder .Preview}}
+        <div class="caption">
+          {{if .Title}}<strong>{{.Title}}</strong>{{end}}
+          {{if .Caption}}<p>{{.Caption}}</p>{{end}}
+          {{if .Stars}}<span class="rating" title="{{.Rating}} of 5">{{.Stars}}</span>{{end}}
+          {{if .Tags}}<div class="tags">{{range .Tags}}<a href="{{.Link}}">{{.Name}}</a>{{end}}</div>{{end}}
+          {{if .Folder}}<a href="{{.Folder}}">{{.FolderName}}</a>{{end}}
+          {{if .Preview}}<a class="original" href="{{.Src}}" target="_blank">View original</a>{{end}}
+        </div>
+        {{end}}
       </div>
       {{end}}
     </div>
-    {{else}}
+    {{template "pager" .Pager}}
+    {{else if not .TagCloud}}
     <p>No images in this folder.</p>
     {{end}}
   </div>
@@ -163,11 +387,17 @@ var indexTemplate = `
     // Navigate with left and right arrow keys
     document.addEventListener('keydown', (e) => {
       const currentHash = window.location.hash;
-      if (!currentHash) return;
+      if (!currentHash || e.target.tagName === 'INPUT') return;
 
       const currentIndex = images.findIndex(link => ` + "`" + `#${link.getAttribute('href').substring(1)}` + "`" + `=== currentHash);
 
-      if (e.key === 'ArrowRight') {
+      // Cross into the neighbouring page at either end of a paged folder
+      const grid = document.querySelector('.grid');
+      if (e.key === 'ArrowRight' && currentIndex === images.length - 1 && grid.dataset.next) {
+        window.location.href = grid.dataset.next + '#modal-' + grid.dataset.nextFirst;
+      } else if (e.key === 'ArrowLeft' && currentIndex === 0 && grid.dataset.prev) {
+        window.location.href = grid.dataset.prev + '#modal-' + grid.dataset.prevLast;
+      } else if (e.key === 'ArrowRight') {
         const nextIndex = (currentIndex + 1) % images.length;
         window.location.hash = ` + "`" + `#${images[nextIndex].getAttribute('href').substring(1)}` + "`" + `;
       } else if (e.key === 'ArrowLeft') {
@@ -175,20 +405,96 @@ var indexTemplate = `
         window.location.hash = ` + "`" + `#${images[prevIndex].getAttribute('href').substring(1)}` + "`" + `;
       }
     });
+
+    // Search the archive-wide index, loaded on first use
+    const search = document.getElementById('search');
+    if (search) {
+      const results = document.getElementById('search-results');
+      const root = search.dataset.root;
+      const runSearch = () => {
+        results.replaceChildren();
+        const terms = search.value.toLowerCase().split(' ').filter(t => t);
+        if (!terms.length || !window.IMA_SEARCH) return;
+        for (const item of window.IMA_SEARCH) {
+          const text = item.slice(1).join(' ').toLowerCase();
+          if (!terms.every(t => text.includes(t))) continue;
+          const link = document.createElement('a');
+          link.href = root + '/' + item[0];
+          link.textContent = item[1];
+          const details = document.createElement('small');
+          details.textContent = [item[2], item[3], item[4], item[5]].filter(d => d).join(' · ');
+          link.appendChild(details);
+          const li = document.createElement('li');
+          li.appendChild(link);
+          results.appendChild(li);
+          if (results.children.length === 50) break;
+        }
+      };
+      search.addEventListener('focus', () => {
+        if (window.IMA_SEARCH || document.getElementById('search-index')) return;
+        const script = document.createElement('script');
+        script.id = 'search-index';
+        script.src = root + '/search-index.js';
+        script.onload = runSearch;
+        document.head.appendChild(script);
+      });
+      search.addEventListener('input', runSearch);
+    }
   });
   </script>
 </body>
 </html>
+{{define "tree"}}
+  <li>
+    {{if .Children}}
+    <details{{if .Open}} open{{end}}>
+      <summary><a href="{{.Link}}"{{if .Current}} class="active"{{end}}>{{.Name}}</a></summary>
+      <ul>
+        {{range .Children}}{{template "tree" .}}{{end}}
+      </ul>
+    </details>
+    {{else}}
+    <a href="{{.Link}}"{{if .Current}} class="active"{{end}}>{{.Name}}</a>
+    {{end}}
+  </li>
+{{end}}
+{{define "pager"}}
+  {{if gt .Pages 1}}
+  <nav class="pager">
+    {{if .Prev}}<a href="{{.Prev}}">&larr; Previous</a>{{end}}
+    <span>Page {{.Page}} of {{.Pages}}</span>
+    {{if .Next}}<a href="{{.Next}}">Next &rarr;</a>{{end}}
+  </nav>
+  {{end}}
+{{end}}
 `
 
+var pageTemplate = template.Must(template.New("index").Parse(indexTemplate))
+
 var noThumb bool // Global variable to track the --nothumb flag
 
+// AddFlags registers the indexing options on cmd. They are persistent, so
+// subcommands that regenerate pages accept the same options.
 func AddFlags(cmd *cobra.Command) {
-	cmd.Flags().BoolVar(&noThumb, "nothumb", false, "Disable thumbnail generation")
+	cmd.PersistentFlags().BoolVar(&noThumb, "nothumb", false, "Disable thumbnail generation")
+	cmd.PersistentFlags().Var(&sortBy, "sort", "Sort images and folders by name|natural|mtime|exif-date|size")
+	cmd.PersistentFlags().BoolVar(&sortDesc, "desc", false, "Sort in descending order")
+	cmd.PersistentFlags().BoolVar(&sortDirsByNewest, "dirs-by-newest", false, "Sort folders by the date of their newest photo")
+	cmd.PersistentFlags().BoolVar(&searchEnabled, "search", false, "Add a search box backed by an archive-wide search index")
+	cmd.PersistentFlags().BoolVar(&timelineEnabled, "timeline", false, "Generate a timeline of images grouped by capture date")
+	cmd.PersistentFlags().BoolVar(&tagsEnabled, "tags", false, "Generate a page per keyword and a tag cloud")
+	cmd.PersistentFlags().BoolVar(&mapEnabled, "map", false, "Generate a map of geotagged images")
+	cmd.PersistentFlags().StringVar(&mapTiles, "map-tiles", "", "Tile URL template for the map, such as https://tile.openstreetmap.org/{z}/{x}/{y}.png (default: offline world outline)")
+	cmd.PersistentFlags().BoolVar(&fixityEnabled, "fixity", false, "Record the SHA-256 digest of every image in "+ManifestFile)
+	cmd.PersistentFlags().BoolVar(&treeSidebar, "tree", false, "Show the full folder tree in the sidebar")
+	cmd.PersistentFlags().IntVar(&pageSize, "page-size", 0, "Split folders into pages of this many images (0 disables paging)")
+	cmd.PersistentFlags().IntVar(&previewSize, "preview-size", previewSize, "Longest side of the previews shown in place of larger originals (0 shows the originals)")
+	cmd.PersistentFlags().Var(&thumbFormats, "thumb-formats", "Additional thumbnail formats offered to browsers that support them, such as avif,webp")
+	cmd.PersistentFlags().BoolVar(&zipEnabled, "zip", false, "Build a ZIP of each folder's images, linked from its pages as \"Download all\"")
 }
 
-// isImageFile checks if a file extension is an image type.
-func isImageFile(name string) bool {
+// IsImageFile checks if a file extension is an image type.
+func IsImageFile(name string) bool {
 	ext := strings.ToLower(filepath.Ext(name))
 	switch ext {
 	case ".jpg", ".jpeg", ".png", ".gif":
@@ -197,7 +503,89 @@ func isImageFile(name string) bool {
 	return false
 }
 
+// archive carries what is known about the whole archive while indexing.
+type archive struct {
+	root string    // Directory the indexing started from
+	name string    // Display name of the root, even when root is "."
+	tree *TreeNode // Full directory tree, nil unless --tree is set
+
+	albums []*Album        // Album definitions, in order of file name
+	locks  map[string]lock // Keys of the protected folders by slash path
+
+	stats map[string]dirStats // Memoised per-directory statistics
+
+	store   *state.Store     // State of the archive, nil if unreadable
+	catalog catalog          // Images of every indexed directory
+	known   map[string]Entry // Catalog entries and fresh metadata by slash path
+	visited map[string]bool  // Directories indexed during this run
+}
+
+func newArchive(root string) *archive {
+	a := &archive{root: filepath.Clean(root)}
+	a.name = filepath.Base(a.root)
+	if abs, err := filepath.Abs(a.root); err == nil {
+		a.name = filepath.Base(abs)
+	}
+	locks, err := readLocks(a.root)
+	if err != nil {
+		log.Printf("Failed to read the protected folders of %s: %v", a.root, err)
+	}
+	a.locks = locks
+	// Sorting the tree by newest photo reads the catalog.
+	a.loadCatalog()
+	if treeSidebar {
+		a.tree = a.buildTree()
+		a.tree.Name = a.name
+		a.pruneTree(a.tree)
+	}
+	a.albums = loadAlbums(a.root)
+	return a
+}
+
+// sections returns the enabled archive-wide sections, linked from the page
+// in the directory rel.
+func (a *archive) sections(rel string) []Section {
+	var sections []Section
+	if timelineEnabled {
+		sections = append(sections, Section{
+			Name: "Timeline",
+			Link: urlPath(path.Join(rootPrefix(rel), timelineDir, "index.html")),
+		})
+	}
+	if len(a.albums) > 0 {
+		sections = append(sections, Section{
+			Name: "Albums",
+			Link: urlPath(path.Join(rootPrefix(rel), albumsDir, "index.html")),
+		})
+	}
+	if tagsEnabled {
+		sections = append(sections, Section{
+			Name: "Tags",
+			Link: urlPath(path.Join(rootPrefix(rel), tagsDir, "index.html")),
+		})
+	}
+	if mapEnabled {
+		sections = append(sections, Section{
+			Name: "Map",
+			Link: urlPath(path.Join(rootPrefix(rel), mapDir, "index.html")),
+		})
+	}
+	return sections
+}
+
+// GenerateIndexHTML writes the pages for dir, treating dir as the root of
+// the archive.
 func GenerateIndexHTML(dir string) error {
+	a := newArchive(dir)
+	if err := a.generateIndex(dir); err != nil {
+		return err
+	}
+	a.finish()
+	return nil
+}
+
+// generateIndex writes the pages for dir, which lies within the archive.
+func (a *archive) generateIndex(dir string) error {
 	// List items in the directory.
 	items, err := os.ReadDir(dir)
 	if err != nil {
@@ -205,7 +593,7 @@ func GenerateIndexHTML(dir string) error {
 	}
 
 	var subDirs []SubDir
-	var images []string
+	var images []Image
 
 	// Create .thumbs directory if thumbnails are enabled
 	thumbsDir := filepath.Join(dir, ".thumbs")
@@ -215,115 +603,326 @@ func GenerateIndexHTML(dir string) error {
 		}
 	}
 
-	parentDir := filepath.Dir(dir)
-
-	if parentDir != dir && parentDir != "." && parentDir != "/" { // Avoid adding ".." for the root directory.
+	rel := relPath(a.root, dir)
+	if rel != "." { // Avoid adding ".." for the root directory.
 		subDirs = append(subDirs, SubDir{
 			Name: "..",
 			Link: "..",
 		})
 	}
 
-	// Channel to collect errors from goroutines
-	errChan := make(chan error, len(items))
 	// WaitGroup to wait for all goroutines to finish
 	var wg sync.WaitGroup
+	// Placeholders made for images that had none cached, by index of the
+	// image, so that goroutines write apart.
+	placeholders := make([]string, len(items))
 
 	for _, item := range items {
 		log.Printf("Processing %s", item.Name())
 		if item.IsDir() {
 			// Add subdirectory link.
-			if item.Name() != ".thumbs" {
+			if !skipDir(a.root, rel, item.Name()) {
 				subDirs = append(subDirs, SubDir{
 					Name: item.Name(),
-					Link: item.Name(),
+					Link: urlPath(item.Name()),
 				})
 			}
-		} else if isImageFile(item.Name()) {
+		} else if IsImageFile(item.Name()) {
 			// Add image file.
-			images = append(images, item.Name())
-
+			info, err := item.Info()
+			if err != nil {
+				return err
+			}
+			img := Image{
+				Name:    item.Name(),
+				Size:    info.Size(),
+				ModTime: info.ModTime(),
+			}
+			meta := a.imageInfo(dir, img)
+			img.describe(meta)
+			img.Tags = tagLinks(rootPrefix(rel), meta.Keywords)
+			images = append(images, img)
+
+			// Thumbnails and previews are made again when the image changes.
+			imagePath := filepath.Join(dir, item.Name())
+			var thumbs []string
+			var preview string
 			if !noThumb {
-				imagePath := filepath.Join(dir, item.Name())
-				thumbnailPath := filepath.Join(thumbsDir, item.Name())
-
-				// Check if thumbnail already exists
-				if _, err := os.Stat(thumbnailPath); os.IsNotExist(err) {
-					wg.Add(1)
-					go func(imagePath, thumbnailPath string) {
-						defer wg.Done()
-						if err := generateThumbnail(imagePath, thumbnailPath); err != nil {
-							log.Printf("Failed to generate thumbnail for %s: %v", imagePath, err)
-							errChan <- err
-						}
-					}(imagePath, thumbnailPath)
+				p := filepath.Join(thumbsDir, item.Name())
+				for _, file := range append([]string{p}, thumbnailFiles(p)...) {
+					if stale(imagePath, file) {
+						thumbs = append(thumbs, file)
+					}
+				}
+			}
+			if p := filepath.Join(dir, previewsDir, item.Name()); previewSize > 0 && stale(imagePath, p) && needsPreview(imagePath) {
+				preview = p
+			}
+			// Placeholders are made from the thumbnail, which is much faster
+			// to read, and kept until the image changes.
+			placeholder := ""
+			if a.known[path.Join(rel, img.Name)].Placeholder == "" {
+				placeholder = imagePath
+				if !noThumb {
+					placeholder = filepath.Join(thumbsDir, item.Name())
 				}
 			}
+			if len(thumbs) > 0 || preview != "" || placeholder != "" {
+				wg.Add(1)
+				go func(i int, imagePath string, thumbs []string, preview, placeholder string) {
+					defer wg.Done()
+					// A broken image should not keep the rest of the page from being generated.
+					if len(thumbs) > 0 || preview != "" {
+						if err := generateDerivatives(imagePath, thumbs, preview); err != nil {
+							log.Printf("Failed to generate thumbnail or preview for %s: %v", imagePath, err)
+							return
+						}
+					}
+					if placeholder != "" {
+						placeholders[i], _ = makePlaceholder(placeholder)
+					}
+				}(len(images)-1, imagePath, thumbs, preview, placeholder)
+			}
 		}
 	}
 
 	// Wait for all goroutines to finish
 	wg.Wait()
-	close(errChan)
+	for i := range images {
+		key := path.Join(rel, images[i].Name)
+		e := a.known[key]
+		if placeholders[i] != "" {
+			e.Placeholder = placeholders[i]
+			a.known[key] = e
+		}
+		images[i].Placeholder = e.Placeholder
+		if previewSize > 0 && !stale(filepath.Join(dir, images[i].Name), filepath.Join(dir, previewsDir, images[i].Name)) {
+			images[i].Preview = urlPath(previewPath(images[i].Name))
+		}
+	}
 
-	// Check if there were any errors
-	for err := range errChan {
-		if err != nil {
-			return err
+	protection, locked := a.locks[a.lockOf(rel)]
+	if locked {
+		lockImages(dir, images, protection.Key)
+	} else if !noThumb {
+		// Protected pages only load the encrypted fallback thumbnails.
+		for i := range images {
+			images[i].Formats = thumbnailFormats(filepath.Join(dir, images[i].Name), filepath.Join(thumbsDir, images[i].Name))
 		}
 	}
 
-	// Prepare template data.
-	data := PageData{
-		Title:       filepath.Base(dir),
-		SubDirs:     subDirs,
-		Images:      images,
-		CurrentPath: dir,
-		Thumbs:      !noThumb,
+	// The ZIP of a protected folder would hold its images unencrypted.
+	var download string
+	if zipEnabled && !locked && len(images) > 0 {
+		if err := writeFolderZip(dir, images); err != nil {
+			log.Printf("Failed to write the ZIP of %s: %v", dir, err)
+		} else {
+			download = ZipFile
+		}
+	} else if zipEnabled {
+		os.Remove(filepath.Join(dir, ZipFile))
 	}
 
-	tmpl, err := template.New("index").Parse(indexTemplate)
-	if err != nil {
-		return err
+	a.sortSubDirs(dir, subDirs)
+	sortImages(dir, images)
+	fillImageLinks(".", images)
+	for i := range subDirs {
+		if subDirs[i].Link != ".." {
+			a.fillSubDir(dir, &subDirs[i])
+		}
 	}
 
-	// Create or overwrite index.html.
-	f, err := os.Create(filepath.Join(dir, "index.html"))
-	if err != nil {
+	title := filepath.Base(dir)
+	if rel == "." {
+		title = a.name
+	}
+
+	pages, pagers := paginate(images)
+	a.record(rel, pages)
+	mapLink := a.mapLink(rel)
+	for i, pageImages := range pages {
+		// Prepare template data.
+		data := PageData{
+			Title:       title,
+			Root:        rootPrefix(rel),
+			Breadcrumbs: breadcrumbs(a.name, rel),
+			Sections:    a.sections(rel),
+			SubDirs:     subDirs,
+			Images:      pageImages,
+			CurrentPath: dir,
+			Thumbs:      !noThumb,
+			Search:      searchEnabled,
+			MapLink:     mapLink,
+			Download:    download,
+			Pager:       pagers[i],
+		}
+		if a.tree != nil {
+			data.Tree = []TreeItem{treeView(a.tree, rel)}
+		}
+
+		// Create or overwrite index.html, index-2.html, ...
+		file := filepath.Join(dir, pageFileName(i+1))
+		if locked {
+			err = writeLockedPage(file, data, protection)
+		} else {
+			err = writePage(file, data)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return removeStalePages(dir, len(pages))
+}
+
+// writePage renders a page into the file at path. Unchanged pages are not
+// rewritten, so regenerating the archive only touches what changed.
+func writePage(path string, data PageData) error {
+	var buf bytes.Buffer
+	if err := pageTemplate.Execute(&buf, data); err != nil {
 		return err
 	}
-	defer f.Close()
+	return writeIfChanged(path, buf.Bytes())
+}
 
-	return tmpl.Execute(f, data)
+// writeIfChanged writes data to the file at path unless it already holds
+// exactly that content.
+func writeIfChanged(path string, data []byte) error {
+	if old, err := os.ReadFile(path); err == nil && bytes.Equal(old, data) {
+		return nil
+	}
+	return os.WriteFile(path, data, 0644)
 }
 
+// SplitCreate writes the pages for every directory below rootDir.
 func SplitCreate(rootDir string) {
-	rootDir = strings.TrimSuffix(rootDir, string(os.PathSeparator))
+	rootDir = filepath.Clean(rootDir)
 	log.Printf("Indexing directory : %s", rootDir)
-	// Walk through each directory and generate an index.html.
-	err := filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
+	a := newArchive(rootDir)
+	if err := a.walk(rootDir); err != nil {
+		log.Printf("Indexing %s failed: %v", rootDir, err)
+	}
+	a.finish()
+}
+
+// Update rewrites the pages affected by changes in dirs, directories within
+// the archive rooted at root, and then the archive-wide files once. The full
+// tree sidebar appears on every page, so with --tree the whole archive is
+// regenerated.
+func Update(root string, dirs ...string) {
+	root = filepath.Clean(root)
+	if treeSidebar {
+		SplitCreate(root)
+		return
+	}
+
+	a := newArchive(root)
+	changed := false
+	parents := make(map[string]bool)
+	// Folders come before their subfolders, which their walk covers.
+	dirs = slices.Clone(dirs)
+	slices.Sort(dirs)
+	for _, dir := range dirs {
+		dir = filepath.Clean(dir)
+		// The directory may be gone; start from its closest surviving ancestor.
+		for dir != a.root {
+			if info, err := os.Stat(dir); err == nil && info.IsDir() {
+				break
+			}
+			dir = filepath.Dir(dir)
+		}
+		rel := relPath(a.root, dir)
+		if rel == ".." || strings.HasPrefix(rel, "../") {
+			log.Printf("Ignoring change outside the archive: %s", dir)
+			continue
+		}
+		top, _, _ := strings.Cut(rel, "/")
+		if isGeneratedDir(a.root, top) {
+			continue // Pages written by finish, not folders of images
+		}
+		changed = true
+		if top == albumsSource || a.visited[rel] {
+			continue // An album definition changed, or already walked
+		}
+
+		log.Printf("Updating directory : %s", dir)
+		if err := a.walk(dir); err != nil {
+			log.Printf("Updating %s failed: %v", dir, err)
+		}
+		// Every ancestor shows this directory's image count and cover.
+		for parent := dir; parent != a.root; {
+			parent = filepath.Dir(parent)
+			parents[parent] = true
+		}
+	}
+	if !changed {
+		return
+	}
+
+	for parent := range parents {
+		if a.visited[relPath(a.root, parent)] {
+			continue
+		}
+		if err := a.generateIndex(parent); err != nil {
+			log.Printf("Updating %s failed: %v", parent, err)
+		}
+	}
+	a.finish()
+}
+
+// walk writes the pages for start and every directory below it.
+func (a *archive) walk(start string) error {
+	defer a.prune(relPath(a.root, start))
+	return filepath.WalkDir(start, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if info.IsDir() {
-			// Create/update the index.html for this directory.
-			if filepath.Base(path) == ".thumbs" {
-				return nil // Skip the .thumbs directory
-			}
-			if err := GenerateIndexHTML(path); err != nil {
-				// Handle the error as needed (e.g., log it).
-				return err
+		if !d.IsDir() {
+			return nil
+		}
+		if path != start && skipDir(a.root, relPath(a.root, filepath.Dir(path)), d.Name()) {
+			return fs.SkipDir // Skip .thumbs and other hidden directories
+		}
+		// Create/update the index.html for this directory.
+		return a.generateIndex(path)
+	})
+}
+
+// WalkImages calls fn for every image of the archive at root that the
+// indexer shows, with its slash path relative to root. Generated and hidden
+// directories are skipped, so other commands see the same images as the
+// gallery pages.
+func WalkImages(root string, fn func(rel string, info fs.FileInfo) error) error {
+	root = filepath.Clean(root)
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != root && skipDir(root, relPath(root, filepath.Dir(p)), d.Name()) {
+				return fs.SkipDir
 			}
+			return nil
 		}
-		return nil
+		if !d.Type().IsRegular() || !IsImageFile(d.Name()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(relPath(root, p), info)
 	})
-	if err != nil {
-		// Handle the error from filepath.Walk.
-		return
-	}
 }
 
 func generateThumbnail(imagePath, thumbnailPath string) error {
+	return generateDerivatives(imagePath, []string{thumbnailPath}, "")
+}
+
+// generateDerivatives decodes the image at imagePath once to write its
+// thumbnails, each in the format its file name calls for, and its preview
+// unless previewPath is empty.
+func generateDerivatives(imagePath string, thumbs []string, previewPath string) error {
 	// Open the original image file.
 	file, err := os.Open(imagePath)
 	if err != nil {
@@ -337,22 +936,42 @@ func generateThumbnail(imagePath, thumbnailPath string) error {
 		return err
 	}
 
+	if previewPath != "" {
+		if err := writePreview(img, previewPath); err != nil {
+			return err
+		}
+	}
+	if len(thumbs) == 0 {
+		return nil
+	}
+
 	// Resize the image to a thumbnail (e.g., 150x150).
 	thumbnail := resizeImage(img, 150, 150)
+	for _, thumbnailPath := range thumbs {
+		if err := writeThumbnail(thumbnailPath, thumbnail, encoderFor(thumbnailPath, img)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+func writeThumbnail(thumbnailPath string, thumbnail image.Image, e Encoder) error {
 	// Create the thumbnail file.
 	outFile, err := os.Create(thumbnailPath)
 	if err != nil {
 		return err
 	}
-	defer outFile.Close()
-
-	// Save the thumbnail as a JPEG.
-	return jpeg.Encode(outFile, thumbnail, &jpeg.Options{Quality: 80})
+	err = e.Encode(outFile, thumbnail)
+	if cerr := outFile.Close(); err == nil {
+		err = cerr
+	}
+	return err
 }
 
+// resizeImage scales img to width by height. Transparent pixels are kept
+// as they are, for encoders that support them.
 func resizeImage(img image.Image, width, height int) image.Image {
 	dst := image.NewRGBA(image.Rect(0, 0, width, height))
-	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Over, nil)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
 	return dst
 }
@@ -1,15 +1,43 @@
 package indexer
 
 import (
+	"bytes"
+	"html"
 	"image"
 	"image/jpeg"
+	"image/png"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
+// writeTestImage encodes a blank image of the given size, as PNG or JPEG
+// depending on the file extension.
+func writeTestImage(t *testing.T, path string, width, height int) {
+	t.Helper()
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create mock image file: %v", err)
+	}
+	defer f.Close()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	if strings.HasSuffix(path, ".png") {
+		err = png.Encode(f, img)
+	} else {
+		err = jpeg.Encode(f, img, &jpeg.Options{Quality: 80})
+	}
+	if err != nil {
+		t.Fatalf("Failed to encode mock image: %v", err)
+	}
+}
+
 func TestIsImageFile(t *testing.T) {
 	tests := []struct {
 		filename string
@@ -25,9 +53,9 @@ func TestIsImageFile(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		result := isImageFile(test.filename)
+		result := IsImageFile(test.filename)
 		if result != test.expected {
-			t.Errorf("isImageFile(%q) = %v; want %v", test.filename, result, test.expected)
+			t.Errorf("IsImageFile(%q) = %v; want %v", test.filename, result, test.expected)
 		}
 	}
 }
@@ -257,8 +285,8 @@ func TestGenerateIndexHTMLWithParallelThumbnails(t *testing.T) {
 	// Create mock subdirectories and image files.
 	os.Mkdir(filepath.Join(tempDir, "subdir1"), 0755)
 	os.Mkdir(filepath.Join(tempDir, "subdir2"), 0755)
-	os.WriteFile(filepath.Join(tempDir, "image1.jpg"), []byte{}, 0644)
-	os.WriteFile(filepath.Join(tempDir, "image2.png"), []byte{}, 0644)
+	writeTestImage(t, filepath.Join(tempDir, "image1.jpg"), 300, 300)
+	writeTestImage(t, filepath.Join(tempDir, "image2.png"), 300, 300)
 	os.WriteFile(filepath.Join(tempDir, "document.txt"), []byte{}, 0644)
 
 	// Call GenerateIndexHTML.
@@ -285,3 +313,198 @@ func TestGenerateIndexHTMLWithParallelThumbnails(t *testing.T) {
 		}
 	}
 }
+
+// An image that cannot be decoded gets no thumbnail, but is still listed,
+// and the rest of the folder is indexed as usual.
+func TestGenerateIndexHTMLWithUnreadableImage(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestImage(t, filepath.Join(tempDir, "good.jpg"), 300, 300)
+	os.WriteFile(filepath.Join(tempDir, "broken.jpg"), []byte("not an image"), 0644)
+
+	if err := GenerateIndexHTML(tempDir); err != nil {
+		t.Fatalf("GenerateIndexHTML failed: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(tempDir, "index.html"))
+	if err != nil {
+		t.Fatalf("index.html was not created: %v", err)
+	}
+	if !strings.Contains(string(content), "good.jpg") || !strings.Contains(string(content), "broken.jpg") {
+		t.Errorf("index.html does not list both images")
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, ".thumbs", "good.jpg")); err != nil {
+		t.Errorf("Thumbnail of the readable image was not created: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, ".thumbs", "broken.jpg")); err == nil {
+		t.Errorf("Thumbnail was created for an unreadable image")
+	}
+}
+
+func TestGenerateIndexHTMLPagination(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg", "e.jpg"} {
+		os.WriteFile(filepath.Join(tempDir, name), []byte{}, 0644)
+	}
+
+	oldSize, oldNoThumb := pageSize, noThumb
+	t.Cleanup(func() { pageSize, noThumb = oldSize, oldNoThumb })
+	pageSize, noThumb = 2, true
+
+	if err := GenerateIndexHTML(tempDir); err != nil {
+		t.Fatalf("GenerateIndexHTML failed: %v", err)
+	}
+
+	// Each page holds its own slice of images and links to its neighbours.
+	tests := []struct {
+		page     string
+		contains []string
+		excludes []string
+	}{
+		{"index.html", []string{"a.jpg", "b.jpg", `href="index-2.html"`, `data-next-first="` + imageID("c.jpg") + `"`}, []string{`src="c.jpg"`, "Previous"}},
+		{"index-2.html", []string{"c.jpg", "d.jpg", `href="index.html"`, `href="index-3.html"`, `data-prev-last="` + imageID("b.jpg") + `"`}, []string{`src="a.jpg"`}},
+		{"index-3.html", []string{"e.jpg", "Page 3 of 3"}, []string{"Next"}},
+	}
+	for _, test := range tests {
+		content, err := os.ReadFile(filepath.Join(tempDir, test.page))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", test.page, err)
+		}
+		for _, want := range test.contains {
+			if !strings.Contains(string(content), want) {
+				t.Errorf("%s does not contain %q", test.page, want)
+			}
+		}
+		for _, unwanted := range test.excludes {
+			if strings.Contains(string(content), unwanted) {
+				t.Errorf("%s unexpectedly contains %q", test.page, unwanted)
+			}
+		}
+	}
+
+	// Shrinking the folder removes pages that no longer exist.
+	os.Remove(filepath.Join(tempDir, "e.jpg"))
+	if err := GenerateIndexHTML(tempDir); err != nil {
+		t.Fatalf("GenerateIndexHTML failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "index-3.html")); !os.IsNotExist(err) {
+		t.Errorf("stale index-3.html was not removed")
+	}
+}
+
+func TestSidecarCaptions(t *testing.T) {
+	tempDir := t.TempDir()
+	os.WriteFile(filepath.Join(tempDir, "pier.jpg"), []byte{}, 0644)
+	sidecar := filepath.Join(tempDir, "pier.xmp")
+	writeSidecar := func(caption string, mtime time.Time) {
+		os.WriteFile(sidecar, []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:Rating="3">
+<dc:title><rdf:Alt><rdf:li xml:lang="x-default">The pier</rdf:li></rdf:Alt></dc:title>
+<dc:description><rdf:Alt><rdf:li xml:lang="x-default">`+caption+`</rdf:li></rdf:Alt></dc:description>
+</rdf:Description></rdf:RDF></x:xmpmeta>`), 0644)
+		os.Chtimes(sidecar, mtime, mtime)
+	}
+
+	oldNoThumb := noThumb
+	t.Cleanup(func() { noThumb = oldNoThumb })
+	noThumb = true
+
+	writeSidecar("Sunset", time.Now().Add(-time.Hour))
+	if err := GenerateIndexHTML(tempDir); err != nil {
+		t.Fatalf("GenerateIndexHTML failed: %v", err)
+	}
+	content, _ := os.ReadFile(filepath.Join(tempDir, "index.html"))
+	for _, want := range []string{`alt="Sunset"`, "<strong>The pier</strong>", "<p>Sunset</p>", "★★★☆☆"} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("index.html does not contain %q", want)
+		}
+	}
+
+	// Editing the sidecar alone is picked up despite the metadata cache.
+	writeSidecar("Sunrise", time.Now())
+	if err := GenerateIndexHTML(tempDir); err != nil {
+		t.Fatalf("GenerateIndexHTML failed: %v", err)
+	}
+	content, _ = os.ReadFile(filepath.Join(tempDir, "index.html"))
+	if !strings.Contains(string(content), `alt="Sunrise"`) {
+		t.Errorf("index.html still shows the old caption")
+	}
+}
+
+// attrPattern matches the value of every href and src attribute.
+var attrPattern = regexp.MustCompile(`(?:href|src)="([^"]*)"`)
+
+func FuzzGenerateIndexHTML(f *testing.F) {
+	for _, seed := range []string{
+		"plain", "with space", "a#b", "100%", "what?", "quote\"s", "it's",
+		"日本語", "<script>alert(1)</script>", "a:b", "javascript:alert(1)",
+		"&amp;", "back\\slash", "%2F", "#modal-x", "..jpg",
+	} {
+		f.Add(seed)
+	}
+
+	oldNoThumb := noThumb
+	f.Cleanup(func() { noThumb = oldNoThumb })
+
+	var jpg bytes.Buffer
+	jpeg.Encode(&jpg, image.NewRGBA(image.Rect(0, 0, 8, 8)), nil)
+
+	f.Fuzz(func(t *testing.T, name string) {
+		if name == "" || strings.ContainsAny(name, "/\x00") || name == "." || name == ".." {
+			t.Skip()
+		}
+
+		tempDir := t.TempDir()
+		imageName := name + ".jpg"
+		if err := os.WriteFile(filepath.Join(tempDir, imageName), jpg.Bytes(), 0644); err != nil {
+			t.Skip() // Not a valid file name on this file system
+		}
+		if !skipDir(tempDir, ".", name) {
+			os.Mkdir(filepath.Join(tempDir, name), 0755)
+		}
+
+		for _, thumbs := range []bool{false, true} {
+			noThumb = !thumbs
+			if err := GenerateIndexHTML(tempDir); err != nil {
+				t.Fatalf("GenerateIndexHTML failed: %v", err)
+			}
+			content, err := os.ReadFile(filepath.Join(tempDir, "index.html"))
+			if err != nil {
+				t.Fatalf("Failed to read index.html: %v", err)
+			}
+			page := string(content)
+
+			if !strings.Contains(html.UnescapeString(page), imageName) {
+				t.Errorf("index.html does not name %q", imageName)
+			}
+
+			for _, m := range attrPattern.FindAllStringSubmatch(page, -1) {
+				ref := html.UnescapeString(m[1])
+
+				// Fragment links must open a modal that exists exactly once.
+				if id, ok := strings.CutPrefix(ref, "#"); ok {
+					if n := strings.Count(page, `id="`+id+`"`); n != 1 {
+						t.Errorf("link %q matches %d elements", ref, n)
+					}
+					continue
+				}
+
+				// Every other link must be a relative path to an existing file.
+				u, err := url.Parse(ref)
+				if err != nil {
+					t.Errorf("link %q does not parse: %v", ref, err)
+					continue
+				}
+				if u.Scheme != "" || u.Host != "" || u.RawQuery != "" || u.Fragment != "" {
+					t.Errorf("link %q is not a plain relative path", ref)
+					continue
+				}
+				target := filepath.Join(tempDir, filepath.FromSlash(u.Path))
+				if filepath.Base(target) == "index.html" {
+					target = filepath.Dir(target) // Subdirectory pages are written by SplitCreate
+				}
+				if _, err := os.Stat(target); err != nil {
+					t.Errorf("link %q does not resolve: %v", ref, err)
+				}
+			}
+		}
+	})
+}
@@ -63,16 +63,23 @@ func buildFolderStructure(root string) []Folder {
 			relPath, _ := filepath.Rel(root, path)
 			folder := Folder{
 				Name: filepath.Base(path),
-				Path: relPath,
+				Path: filepath.ToSlash(relPath),
 			}
 
 			// Get images
+			var images []Image
 			files, _ := os.ReadDir(path)
 			for _, f := range files {
 				if !f.IsDir() && isImage(f.Name()) {
-					folder.Images = append(folder.Images, f.Name())
+					if info, err := f.Info(); err == nil {
+						images = append(images, Image{Name: f.Name(), Size: info.Size(), ModTime: info.ModTime()})
+					}
 				}
 			}
+			sortImages(path, images)
+			for _, img := range images {
+				folder.Images = append(folder.Images, img.Name)
+			}
 
 			folders = addToStructure(folders, strings.Split(relPath, string(filepath.Separator)), folder)
 		}
@@ -83,9 +90,23 @@ func buildFolderStructure(root string) []Folder {
 		log.Fatal(err)
 	}
 
+	a := &archive{root: filepath.Clean(root)}
+	a.loadCatalog()
+	a.sortFolders(folders)
 	return folders
 }
 
+// sortFolders orders the folder tree the same way as the per-directory pages.
+func (a *archive) sortFolders(folders []Folder) {
+	sortDirs(folders,
+		func(f Folder) string { return f.Name },
+		func(f Folder) string { return filepath.Join(a.root, f.Path) },
+		a.newestPhoto)
+	for _, f := range folders {
+		a.sortFolders(f.Children)
+	}
+}
+
 func generateHTML(folders []Folder) {
 	tmpl := `
     <!DOCTYPE html>
@@ -111,20 +132,25 @@ func generateHTML(folders []Folder) {
         <div class="content" id="content"></div>
 
         <script>
+            // Escape each path segment so names with '#', '?' or '%' still resolve.
+            function urlPath(p) {
+                return p.split('/').map(s => s === '.' ? s : encodeURIComponent(s)).join('/');
+            }
             function showImages(path, images) {
-                if (images) {
                 const content = document.getElementById('content');
-                content.innerHTML = images.map(img =>` +
-		"`<img" + ` src="${path}/${img}" alt="${img}">` + "`" + `
-                ).join('');
-                }
+                content.replaceChildren(...(images || []).map(name => {
+                    const img = document.createElement('img');
+                    img.src = urlPath(path + '/' + name);
+                    img.alt = name;
+                    return img;
+                }));
             }
         </script>
     </body>
     </html>
 
     {{define "folderTemplate"}}
-        <li class="folder" onclick="showImages('{{.Path}}', {{.Images}})">
+        <li class="folder" onclick="event.stopPropagation(); showImages({{.Path}}, {{.Images}})">
             📁 {{.Name}}
             {{if .Children}}
                 <ul>
@@ -0,0 +1,361 @@
+package indexer
+
+import (
+	"bytes"
+	"html/template"
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var (
+	mapEnabled bool   // --map
+	mapTiles   string // --map-tiles
+)
+
+// mapDir is the root-level directory holding the map page.
+const mapDir = "map"
+
+// mapPoint is one geotagged image on the map page.
+type mapPoint struct {
+	Lat    float64 `json:"lat"`
+	Lon    float64 `json:"lon"`
+	Name   string  `json:"name"`
+	Thumb  string  `json:"thumb"`  // Escaped link to the thumbnail
+	Href   string  `json:"href"`   // Escaped link to the image on its folder page
+	Folder string  `json:"folder"` // Slash path of the folder, "." at the root
+}
+
+// MapData holds the data for the map page template.
+type MapData struct {
+	Title       string
+	Breadcrumbs []Crumb
+	Sections    []Section
+	Tiles       string // Tile URL template, empty for the built-in outline
+	Outline     string // SVG path of the world outline
+	Points      []mapPoint
+}
+
+// writeMap writes the map page plotting every geotagged image.
+func (a *archive) writeMap() error {
+	dir, err := claimDir(a.root, mapDir)
+	if err != nil {
+		return err
+	}
+
+	dirs := make([]string, 0, len(a.catalog))
+	for d := range a.catalog {
+		dirs = append(dirs, d)
+	}
+	slices.SortFunc(dirs, naturalCompare)
+
+	prefix := rootPrefix(mapDir)
+	points := []mapPoint{}
+	for _, d := range dirs {
+		for _, e := range a.catalog[d] {
+			loc := e.Meta.Location
+			if loc == nil {
+				continue
+			}
+			img := a.entryImage(prefix, d, e)
+			points = append(points, mapPoint{
+				Lat:    loc.Lat,
+				Lon:    loc.Lon,
+				Name:   e.Name,
+				Thumb:  img.Thumb,
+				Href:   img.Folder,
+				Folder: d,
+			})
+		}
+	}
+
+	data := MapData{
+		Title:       "Map",
+		Breadcrumbs: a.sectionCrumbs(mapDir, []string{"Map"}),
+		Sections:    a.sections(mapDir),
+		Tiles:       mapTiles,
+		Outline:     worldPath,
+		Points:      points,
+	}
+
+	var buf bytes.Buffer
+	if err := mapPageTemplate.Execute(&buf, data); err != nil {
+		return err
+	}
+	return writeIfChanged(filepath.Join(dir, "index.html"), buf.Bytes())
+}
+
+// mapLink returns the link from the page of the directory rel to the map
+// showing its geotagged images and those of its subfolders, or "" when
+// there are none.
+func (a *archive) mapLink(rel string) string {
+	if !mapEnabled {
+		return ""
+	}
+	for d, entries := range a.catalog {
+		if rel != "." && d != rel && !strings.HasPrefix(d, rel+"/") || a.lockOf(d) != "" {
+			continue
+		}
+		for _, e := range entries {
+			if e.Meta.Location != nil {
+				return urlPath(path.Join(rootPrefix(rel), mapDir, "index.html")) + "#folder=" + url.PathEscape(rel)
+			}
+		}
+	}
+	return ""
+}
+
+// HTML template for the map page. Markers are placed in web mercator world
+// coordinates, where the whole world spans 256 units at zoom 0, which is
+// also the layout of slippy map tiles.
+var mapTemplate = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8">
+  <title>{{.Title}}</title>
+  <style>
+    * { box-sizing: border-box; margin: 0; padding: 0; }
+    body { font-family: Arial, sans-serif; padding: 20px; }
+    .sections { margin-bottom: 10px; }
+    .sections a { margin-right: 15px; color: #333; }
+    .breadcrumbs { margin-bottom: 10px; color: #666; }
+    .breadcrumbs a { color: #333; }
+    h1 { margin-bottom: 10px; }
+    #filter { margin-bottom: 10px; color: #666; }
+    #map {
+      position: relative;
+      height: 70vh;
+      overflow: hidden;
+      background: #aad3df;
+      cursor: grab;
+      touch-action: none;
+      user-select: none;
+    }
+    #layer img, #outline { position: absolute; }
+    #layer img { width: 256px; height: 256px; }
+    #outline path { fill: #f2efe9; stroke: #999; vector-effect: non-scaling-stroke; }
+    .marker {
+      position: absolute;
+      min-width: 28px;
+      height: 28px;
+      padding: 0 6px;
+      transform: translate(-50%, -50%);
+      border: 2px solid #fff;
+      border-radius: 14px;
+      background: #c33;
+      color: #fff;
+      font-weight: bold;
+      cursor: pointer;
+    }
+    .zoom { position: absolute; top: 10px; right: 10px; display: flex; flex-direction: column; gap: 4px; }
+    .zoom button { width: 30px; height: 30px; font-size: 18px; }
+    #cluster {
+      display: grid;
+      grid-template-columns: repeat(auto-fill, minmax(120px, 1fr));
+      grid-gap: 10px;
+      margin-top: 15px;
+    }
+    #cluster img { width: 100%; aspect-ratio: 1; object-fit: cover; display: block; }
+  </style>
+</head>
+<body>
+  {{if .Sections}}
+  <nav class="sections">
+    {{range .Sections}}<a href="{{.Link}}">{{.Name}}</a>{{end}}
+  </nav>
+  {{end}}
+  <nav class="breadcrumbs">
+    {{range $i, $c := .Breadcrumbs}}
+    {{if $i}}<span>&rsaquo;</span>{{end}}
+    {{if $c.Link}}<a href="{{$c.Link}}">{{$c.Name}}</a>{{else}}<span>{{$c.Name}}</span>{{end}}
+    {{end}}
+  </nav>
+  <h1>{{.Title}}</h1>
+  <p id="filter" hidden><span></span> &middot; <a href="#">Show all</a></p>
+  <div id="map" data-tiles="{{.Tiles}}">
+    <div id="layer">
+      {{if not .Tiles}}
+      <svg id="outline" viewBox="0 0 256 256" preserveAspectRatio="none"><path d="{{.Outline}}"/></svg>
+      {{end}}
+    </div>
+    <div id="markers"></div>
+    <div class="zoom">
+      <button type="button" id="zoom-in">+</button>
+      <button type="button" id="zoom-out">&minus;</button>
+    </div>
+  </div>
+  <div id="cluster"></div>
+  <script>
+    const allPoints = {{.Points}};
+    const view = document.getElementById('map');
+    const layer = document.getElementById('layer');
+    const markers = document.getElementById('markers');
+    const cluster = document.getElementById('cluster');
+    const outline = document.getElementById('outline');
+    const tiles = view.dataset.tiles;
+    const maxZoom = tiles ? 18 : 8;
+    const cellSize = 60;
+
+    // Project to web mercator world coordinates at zoom 0
+    for (const p of allPoints) {
+      const lat = Math.max(-85.05, Math.min(85.05, p.lat)) * Math.PI / 180;
+      p.x = (p.lon + 180) / 360 * 256;
+      p.y = (1 - Math.log(Math.tan(lat) + 1 / Math.cos(lat)) / Math.PI) / 2 * 256;
+    }
+
+    let points = allPoints, zoom = 1, cx = 128, cy = 128;
+
+    const origin = () => {
+      const scale = 2 ** zoom;
+      return [view.clientWidth / 2 - cx * scale, view.clientHeight / 2 - cy * scale, scale];
+    };
+
+    const render = () => {
+      const [ox, oy, scale] = origin();
+      const w = view.clientWidth, h = view.clientHeight;
+
+      if (tiles) {
+        layer.replaceChildren();
+        const n = 2 ** zoom;
+        const y0 = Math.max(0, Math.floor(-oy / 256)), y1 = Math.min(n - 1, Math.floor((h - oy) / 256));
+        for (let y = y0; y <= y1; y++) {
+          for (let x = Math.floor(-ox / 256); x <= Math.floor((w - ox) / 256); x++) {
+            const img = document.createElement('img');
+            img.src = tiles.replace('{z}', zoom).replace('{x}', ((x % n) + n) % n).replace('{y}', y);
+            img.alt = '';
+            img.style.left = (ox + x * 256) + 'px';
+            img.style.top = (oy + y * 256) + 'px';
+            layer.appendChild(img);
+          }
+        }
+      } else {
+        outline.style.left = ox + 'px';
+        outline.style.top = oy + 'px';
+        outline.style.width = outline.style.height = (256 * scale) + 'px';
+      }
+
+      // Cluster the visible points on a grid of screen cells
+      const cells = new Map();
+      for (const p of points) {
+        const sx = p.x * scale + ox, sy = p.y * scale + oy;
+        if (sx < -cellSize || sy < -cellSize || sx > w + cellSize || sy > h + cellSize) continue;
+        const key = Math.floor(sx / cellSize) + ',' + Math.floor(sy / cellSize);
+        let c = cells.get(key);
+        if (!c) cells.set(key, c = { sx: 0, sy: 0, points: [] });
+        c.sx += sx;
+        c.sy += sy;
+        c.points.push(p);
+      }
+      markers.replaceChildren();
+      for (const c of cells.values()) {
+        const marker = document.createElement('button');
+        marker.type = 'button';
+        marker.className = 'marker';
+        marker.textContent = c.points.length;
+        marker.style.left = (c.sx / c.points.length) + 'px';
+        marker.style.top = (c.sy / c.points.length) + 'px';
+        marker.addEventListener('click', () => open(c.points));
+        markers.appendChild(marker);
+      }
+    };
+
+    // List the images of a cluster and zoom in while it can still split
+    const open = (ps) => {
+      if (dragged) return;
+      cluster.replaceChildren();
+      for (const p of ps.slice(0, 200)) {
+        const link = document.createElement('a');
+        link.href = p.href;
+        link.title = p.name;
+        const img = document.createElement('img');
+        img.loading = 'lazy';
+        img.src = p.thumb;
+        img.alt = p.name;
+        link.appendChild(img);
+        cluster.appendChild(link);
+      }
+      const spread = ps.some(p => p.x !== ps[0].x || p.y !== ps[0].y);
+      if (spread && zoom < maxZoom) {
+        cx = ps.reduce((s, p) => s + p.x, 0) / ps.length;
+        cy = ps.reduce((s, p) => s + p.y, 0) / ps.length;
+        zoom = Math.min(maxZoom, zoom + 2);
+        render();
+      }
+    };
+
+    // Fit the view to the points shown
+    const fit = () => {
+      if (!points.length) {
+        zoom = 1, cx = 128, cy = 128;
+        return;
+      }
+      const xs = points.map(p => p.x), ys = points.map(p => p.y);
+      const minX = Math.min(...xs), maxX = Math.max(...xs), minY = Math.min(...ys), maxY = Math.max(...ys);
+      cx = (minX + maxX) / 2;
+      cy = (minY + maxY) / 2;
+      const span = Math.max(maxX - minX, maxY - minY, 256 / 2 ** 14);
+      const fitted = Math.floor(Math.log2(0.8 * Math.min(view.clientWidth, view.clientHeight) / span));
+      zoom = Math.max(0, Math.min(maxZoom, fitted));
+    };
+
+    // Show only one folder and its subfolders when linked from a folder page
+    const filter = () => {
+      const m = location.hash.match(/^#folder=(.*)$/);
+      const folder = m ? decodeURIComponent(m[1]) : '';
+      points = folder && folder !== '.' ? allPoints.filter(p => p.folder === folder || p.folder.startsWith(folder + '/')) : allPoints;
+      const note = document.getElementById('filter');
+      note.hidden = !folder || folder === '.';
+      note.querySelector('span').textContent = folder;
+      cluster.replaceChildren();
+      fit();
+      render();
+    };
+
+    const zoomAt = (z, mx, my) => {
+      z = Math.max(0, Math.min(maxZoom, z));
+      const [ox, oy, scale] = origin();
+      const wx = (mx - ox) / scale, wy = (my - oy) / scale;
+      zoom = z;
+      cx = wx - (mx - view.clientWidth / 2) / 2 ** z;
+      cy = wy - (my - view.clientHeight / 2) / 2 ** z;
+      render();
+    };
+
+    // Pan by dragging, without treating the end of a drag as a click
+    let drag = null, dragged = false;
+    view.addEventListener('pointerdown', (e) => {
+      if (e.target.closest('.zoom')) return;
+      drag = { x: e.clientX, y: e.clientY, cx, cy };
+      dragged = false;
+    });
+    window.addEventListener('pointermove', (e) => {
+      if (!drag) return;
+      const dx = e.clientX - drag.x, dy = e.clientY - drag.y;
+      if (Math.abs(dx) + Math.abs(dy) > 3) dragged = true;
+      if (!dragged) return;
+      cx = drag.cx - dx / 2 ** zoom;
+      cy = drag.cy - dy / 2 ** zoom;
+      render();
+    });
+    window.addEventListener('pointerup', () => { drag = null; });
+
+    view.addEventListener('wheel', (e) => {
+      e.preventDefault();
+      const rect = view.getBoundingClientRect();
+      zoomAt(zoom + (e.deltaY < 0 ? 1 : -1), e.clientX - rect.left, e.clientY - rect.top);
+    }, { passive: false });
+    document.getElementById('zoom-in').addEventListener('click', () => zoomAt(zoom + 1, view.clientWidth / 2, view.clientHeight / 2));
+    document.getElementById('zoom-out').addEventListener('click', () => zoomAt(zoom - 1, view.clientWidth / 2, view.clientHeight / 2));
+
+    window.addEventListener('resize', render);
+    window.addEventListener('hashchange', filter);
+    filter();
+  </script>
+</body>
+</html>
+`
+
+var mapPageTemplate = template.Must(template.New("map").Parse(mapTemplate))
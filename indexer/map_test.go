@@ -0,0 +1,89 @@
+package indexer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/image-archive/metadata"
+)
+
+func TestMap(t *testing.T) {
+	tempDir := t.TempDir()
+
+	oldMap, oldTiles, oldNoThumb := mapEnabled, mapTiles, noThumb
+	t.Cleanup(func() { mapEnabled, mapTiles, noThumb = oldMap, oldTiles, oldNoThumb })
+	mapEnabled, mapTiles, noThumb = true, "", false
+
+	a := newArchive(tempDir)
+	a.catalog = catalog{
+		"trips/rome": {
+			{Name: "forum.jpg", Page: 2, ID: "iforum", Meta: metadata.Info{Location: &metadata.Location{Lat: 41.89, Lon: 12.49}}},
+			{Name: "hotel.jpg", Page: 1, ID: "ihotel"},
+		},
+		"home": {{Name: "garden.jpg", Page: 1, ID: "igarden"}},
+	}
+	if err := a.writeMap(); err != nil {
+		t.Fatalf("writeMap failed: %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(tempDir, "map", "index.html"))
+	if err != nil {
+		t.Fatalf("Failed to read map page: %v", err)
+	}
+	page := string(content)
+	for _, want := range []string{
+		`"lat":41.89`,
+		`"href":"../trips/rome/index-2.html#modal-iforum"`,
+		`"thumb":"../trips/rome/.thumbs/forum.jpg"`,
+		`<path d="M`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("map page does not contain %q", want)
+		}
+	}
+	if strings.Contains(page, "hotel.jpg") || strings.Contains(page, "garden.jpg") {
+		t.Errorf("map page plots images without a location")
+	}
+
+	if got, want := a.mapLink("trips"), "../map/index.html#folder=trips"; got != want {
+		t.Errorf("mapLink(trips) = %q; want %q", got, want)
+	}
+	if got, want := a.mapLink("trips/rome"), "../../map/index.html#folder=trips%2Frome"; got != want {
+		t.Errorf("mapLink(trips/rome) = %q; want %q", got, want)
+	}
+	if got := a.mapLink("home"); got != "" {
+		t.Errorf("mapLink(home) = %q; want no link", got)
+	}
+
+	// A tile source replaces the built-in outline.
+	mapTiles = "https://tiles.example.com/{z}/{x}/{y}.png"
+	if err := a.writeMap(); err != nil {
+		t.Fatalf("writeMap failed: %v", err)
+	}
+	content, _ = os.ReadFile(filepath.Join(tempDir, "map", "index.html"))
+	if !strings.Contains(string(content), `data-tiles="https://tiles.example.com/{z}/{x}/{y}.png"`) {
+		t.Errorf("map page does not use the tile source")
+	}
+	if strings.Contains(string(content), `id="outline"`) {
+		t.Errorf("map page draws the outline despite a tile source")
+	}
+}
+
+func TestMercator(t *testing.T) {
+	tests := []struct {
+		lon, lat float64
+		x, y     float64
+	}{
+		{0, 0, 128, 128},
+		{-180, 85.05, 0, 0},
+		{180, -85.05, 256, 256},
+	}
+	for _, tt := range tests {
+		x, y := mercator(tt.lon, tt.lat)
+		if x < tt.x-0.1 || x > tt.x+0.1 || y < tt.y-0.1 || y > tt.y+0.1 {
+			t.Errorf("mercator(%v, %v) = %.2f, %.2f; want %v, %v", tt.lon, tt.lat, x, y, tt.x, tt.y)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package indexer
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// worldOutline is a coarse outline of the land masses as rings of
+// longitude and latitude pairs. It is only meant to give geotagged photos
+// some context on a fully offline map.
+var worldOutline = [][][2]float64{
+	// North America
+	{{-168, 66}, {-162, 70}, {-156, 71.3}, {-141, 69.6}, {-128, 70}, {-115, 68.5}, {-95, 68}, {-90, 69}, {-82, 67},
+		{-82, 63}, {-93, 61}, {-94, 58.5}, {-89, 56.8}, {-82, 55}, {-79, 51.5}, {-78.5, 55}, {-77, 60}, {-73, 62},
+		{-69.5, 59}, {-64.5, 60.3}, {-61.5, 56}, {-56, 52}, {-66, 45}, {-70, 42}, {-76, 38}, {-76, 35}, {-81, 31},
+		{-80, 25.5}, {-82, 27}, {-84, 30}, {-89, 30}, {-94, 29.5}, {-97, 26}, {-97, 22}, {-94, 18.5}, {-90, 21},
+		{-87, 21.5}, {-88, 16}, {-83, 15}, {-83, 10}, {-79.5, 9}, {-77.5, 8}, {-80, 7.5}, {-85, 10}, {-87, 13},
+		{-92, 14.5}, {-95, 16}, {-105, 20}, {-106, 23}, {-110, 27.5}, {-112.7, 31.5}, {-114, 30}, {-110, 23},
+		{-112, 25}, {-115, 29.5}, {-117, 32.5}, {-121, 34.5}, {-124, 40}, {-124, 46}, {-124.7, 48.5}, {-127, 50.5},
+		{-131, 54}, {-135, 58}, {-140, 60}, {-147, 61}, {-152, 59}, {-158, 57}, {-165, 54.5}, {-158, 58.5},
+		{-162, 60}, {-165, 62.5}, {-164, 64.5}},
+	// Baffin Island
+	{{-80, 73.5}, {-72, 71.5}, {-68, 70}, {-62, 66.5}, {-65, 63.5}, {-72, 64}, {-78, 64.5}, {-78, 68}, {-84, 70},
+		{-90, 72}},
+	// Greenland
+	{{-73, 78}, {-65, 80}, {-60, 82}, {-40, 83.5}, {-22, 82.5}, {-18, 80}, {-20, 75}, {-22, 71}, {-27, 68.5},
+		{-35, 66}, {-40, 65}, {-43, 60}, {-48, 61}, {-51, 64}, {-53, 66.5}, {-54, 70}, {-56, 74}, {-61, 76}, {-70, 77}},
+	// Cuba
+	{{-85, 21.8}, {-82, 23.1}, {-77.5, 22.4}, {-74.2, 20.2}, {-77.3, 19.9}, {-79.5, 21.6}},
+	// South America
+	{{-77.5, 8}, {-72, 12}, {-62, 10.5}, {-52, 5}, {-50, 0}, {-44, -2.5}, {-35, -5.5}, {-35, -9}, {-39, -13},
+		{-39, -18}, {-41, -22}, {-48, -26}, {-53, -34}, {-58, -38.5}, {-62, -39}, {-65, -42}, {-67, -46}, {-69, -51},
+		{-68.5, -55}, {-72, -54}, {-75, -50}, {-73.5, -42}, {-73.5, -37}, {-71.5, -30}, {-70, -18}, {-76, -14},
+		{-81, -6}, {-80, -1}, {-79.5, 3}, {-78, 6.5}},
+	// Iceland
+	{{-24, 65.5}, {-22, 66.4}, {-16, 66.5}, {-13.5, 65.2}, {-15, 64.3}, {-19, 63.4}, {-22.5, 63.8}},
+	// Great Britain
+	{{-5.7, 50}, {1.5, 51.2}, {1.7, 52.7}, {0, 53.5}, {-1.5, 55}, {-2, 56}, {-1.8, 57.6}, {-3.3, 58.6}, {-5, 58.6},
+		{-6.2, 57.5}, {-5.6, 56}, {-4.8, 54.8}, {-3, 54}, {-3, 53.3}, {-4.5, 53.2}, {-4.3, 51.7}},
+	// Ireland
+	{{-6, 52.2}, {-6.2, 54}, {-7.3, 55.3}, {-8.5, 54.3}, {-10, 53.5}, {-10.2, 51.6}, {-8.5, 51.6}},
+	// Eurasia
+	{{-9.5, 37}, {-9, 43}, {-1.5, 43.5}, {-1.5, 46.5}, {-4.5, 48.5}, {1.5, 50.9}, {4, 51.5}, {8.5, 53.5}, {8.6, 57},
+		{10.5, 57.7}, {10.5, 54.5}, {14, 54}, {21, 55}, {21.5, 57}, {24, 57.3}, {23.5, 59.5}, {30, 60}, {22.5, 60.5},
+		{21.5, 63}, {25, 65}, {21.5, 65.7}, {17, 61.5}, {19, 60}, {16.5, 56.5}, {13, 55.5}, {11, 59}, {5.5, 58.5},
+		{5, 62}, {10.5, 64.5}, {14, 67.5}, {18, 69.8}, {25, 71.1}, {31, 70.3}, {33, 69.3}, {41, 67}, {36, 64.5},
+		{44, 66.2}, {44, 68.3}, {53, 68.8}, {60, 68.7}, {69, 73}, {72.5, 72.8}, {80, 73.5}, {87, 75}, {100, 76.5},
+		{105, 77.7}, {113, 73.8}, {128, 72.4}, {140, 72.5}, {150, 71.5}, {160, 69.5}, {170, 70}, {180, 68.9},
+		{180, 65}, {178, 64.5}, {173, 61}, {163, 59.5}, {163, 56}, {156.5, 51}, {156, 57.5}, {142, 59.3},
+		{135, 54.5}, {141, 53}, {140, 48}, {133, 43}, {129.5, 42.4}, {128.5, 38.5}, {129.3, 35.3}, {126.5, 34.4},
+		{126.2, 37.7}, {124.5, 39.8}, {121.5, 40.9}, {121, 39}, {118, 39}, {119.5, 37}, {122.5, 37}, {119, 34.5},
+		{121.8, 31}, {122, 29}, {119, 25}, {114, 22.3}, {109.5, 21.5}, {108, 21.5}, {106, 18}, {109, 12},
+		{105, 8.6}, {103, 10.5}, {100.5, 13.5}, {99.5, 10}, {100.3, 6.5}, {103.5, 1.3}, {101, 2.8}, {98.3, 8},
+		{98.5, 13}, {97.5, 16.5}, {94.3, 16}, {94, 19.5}, {92, 21.5}, {90, 21.8}, {87, 21}, {86.5, 20},
+		{80.2, 15.5}, {80.3, 13}, {78, 8.1}, {76.5, 9}, {74.5, 15}, {72.8, 19}, {72.5, 21.5}, {70, 22.5},
+		{66.5, 25.4}, {61.6, 25.2}, {57.3, 25.8}, {56.5, 27.1}, {51.5, 27.9}, {48.5, 29.9}, {50.2, 26.2},
+		{51.5, 24.3}, {56, 26}, {59.8, 22.5}, {58.5, 20.5}, {55, 17}, {52, 15.9}, {45, 12.9}, {43.3, 12.7},
+		{42.7, 16}, {39, 21.5}, {35, 28}, {34.2, 31.2}, {35.5, 33.9}, {36, 36.3}, {32.5, 36.1}, {30, 36.2},
+		{27.2, 37}, {26.2, 39.5}, {26.7, 40.4}, {29, 41}, {33.5, 42}, {38, 41}, {41.5, 41.6}, {37.5, 44.7},
+		{39, 47.3}, {35, 45.5}, {33.5, 44.5}, {30.8, 46.5}, {28.6, 43.7}, {28, 41.8}, {26, 40.8}, {24, 40.7},
+		{23, 39.5}, {22.5, 36.5}, {21, 38.3}, {19.5, 41.8}, {16, 43.5}, {13.7, 45.6}, {12.3, 45}, {13.5, 43.6},
+		{16, 41.9}, {18.5, 40.2}, {17, 39.2}, {16, 38}, {15.6, 38.2}, {15.6, 40.1}, {13, 41.2}, {11, 42.4},
+		{10, 44}, {8.5, 44.3}, {6.5, 43.1}, {3.2, 43.2}, {3.2, 41.9}, {0.9, 41}, {-0.4, 39.4}, {0.2, 38.7},
+		{-2.2, 36.7}, {-5.6, 36}, {-7.5, 37.2}},
+	// Africa
+	{{-17, 21}, {-16, 28}, {-9.5, 30}, {-6, 35.8}, {0, 35.9}, {10, 37.2}, {11, 33}, {20, 31}, {25, 31.7}, {32, 31},
+		{34, 28}, {38.5, 18}, {43, 12.5}, {51, 11.8}, {51, 10.5}, {47, 5}, {41, -2}, {39.5, -7}, {40.5, -11},
+		{40.5, -15.5}, {35.3, -22}, {32.6, -26}, {32.5, -28.5}, {28, -33}, {20, -34.8}, {18.3, -33.5},
+		{14.5, -22.5}, {11.8, -17}, {13.5, -12}, {12, -5}, {9, -1}, {9.7, 3.5}, {6, 4.3}, {2, 6.3}, {-4, 5.2},
+		{-8, 4.4}, {-13, 8}, {-17, 14.5}},
+	// Madagascar
+	{{49.3, -12}, {50.5, -15.5}, {47.2, -25}, {44, -24.5}, {43.3, -21.5}, {44.4, -16.2}},
+	// Sri Lanka
+	{{79.8, 9.8}, {81.3, 8.5}, {81.8, 7}, {80.5, 5.9}, {79.8, 7}},
+	// Japan
+	{{130, 31}, {131.5, 31.5}, {132, 33.8}, {135, 33.5}, {137, 34.5}, {140, 35}, {141, 38}, {142, 40},
+		{141.5, 41.5}, {140, 41.5}, {140, 40}, {139.7, 38.5}, {139, 37.5}, {136.8, 37.3}, {135.5, 35.6},
+		{132.5, 35.5}, {131, 34.3}, {130, 33.5}},
+	{{140, 41.5}, {141.5, 42.5}, {143.5, 42}, {145.5, 43.3}, {142, 45.4}, {141.5, 44}, {140, 43}},
+	// Taiwan
+	{{121, 25.3}, {122, 25}, {121.5, 23}, {120.7, 21.9}, {120.1, 23}},
+	// Philippines
+	{{120.5, 18.5}, {122.3, 18.5}, {122, 16}, {121.5, 14}, {124, 12.5}, {125.5, 9.5}, {126.5, 7}, {125.5, 6},
+		{124, 6.5}, {122, 7}, {123, 9}, {121.5, 11}, {120, 14.5}},
+	// Sumatra, Java, Borneo and New Guinea
+	{{95.3, 5.6}, {98, 4.2}, {103.7, -1}, {106, -3}, {105.8, -5.8}, {104.5, -5.9}, {101, -2.6}, {98.7, 1.8}},
+	{{105.2, -6.8}, {108.4, -6.4}, {112.5, -6.9}, {114.5, -7.8}, {110.5, -8.2}, {106.5, -7.4}},
+	{{109, 1.5}, {110, -1.5}, {111.5, -3}, {114.5, -3.7}, {116.5, -2.5}, {117.5, 0.5}, {119, 5}, {117, 7},
+		{115, 5}, {113, 3.2}, {111, 1.6}},
+	{{131, -1.5}, {134, -1}, {138, -1.6}, {141, -2.6}, {145, -4.4}, {148, -8}, {150.5, -10.5}, {147, -10},
+		{144, -7.7}, {141, -9.1}, {138, -8.3}, {137.8, -5}, {135, -4.3}, {133, -4}},
+	// Australia
+	{{113.5, -22}, {114, -26}, {115, -34}, {118, -35}, {124, -33.8}, {129, -31.6}, {131, -31.5}, {134, -32.7},
+		{138, -35.6}, {140, -38}, {144, -38.3}, {146.5, -39}, {150, -37.5}, {151.3, -33.8}, {153.6, -28.5},
+		{153, -25.3}, {150.8, -22.5}, {146.3, -19}, {145.3, -15}, {143.5, -14}, {142.5, -10.8}, {141.5, -13},
+		{141.6, -17}, {140, -17.7}, {137, -15.8}, {136.7, -12.2}, {133, -11.3}, {130, -12.6}, {129, -15},
+		{126, -14}, {122.2, -17}, {121, -19.5}, {117, -20.6}},
+	// Tasmania
+	{{144.6, -40.7}, {148.3, -40.9}, {148, -43}, {146, -43.6}},
+	// New Zealand
+	{{172.7, -34.5}, {174.6, -36}, {176, -37.6}, {178.5, -37.7}, {177, -39.3}, {176.6, -40.3}, {175.2, -41.6},
+		{174.6, -39.8}, {173.8, -39.2}, {174.6, -37}},
+	{{172.7, -40.5}, {174.2, -41.3}, {173.5, -42.5}, {171.2, -44.5}, {169, -46.6}, {166.5, -46}, {168, -44},
+		{171, -42.3}},
+	// Antarctica
+	{{-180, -85}, {180, -85}, {180, -78}, {165, -77}, {165, -70}, {140, -66.5}, {110, -66}, {90, -66.5},
+		{70, -68}, {55, -66}, {30, -69.5}, {0, -70}, {-20, -73}, {-40, -78}, {-60, -75}, {-62, -64}, {-58, -63},
+		{-70, -69}, {-75, -73}, {-100, -74}, {-130, -74}, {-150, -77}, {-165, -78}, {-180, -78}},
+}
+
+// worldPath is worldOutline as SVG path data in world coordinates.
+var worldPath = outlinePath(worldOutline)
+
+// mercator projects a position to web mercator world coordinates, where
+// the world spans 0 to 256 on both axes.
+func mercator(lon, lat float64) (x, y float64) {
+	lat = max(-85.05, min(85.05, lat)) * math.Pi / 180
+	x = (lon + 180) / 360 * 256
+	y = (1 - math.Log(math.Tan(lat)+1/math.Cos(lat))/math.Pi) / 2 * 256
+	return x, y
+}
+
+// outlinePath returns SVG path data drawing each ring as a closed shape.
+func outlinePath(rings [][][2]float64) string {
+	var b strings.Builder
+	for _, ring := range rings {
+		for i, p := range ring {
+			x, y := mercator(p[0], p[1])
+			cmd := "L"
+			if i == 0 {
+				cmd = "M"
+			}
+			fmt.Fprintf(&b, "%s%.1f %.1f", cmd, x, y)
+		}
+		b.WriteString("Z")
+	}
+	return b.String()
+}
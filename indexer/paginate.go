@@ -0,0 +1,98 @@
+package indexer
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var pageSize int // --page-size, 0 keeps every image on a single page
+
+// Pager links a page to its neighbours when a directory is split over
+// several pages.
+type Pager struct {
+	Page      int    // 1-based page number
+	Pages     int    // Total number of pages
+	Prev      string // Link to the previous page, empty on the first page
+	Next      string // Link to the next page, empty on the last page
+	PrevLast  string // Modal ID of the last image on the previous page
+	NextFirst string // Modal ID of the first image on the next page
+}
+
+// pageFileName returns the file name of the n-th page of a directory.
+func pageFileName(n int) string {
+	if n <= 1 {
+		return "index.html"
+	}
+	return "index-" + strconv.Itoa(n) + ".html"
+}
+
+// isPageFile reports whether name is a page written by GenerateIndexHTML.
+func isPageFile(name string) bool {
+	if name == "index.html" {
+		return true
+	}
+	n, ok := strings.CutPrefix(name, "index-")
+	if !ok {
+		return false
+	}
+	n, ok = strings.CutSuffix(n, ".html")
+	if !ok {
+		return false
+	}
+	_, err := strconv.Atoi(n)
+	return err == nil
+}
+
+// paginate splits images into pages of at most pageSize entries and
+// returns the pager for each page. There is always at least one page.
+func paginate(images []Image) ([][]Image, []Pager) {
+	size := pageSize
+	if size <= 0 || len(images) <= size {
+		return [][]Image{images}, []Pager{{Page: 1, Pages: 1}}
+	}
+
+	var pages [][]Image
+	for start := 0; start < len(images); start += size {
+		pages = append(pages, images[start:min(start+size, len(images))])
+	}
+
+	pagers := make([]Pager, len(pages))
+	for i := range pages {
+		p := Pager{Page: i + 1, Pages: len(pages)}
+		if i > 0 {
+			prev := pages[i-1]
+			p.Prev = pageFileName(i)
+			p.PrevLast = prev[len(prev)-1].ID
+		}
+		if i < len(pages)-1 {
+			p.Next = pageFileName(i + 2)
+			p.NextFirst = pages[i+1][0].ID
+		}
+		pagers[i] = p
+	}
+	return pages, pagers
+}
+
+// removeStalePages deletes numbered pages beyond the current page count,
+// left over from a time when the directory held more images.
+func removeStalePages(dir string, pages int) error {
+	matches, err := filepath.Glob(filepath.Join(dir, "index-*.html"))
+	if err != nil {
+		return err
+	}
+	for _, match := range matches {
+		name := filepath.Base(match)
+		if !isPageFile(name) {
+			continue
+		}
+		n, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "index-"), ".html"))
+		if n > pages {
+			if err := os.Remove(match); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
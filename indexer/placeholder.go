@@ -0,0 +1,44 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"html/template"
+	"image"
+	"image/png"
+	"os"
+
+	"golang.org/x/image/draw"
+)
+
+// placeholderSize is the side, in pixels, of the placeholders shown in
+// the grid until thumbnails load. Browsers blur them as they scale them up.
+const placeholderSize = 8
+
+// makePlaceholder returns the placeholder of the image, or thumbnail, at
+// file as a PNG data URI.
+func makePlaceholder(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return "", err
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, placeholderSize, placeholderSize))
+	draw.BiLinear.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	var buf bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := enc.Encode(&buf, dst); err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// PlaceholderURL returns the placeholder of the image for use in CSS.
+func (img Image) PlaceholderURL() template.URL {
+	return template.URL(img.Placeholder)
+}
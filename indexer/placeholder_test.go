@@ -0,0 +1,30 @@
+package indexer
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestPlaceholders(t *testing.T) {
+	root := t.TempDir()
+	writeTestImage(t, filepath.Join(root, "a.jpg"), 40, 30)
+
+	placeholder := regexp.MustCompile(`<img loading="lazy" src=".thumbs/a.jpg" alt="[^"]*" style="background-image: url\((data:image/png;base64,[A-Za-z0-9+/=]+)\)">`)
+	SplitCreate(root)
+	page, _ := os.ReadFile(filepath.Join(root, "index.html"))
+	m := placeholder.FindSubmatch(page)
+	if m == nil {
+		t.Fatalf("page has no placeholder:\n%s", page)
+	}
+
+	// The placeholder is kept in the catalog rather than read again from the
+	// thumbnail.
+	os.WriteFile(filepath.Join(root, ".thumbs", "a.jpg"), []byte("not an image"), 0644)
+	SplitCreate(root)
+	page, _ = os.ReadFile(filepath.Join(root, "index.html"))
+	if again := placeholder.FindSubmatch(page); again == nil || string(again[1]) != string(m[1]) {
+		t.Errorf("cached placeholder was not used")
+	}
+}
@@ -0,0 +1,78 @@
+package indexer
+
+import (
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path"
+	"path/filepath"
+
+	"golang.org/x/image/draw"
+)
+
+var previewSize = 2048 // --preview-size
+
+// previewsDir is the directory, next to .thumbs, holding the screen-size
+// previews shown in place of large originals.
+const previewsDir = ".previews"
+
+// previewPath returns the path of the preview of the image at the slash
+// path p.
+func previewPath(p string) string {
+	return path.Join(path.Dir(p), previewsDir, path.Base(p))
+}
+
+// stale reports whether the file derived from the image at src, such as its
+// thumbnail, is missing or older than the image.
+func stale(src, derived string) bool {
+	info, err := os.Stat(derived)
+	if err != nil {
+		return true
+	}
+	orig, err := os.Stat(src)
+	return err == nil && info.ModTime().Before(orig.ModTime())
+}
+
+// needsPreview reports whether the image at file is too large to be shown
+// as it is. Images of unknown size get no preview.
+func needsPreview(file string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	return err == nil && max(cfg.Width, cfg.Height) > previewSize
+}
+
+// writePreview writes img scaled down to fit previewSize as a JPEG, or as a
+// PNG if it has transparency.
+func writePreview(img image.Image, previewPath string) error {
+	if err := os.MkdirAll(filepath.Dir(previewPath), os.ModePerm); err != nil {
+		return err
+	}
+	out, err := os.Create(previewPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if hasAlpha(img) {
+		return png.Encode(out, scaleToFit(img, previewSize))
+	}
+	return jpeg.Encode(out, scaleToFit(img, previewSize), &jpeg.Options{Quality: 85})
+}
+
+// scaleToFit returns img scaled down, keeping its proportions, so that its
+// longest side is size pixels.
+func scaleToFit(img image.Image, size int) image.Image {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if w >= h {
+		w, h = size, max(1, h*size/w)
+	} else {
+		w, h = max(1, w*size/h), size
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,53 @@
+package indexer
+
+import (
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPreviews(t *testing.T) {
+	root := t.TempDir()
+	oldSize := previewSize
+	t.Cleanup(func() { previewSize = oldSize })
+	previewSize = 100
+	writeTestImage(t, filepath.Join(root, "large.jpg"), 400, 200)
+	writeTestImage(t, filepath.Join(root, "small.jpg"), 80, 60)
+
+	SplitCreate(root)
+	preview := filepath.Join(root, previewsDir, "large.jpg")
+	f, err := os.Open(preview)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := jpeg.DecodeConfig(f)
+	f.Close()
+	if err != nil || cfg.Width != 100 || cfg.Height != 50 {
+		t.Errorf("preview is %dx%d (%v); want 100x50", cfg.Width, cfg.Height, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, previewsDir, "small.jpg")); err == nil {
+		t.Errorf("an image that fits the screen got a preview")
+	}
+
+	page, _ := os.ReadFile(filepath.Join(root, "index.html"))
+	for _, want := range []string{
+		`src=".previews/large.jpg" data-original="large.jpg"`,
+		`<a class="original" href="large.jpg" target="_blank">View original</a>`,
+		`src="small.jpg" data-original="small.jpg"`,
+	} {
+		if !strings.Contains(string(page), want) {
+			t.Errorf("page lacks %s", want)
+		}
+	}
+
+	// A preview older than its image is regenerated.
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(preview, past, past)
+	SplitCreate(root)
+	if info, _ := os.Stat(preview); !info.ModTime().After(past) {
+		t.Errorf("stale preview was not regenerated")
+	}
+}
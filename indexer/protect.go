@@ -0,0 +1,522 @@
+package indexer
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/image-archive/state"
+)
+
+// protectedFile is the state file holding the keys of protected folders.
+const protectedFile = "protected.json"
+
+// ExcludeFile is the state file listing, one rsync pattern per line, what
+// must not be published of the archive: the state directory and the
+// unencrypted files of protected folders.
+const ExcludeFile = "publish-exclude"
+
+// lockedDir is the directory next to the images and thumbnails of a
+// protected folder holding their encrypted copies.
+const lockedDir = ".locked"
+
+// pbkdf2Iterations is the work factor of the key derivation, paid once
+// per browser session when opening a protected folder.
+const pbkdf2Iterations = 600000
+
+// lock is the key protecting a folder, along with what the browser needs
+// to derive it again from the password.
+type lock struct {
+	Salt       []byte
+	Iterations int
+	Key        []byte // AES-256 key, PBKDF2-SHA256 of the password
+}
+
+// readLocks returns the locks of the archive at root by the slash path of
+// their folder.
+func readLocks(root string) (map[string]lock, error) {
+	locks := make(map[string]lock)
+	data, err := os.ReadFile(state.Path(root, protectedFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return locks, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return locks, json.Unmarshal(data, &locks)
+}
+
+func writeLocks(root string, locks map[string]lock) error {
+	data, err := json.MarshalIndent(locks, "", "  ")
+	if err != nil {
+		return err
+	}
+	return state.WriteFile(state.Path(root, protectedFile), data)
+}
+
+// Protect encrypts the pages and images of the folder at the slash path rel
+// and of the folders below it with password, from the next indexing of the
+// archive at root on. Changing the password drops the files encrypted with
+// the previous one.
+func Protect(root, rel, password string) error {
+	rel, err := folderPath(root, rel)
+	if err != nil {
+		return err
+	}
+	if password == "" {
+		return errors.New("empty password")
+	}
+	locks, err := readLocks(root)
+	if err != nil {
+		return err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, pbkdf2Iterations, 32)
+	if err != nil {
+		return err
+	}
+	locks[rel] = lock{Salt: salt, Iterations: pbkdf2Iterations, Key: key}
+	if err := removeLocked(filepath.Join(root, filepath.FromSlash(rel))); err != nil {
+		return err
+	}
+	return writeLocks(root, locks)
+}
+
+// Unprotect publishes the folder at the slash path rel in clear again from
+// the next indexing on.
+func Unprotect(root, rel string) error {
+	rel, err := folderPath(root, rel)
+	if err != nil {
+		return err
+	}
+	locks, err := readLocks(root)
+	if err != nil {
+		return err
+	}
+	if _, ok := locks[rel]; !ok {
+		return fmt.Errorf("%s is not protected", rel)
+	}
+	delete(locks, rel)
+	if err := removeLocked(filepath.Join(root, filepath.FromSlash(rel))); err != nil {
+		return err
+	}
+	return writeLocks(root, locks)
+}
+
+// folderPath returns the clean slash path rel after checking that it is a
+// folder of images of the archive at root.
+func folderPath(root, rel string) (string, error) {
+	rel = path.Clean(strings.Trim(rel, "/"))
+	if rel == ".." || strings.HasPrefix(rel, "../") || path.IsAbs(rel) {
+		return "", fmt.Errorf("%s is outside the archive", rel)
+	}
+	for _, name := range strings.Split(rel, "/") {
+		if strings.HasPrefix(name, ".") && name != "." {
+			return "", fmt.Errorf("%s is a hidden folder", rel)
+		}
+	}
+	if IsGenerated(root, rel) {
+		return "", fmt.Errorf("%s holds generated pages", rel)
+	}
+	info, err := os.Stat(filepath.Join(root, filepath.FromSlash(rel)))
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a folder", rel)
+	}
+	return rel, nil
+}
+
+// removeLocked removes the encrypted copies in dir and below it.
+func removeLocked(dir string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == lockedDir {
+			if err := os.RemoveAll(p); err != nil {
+				return err
+			}
+			return fs.SkipDir
+		}
+		return nil
+	})
+}
+
+// lockOf returns the protected folder covering the directory rel, the
+// closest one if several are nested, or "" if rel is public.
+func (a *archive) lockOf(rel string) string {
+	for d := rel; ; d = path.Dir(d) {
+		if _, ok := a.locks[d]; ok {
+			return d
+		}
+		if d == "." {
+			return ""
+		}
+	}
+}
+
+// IsProtected reports whether the folder at the slash path rel of the
+// archive at root is protected by a password, itself or through an
+// ancestor. An unreadable lock file counts as protecting everything.
+func IsProtected(root, rel string) bool {
+	locks, err := readLocks(root)
+	if err != nil {
+		return true
+	}
+	a := &archive{root: root, locks: locks}
+	return a.lockOf(rel) != ""
+}
+
+// publicCatalog returns the catalog without the protected folders, which
+// archive-wide pages must not reveal.
+func (a *archive) publicCatalog() catalog {
+	if len(a.locks) == 0 {
+		return a.catalog
+	}
+	public := make(catalog)
+	for dir, entries := range a.catalog {
+		if a.lockOf(dir) == "" {
+			public[dir] = entries
+		}
+	}
+	return public
+}
+
+// pruneTree drops from the tree the folders inside protected folders, so
+// that their names stay private.
+func (a *archive) pruneTree(n *TreeNode) {
+	for _, c := range n.Children {
+		if _, ok := a.locks[c.Path]; ok {
+			c.Children = nil
+		} else {
+			a.pruneTree(c)
+		}
+	}
+}
+
+// seal encrypts data with AES-GCM. The nonce is derived from the data, so
+// an unchanged page encrypts to the same bytes and is not rewritten, while
+// different contents never share a nonce.
+func seal(key, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("nonce"))
+	mac = hmac.New(sha256.New, mac.Sum(nil))
+	mac.Write(data)
+	nonce := mac.Sum(nil)[:gcm.NonceSize()]
+	return gcm.Seal(nonce, nonce, data, nil), nil
+}
+
+// lockedPath returns the path of the encrypted copy of the file at p.
+func lockedPath(p string) string {
+	return filepath.Join(filepath.Dir(p), lockedDir, filepath.Base(p))
+}
+
+// lockImages writes the encrypted copies of the images of a protected
+// directory and of their thumbnails and previews, and removes those of
+// images that are gone.
+func lockImages(dir string, images []Image, key []byte) {
+	files := make(map[string]bool)
+	for _, img := range images {
+		files[filepath.Join(dir, img.Name)] = true
+		if !noThumb {
+			files[filepath.Join(dir, ".thumbs", img.Name)] = true
+		}
+		if img.Preview != "" {
+			files[filepath.Join(dir, previewsDir, img.Name)] = true
+		}
+	}
+	for file := range files {
+		if err := lockFile(file, key); err != nil {
+			log.Printf("Failed to encrypt %s: %v", file, err)
+		}
+	}
+
+	for _, d := range []string{filepath.Join(dir, lockedDir), filepath.Join(dir, ".thumbs", lockedDir), filepath.Join(dir, previewsDir, lockedDir)} {
+		items, _ := os.ReadDir(d)
+		for _, item := range items {
+			if !files[filepath.Join(filepath.Dir(d), item.Name())] {
+				os.Remove(filepath.Join(d, item.Name()))
+			}
+		}
+	}
+}
+
+// lockFile writes the encrypted copy of file unless it is up to date. The
+// copy takes the modification time of the file to tell.
+func lockFile(file string, key []byte) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	out := lockedPath(file)
+	if o, err := os.Stat(out); err == nil && o.ModTime().Equal(info.ModTime()) {
+		return nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	sealed, err := seal(key, data)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(out), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.WriteFile(out, sealed, 0644); err != nil {
+		return err
+	}
+	return os.Chtimes(out, info.ModTime(), info.ModTime())
+}
+
+// LockedData holds the data for the page standing in for a page of a
+// protected folder.
+type LockedData struct {
+	Title      string
+	Salt       string // Base64 salt of the key derivation
+	Iterations int
+	Page       string // Base64 of the encrypted page
+}
+
+// writeLockedPage writes the page for data encrypted with l.
+func writeLockedPage(file string, data PageData, l lock) error {
+	var page bytes.Buffer
+	if err := pageTemplate.Execute(&page, data); err != nil {
+		return err
+	}
+	sealed, err := seal(l.Key, page.Bytes())
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	err = lockedPageTemplate.Execute(&buf, LockedData{
+		Title:      data.Title,
+		Salt:       base64.StdEncoding.EncodeToString(l.Salt),
+		Iterations: l.Iterations,
+		Page:       base64.StdEncoding.EncodeToString(sealed),
+	})
+	if err != nil {
+		return err
+	}
+	return writeIfChanged(file, buf.Bytes())
+}
+
+// writeExcludes writes the exclude file listing the state directory, the
+// fixity manifest, which names the files of every folder, and every
+// unencrypted file of the protected folders, or removes it when no folder is
+// protected.
+func (a *archive) writeExcludes() error {
+	file := state.Path(a.root, ExcludeFile)
+	if len(a.locks) == 0 {
+		if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	patterns := []string{"/" + state.Dir + "/", "/" + escapePattern(ManifestFile)}
+	var dirs []string
+	for dir := range a.catalog {
+		if a.lockOf(dir) != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	slices.SortFunc(dirs, naturalCompare)
+	for _, dir := range dirs {
+		for _, sub := range []string{"", ".thumbs", previewsDir} {
+			items, _ := os.ReadDir(filepath.Join(a.root, filepath.FromSlash(dir), sub))
+			for _, item := range items {
+				name := item.Name()
+				p := path.Join("/", dir, sub, name)
+				switch {
+				case name == lockedDir, sub == "" && (name == ".thumbs" || name == previewsDir):
+				case item.IsDir() && !strings.HasPrefix(name, "."):
+					// Folders of images have their own entries.
+				case item.IsDir():
+					patterns = append(patterns, escapePattern(p)+"/")
+				case sub == "" && isPageFile(name):
+				default:
+					patterns = append(patterns, escapePattern(p))
+				}
+			}
+		}
+	}
+	return state.WriteFile(file, []byte(strings.Join(patterns, "\n")+"\n"))
+}
+
+// escapePattern quotes the wildcards of an rsync pattern.
+func escapePattern(p string) string {
+	var b strings.Builder
+	for _, r := range p {
+		if strings.ContainsRune(`\*?[`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// HTML template for the pages of protected folders, which ask for the
+// password and decrypt the page and its images in the browser.
+var lockedTemplate = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8">
+  <title>{{.Title}}</title>
+  <style>
+    body {
+      font-family: Arial, sans-serif;
+      display: flex;
+      height: 100vh;
+      margin: 0;
+      justify-content: center;
+      align-items: center;
+      background: #f0f0f0;
+    }
+    form {
+      display: flex;
+      flex-direction: column;
+      gap: 10px;
+      padding: 20px;
+      max-width: 360px;
+      background: #fff;
+      border-radius: 4px;
+      box-shadow: 0 2px 6px rgba(0, 0, 0, 0.3);
+    }
+    input { padding: 6px 10px; }
+    .error { color: #b00; }
+  </style>
+</head>
+<body>
+  <form id="unlock" data-salt="{{.Salt}}" data-iterations="{{.Iterations}}" data-page="{{.Page}}">
+    <h1>{{.Title}}</h1>
+    <label for="password">This folder is protected. Enter its password to view it.</label>
+    <input type="password" id="password" autocomplete="current-password" autofocus>
+    <button type="submit">Open</button>
+    <p class="error" hidden></p>
+    <noscript>Viewing this folder needs JavaScript.</noscript>
+  </form>
+  <script>
+  (() => {
+    const form = document.getElementById('unlock');
+    const error = form.querySelector('.error');
+    const bytes = text => Uint8Array.from(atob(text), c => c.charCodeAt(0));
+    const decrypt = (key, data) => crypto.subtle.decrypt({name: 'AES-GCM', iv: data.slice(0, 12)}, key, data.slice(12));
+
+    // The key is kept for the browser session, so that the other pages of
+    // the folder open without asking again.
+    const saved = 'ima-key-' + form.dataset.salt;
+
+    const open = async raw => {
+      const key = await crypto.subtle.importKey('raw', raw, 'AES-GCM', false, ['decrypt']);
+      const html = new TextDecoder().decode(await decrypt(key, bytes(form.dataset.page)));
+      sessionStorage.setItem(saved, btoa(String.fromCharCode(...raw)));
+
+      // Images are encrypted too; they are fetched and decrypted once in view.
+      const doc = new DOMParser().parseFromString(html, 'text/html');
+      for (const img of doc.querySelectorAll('img[src]')) {
+        img.dataset.locked = img.getAttribute('src');
+        img.removeAttribute('src');
+      }
+      document.documentElement.replaceWith(document.adoptNode(doc.documentElement));
+      // Scripts of a parsed document do not run, fresh copies do.
+      for (const old of document.querySelectorAll('script')) {
+        const script = document.createElement('script');
+        script.textContent = old.textContent;
+        old.replaceWith(script);
+      }
+      document.dispatchEvent(new Event('DOMContentLoaded'));
+
+      // unlockFile fetches the encrypted copy of the file linked as src and
+      // returns a URL to it decrypted.
+      const types = {jpg: 'image/jpeg', jpeg: 'image/jpeg', png: 'image/png', gif: 'image/gif'};
+      const unlockFile = src => {
+        const i = src.lastIndexOf('/') + 1;
+        return fetch(src.slice(0, i) + '.locked/' + src.slice(i))
+          .then(res => res.ok ? res.arrayBuffer() : Promise.reject(new Error(res.statusText)))
+          .then(data => decrypt(key, new Uint8Array(data)))
+          .then(data => URL.createObjectURL(new Blob([data], {type: types[src.split('.').pop().toLowerCase()] || ''})));
+      };
+
+      const observer = new IntersectionObserver(entries => {
+        for (const entry of entries) {
+          if (!entry.isIntersecting) continue;
+          const img = entry.target;
+          observer.unobserve(img);
+          unlockFile(img.dataset.locked)
+            .then(url => { img.src = url; })
+            .catch(err => console.error('Cannot decrypt ' + img.dataset.locked, err));
+        }
+      }, {rootMargin: '200px'});
+      document.querySelectorAll('img[data-locked]').forEach(img => observer.observe(img));
+
+      // Originals open decrypted in a new tab.
+      document.querySelectorAll('a.original').forEach(link => link.addEventListener('click', e => {
+        e.preventDefault();
+        const tab = window.open();
+        unlockFile(link.getAttribute('href'))
+          .then(url => { tab.location = url; })
+          .catch(err => { tab.close(); console.error('Cannot decrypt ' + link.getAttribute('href'), err); });
+      }));
+    };
+
+    form.addEventListener('submit', async e => {
+      e.preventDefault();
+      error.hidden = true;
+      try {
+        const password = new TextEncoder().encode(document.getElementById('password').value);
+        const material = await crypto.subtle.importKey('raw', password, 'PBKDF2', false, ['deriveBits']);
+        const raw = await crypto.subtle.deriveBits({
+          name: 'PBKDF2',
+          hash: 'SHA-256',
+          salt: bytes(form.dataset.salt),
+          iterations: Number(form.dataset.iterations),
+        }, material, 256);
+        await open(new Uint8Array(raw));
+      } catch (err) {
+        // Browsers only offer decryption to pages served over HTTPS.
+        error.textContent = err.name === 'OperationError' ? 'Wrong password' : 'This browser cannot open the folder: ' + err.message;
+        error.hidden = false;
+      }
+    });
+
+    if (sessionStorage.getItem(saved)) {
+      open(bytes(sessionStorage.getItem(saved))).catch(() => sessionStorage.removeItem(saved));
+    }
+  })();
+  </script>
+</body>
+</html>
+`
+
+var lockedPageTemplate = template.Must(template.New("locked").Parse(lockedTemplate))
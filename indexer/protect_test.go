@@ -0,0 +1,161 @@
+package indexer
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/image-archive/state"
+)
+
+// unlock decrypts what seal encrypted, deriving the key from the password
+// like the page does in the browser.
+func unlock(t *testing.T, password string, salt []byte, iterations int, data []byte) []byte {
+	t.Helper()
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := aes.NewCipher(key)
+	gcm, _ := cipher.NewGCM(block)
+	plain, err := gcm.Open(nil, data[:12], data[12:], nil)
+	if err != nil {
+		t.Fatalf("decrypting: %v", err)
+	}
+	return plain
+}
+
+func TestProtect(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"public/p.jpg", "private/secret.jpg", "private/inner/deep.jpg"} {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		writeTestImage(t, p, 40, 30)
+	}
+	oldSearch := searchEnabled
+	t.Cleanup(func() { searchEnabled = oldSearch })
+	searchEnabled = true
+
+	if err := Protect(root, "../elsewhere", "pw"); err == nil {
+		t.Errorf("Protect accepted a folder outside the archive")
+	}
+	if err := Protect(root, "private", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	SplitCreate(root)
+
+	// The page only holds the encrypted page, which the password opens.
+	page, err := os.ReadFile(filepath.Join(root, "private", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(page, []byte("secret.jpg")) || bytes.Contains(page, []byte("inner")) {
+		t.Errorf("protected page reveals its contents:\n%s", page)
+	}
+	field := func(name string) string {
+		m := regexp.MustCompile(`data-` + name + `="([^"]*)"`).FindSubmatch(page)
+		if m == nil {
+			t.Fatalf("protected page has no data-%s", name)
+		}
+		return strings.ReplaceAll(string(m[1]), "&#43;", "+")
+	}
+	salt, _ := base64.StdEncoding.DecodeString(field("salt"))
+	iterations, _ := strconv.Atoi(field("iterations"))
+	sealed, _ := base64.StdEncoding.DecodeString(field("page"))
+	if plain := unlock(t, "correct horse", salt, iterations, sealed); !bytes.Contains(plain, []byte(`src="secret.jpg"`)) || !bytes.Contains(plain, []byte("inner/index.html")) {
+		t.Errorf("decrypted page does not show the folder:\n%s", plain)
+	}
+
+	// So do the encrypted copies of images and thumbnails.
+	for _, name := range []string{"private/.locked/secret.jpg", "private/.thumbs/.locked/secret.jpg", "private/inner/.locked/deep.jpg"} {
+		sealed, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatalf("no encrypted copy: %v", err)
+		}
+		original := strings.Replace(name, ".locked/", "", 1)
+		want, _ := os.ReadFile(filepath.Join(root, filepath.FromSlash(original)))
+		if !bytes.Equal(unlock(t, "correct horse", salt, iterations, sealed), want) {
+			t.Errorf("%s does not decrypt to %s", name, original)
+		}
+	}
+
+	// Indexing again leaves the encrypted page as it is.
+	info, _ := os.Stat(filepath.Join(root, "private", "index.html"))
+	SplitCreate(root)
+	if again, _ := os.Stat(filepath.Join(root, "private", "index.html")); !again.ModTime().Equal(info.ModTime()) {
+		t.Errorf("unchanged protected page was rewritten")
+	}
+
+	// Public pages neither show nor index the protected images.
+	parent, _ := os.ReadFile(filepath.Join(root, "index.html"))
+	if bytes.Contains(parent, []byte("private/.thumbs")) || !bytes.Contains(parent, []byte("private/index.html")) {
+		t.Errorf("root page shows the cover of the protected folder or no link to it")
+	}
+	index, _ := os.ReadFile(filepath.Join(root, searchIndexFile))
+	if bytes.Contains(index, []byte("secret.jpg")) || !bytes.Contains(index, []byte("p.jpg")) {
+		t.Errorf("search index = %s", index)
+	}
+
+	excludes, err := os.ReadFile(state.Path(root, ExcludeFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"/.ima/\n", "/" + ManifestFile + "\n", "/private/secret.jpg\n", "/private/.thumbs/secret.jpg\n", "/private/inner/deep.jpg\n"} {
+		if !bytes.Contains(excludes, []byte(want)) {
+			t.Errorf("exclude file does not list %q:\n%s", want, excludes)
+		}
+	}
+	if bytes.Contains(excludes, []byte("index.html")) || bytes.Contains(excludes, []byte(".locked")) || bytes.Contains(excludes, []byte("public")) {
+		t.Errorf("exclude file lists published files:\n%s", excludes)
+	}
+
+	// Unprotecting publishes the folder in clear again.
+	if err := Unprotect(root, "private"); err != nil {
+		t.Fatal(err)
+	}
+	SplitCreate(root)
+	page, _ = os.ReadFile(filepath.Join(root, "private", "index.html"))
+	if !bytes.Contains(page, []byte(`src="secret.jpg"`)) {
+		t.Errorf("unprotected page does not show its images")
+	}
+	if _, err := os.Stat(filepath.Join(root, "private", lockedDir)); !os.IsNotExist(err) {
+		t.Errorf("encrypted copies are left after unprotecting")
+	}
+	if _, err := os.Stat(state.Path(root, ExcludeFile)); !os.IsNotExist(err) {
+		t.Errorf("exclude file is left without protected folders")
+	}
+}
+
+func TestProtectedSubfolderStats(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"album/a.jpg", "album/vault/v.jpg"} {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		writeTestImage(t, p, 40, 30)
+	}
+	os.WriteFile(filepath.Join(root, "album", coverMarker), []byte("vault/v.jpg"), 0644)
+	if err := Protect(root, "album/vault", "pw"); err != nil {
+		t.Fatal(err)
+	}
+	SplitCreate(root)
+
+	// The protected folder counts for nothing on public pages, and cannot
+	// be picked as a cover.
+	page, _ := os.ReadFile(filepath.Join(root, "index.html"))
+	if !bytes.Contains(page, []byte(`src="album/.thumbs/a.jpg"`)) || !bytes.Contains(page, []byte("<small>1 photo &middot;")) || bytes.Contains(page, []byte("vault")) {
+		t.Errorf("root page reveals the protected folder:\n%s", page)
+	}
+	page, _ = os.ReadFile(filepath.Join(root, "album", "index.html"))
+	if bytes.Contains(page, []byte("vault/.thumbs")) || bytes.Contains(page, []byte("<small>1 photo")) {
+		t.Errorf("folder page reveals the protected subfolder:\n%s", page)
+	}
+}
@@ -0,0 +1,75 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/image-archive/metadata"
+	"github.com/image-archive/state"
+)
+
+var searchEnabled bool // --search
+
+// searchIndexFile is written at the archive root. Pages load it with a
+// script tag rather than fetch, which browsers block for file:// URLs.
+const searchIndexFile = "search-index.js"
+
+// searchItem is one image in the search index, encoded as a JSON array to
+// keep the file small: href, name, folder, date, camera, caption, tags.
+type searchItem [7]string
+
+// writeSearchIndex writes the search index for the whole catalog.
+func (a *archive) writeSearchIndex() error {
+	dirs := make([]string, 0, len(a.catalog))
+	for dir := range a.catalog {
+		dirs = append(dirs, dir)
+	}
+	slices.Sort(dirs)
+
+	items := []searchItem{}
+	for _, dir := range dirs {
+		folder := dir
+		if dir == "." {
+			folder = a.name
+		}
+		for _, e := range a.catalog[dir] {
+			var date string
+			if !e.Meta.DateTimeOriginal.IsZero() {
+				date = e.Meta.DateTimeOriginal.Format("2006-01-02")
+			}
+			items = append(items, searchItem{
+				urlPath(path.Join(dir, pageFileName(e.Page))) + "#modal-" + e.ID,
+				e.Name,
+				folder,
+				date,
+				e.Meta.Camera(),
+				caption(e.Meta),
+				strings.Join(e.Meta.Keywords, ", "),
+			})
+		}
+	}
+
+	data, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("window.IMA_SEARCH = ")
+	buf.Write(data)
+	buf.WriteString(";\n")
+	return state.WriteFile(filepath.Join(a.root, searchIndexFile), buf.Bytes())
+}
+
+// caption returns the title and description of an image for the search
+// index.
+func caption(meta metadata.Info) string {
+	if meta.Title != "" && meta.Description != "" {
+		return meta.Title + " — " + meta.Description
+	}
+	return meta.Title + meta.Description
+}
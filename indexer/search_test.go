@@ -0,0 +1,61 @@
+package indexer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSearchIndex(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, name := range []string{"2023/march/beach.jpg", "2023/june/a#b.jpg", "root.png"} {
+		p := filepath.Join(tempDir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		os.WriteFile(p, []byte{}, 0644)
+	}
+
+	oldSearch, oldNoThumb := searchEnabled, noThumb
+	t.Cleanup(func() { searchEnabled, noThumb = oldSearch, oldNoThumb })
+	searchEnabled, noThumb = true, true
+
+	SplitCreate(tempDir)
+
+	content, err := os.ReadFile(filepath.Join(tempDir, searchIndexFile))
+	if err != nil {
+		t.Fatalf("Failed to read search index: %v", err)
+	}
+	index := string(content)
+	for _, want := range []string{
+		`"2023/march/index.html#modal-` + imageID("beach.jpg") + `","beach.jpg","2023/march"`,
+		`"2023/june/index.html#modal-` + imageID("a#b.jpg") + `","a#b.jpg"`,
+		`"index.html#modal-` + imageID("root.png") + `","root.png","` + filepath.Base(tempDir) + `"`,
+	} {
+		if !strings.Contains(index, want) {
+			t.Errorf("search index does not contain %s", want)
+		}
+	}
+
+	page, err := os.ReadFile(filepath.Join(tempDir, "2023", "march", "index.html"))
+	if err != nil {
+		t.Fatalf("Failed to read index.html: %v", err)
+	}
+	if !strings.Contains(string(page), `data-root="../.."`) {
+		t.Errorf("index.html has no search box pointing at the root")
+	}
+
+	// Removing a folder drops its images after the watcher's update.
+	os.RemoveAll(filepath.Join(tempDir, "2023", "march"))
+	Update(tempDir, filepath.Join(tempDir, "2023", "march"))
+
+	content, err = os.ReadFile(filepath.Join(tempDir, searchIndexFile))
+	if err != nil {
+		t.Fatalf("Failed to read search index: %v", err)
+	}
+	if strings.Contains(string(content), "beach.jpg") {
+		t.Errorf("search index still lists images of a removed folder")
+	}
+	if !strings.Contains(string(content), "root.png") {
+		t.Errorf("search index lost images outside the updated folder")
+	}
+}
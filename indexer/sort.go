@@ -0,0 +1,198 @@
+package indexer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/image-archive/metadata"
+)
+
+// Sort keys accepted by --sort.
+const (
+	SortName     = "name"      // Byte-wise file name order
+	SortNatural  = "natural"   // Case-insensitive, numbers compared by value
+	SortMTime    = "mtime"     // File modification time
+	SortEXIFDate = "exif-date" // EXIF capture time, falling back to mtime
+	SortSize     = "size"      // File size
+)
+
+// sortKey is a pflag.Value that only accepts the known sort keys.
+type sortKey string
+
+func (k *sortKey) String() string { return string(*k) }
+
+func (k *sortKey) Type() string { return "key" }
+
+func (k *sortKey) Set(s string) error {
+	switch s {
+	case SortName, SortNatural, SortMTime, SortEXIFDate, SortSize:
+		*k = sortKey(s)
+		return nil
+	}
+	return fmt.Errorf("must be one of %s, %s, %s, %s, %s",
+		SortName, SortNatural, SortMTime, SortEXIFDate, SortSize)
+}
+
+var (
+	sortBy           = sortKey(SortName) // --sort
+	sortDesc         bool                // --desc
+	sortDirsByNewest bool                // --dirs-by-newest
+)
+
+// photoTime returns the capture time of an image, falling back to its
+// modification time when the file carries no EXIF date.
+func photoTime(path string, modTime time.Time) time.Time {
+	if info, err := metadata.Read(path); err == nil && !info.DateTimeOriginal.IsZero() {
+		return info.DateTimeOriginal
+	}
+	return modTime
+}
+
+// newestPhoto returns the most recent capture time of any image below dir,
+// from the statistics memoised for the whole archive.
+func (a *archive) newestPhoto(dir string) time.Time {
+	return a.dirStats(dir).last
+}
+
+// sortImages orders images in place according to --sort and --desc.
+// Capture times are only read from disk when sorting by exif-date.
+func sortImages(dir string, images []Image) {
+	if sortBy == SortEXIFDate {
+		for i := range images {
+			if images[i].Taken.IsZero() {
+				images[i].Taken = photoTime(filepath.Join(dir, images[i].Name), images[i].ModTime)
+			}
+		}
+	}
+
+	slices.SortStableFunc(images, func(a, b Image) int {
+		switch sortBy {
+		case SortMTime:
+			if c := a.ModTime.Compare(b.ModTime); c != 0 {
+				return c
+			}
+		case SortEXIFDate:
+			if c := a.Taken.Compare(b.Taken); c != 0 {
+				return c
+			}
+		case SortSize:
+			if c := compareInt64(a.Size, b.Size); c != 0 {
+				return c
+			}
+		case SortName:
+			return strings.Compare(a.Name, b.Name)
+		}
+		return naturalCompare(a.Name, b.Name)
+	})
+
+	if sortDesc {
+		slices.Reverse(images)
+	}
+}
+
+// sortSubDirs orders subdirectory entries in place, keeping ".." first.
+func (a *archive) sortSubDirs(dir string, subDirs []SubDir) {
+	start := 0
+	if len(subDirs) > 0 && subDirs[0].Link == ".." {
+		start = 1
+	}
+	sortDirs(subDirs[start:],
+		func(s SubDir) string { return s.Name },
+		func(s SubDir) string { return filepath.Join(dir, s.Name) },
+		a.newestPhoto)
+}
+
+// sortDirs orders directory-like items in place. Directories are sorted
+// by name for the name, natural, size and exif-date keys, by their own
+// mtime for the mtime key, and by the capture time of their newest photo
+// when --dirs-by-newest is set, as given by newest.
+func sortDirs[T any](items []T, name, path func(T) string, newest func(dir string) time.Time) {
+	times := make(map[string]time.Time, len(items))
+	if sortDirsByNewest || sortBy == SortMTime {
+		for _, item := range items {
+			p := path(item)
+			if sortDirsByNewest {
+				times[p] = newest(p)
+			} else if info, err := os.Stat(p); err == nil {
+				times[p] = info.ModTime()
+			}
+		}
+	}
+
+	slices.SortStableFunc(items, func(a, b T) int {
+		if len(times) > 0 {
+			if c := times[path(a)].Compare(times[path(b)]); c != 0 {
+				return c
+			}
+		}
+		if sortBy == SortName {
+			return strings.Compare(name(a), name(b))
+		}
+		return naturalCompare(name(a), name(b))
+	})
+
+	if sortDesc {
+		slices.Reverse(items)
+	}
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// naturalCompare compares two names case-insensitively, treating runs of
+// digits as numbers so that "IMG_2" sorts before "IMG_10".
+func naturalCompare(a, b string) int {
+	x, y := strings.ToLower(a), strings.ToLower(b)
+	for x != "" && y != "" {
+		xd, yd := isDigit(x[0]), isDigit(y[0])
+		if xd && yd {
+			xn, xrest := splitDigits(x)
+			yn, yrest := splitDigits(y)
+			tx, ty := strings.TrimLeft(xn, "0"), strings.TrimLeft(yn, "0")
+			if c := compareInt64(int64(len(tx)), int64(len(ty))); c != 0 {
+				return c
+			}
+			if c := strings.Compare(tx, ty); c != 0 {
+				return c
+			}
+			// Equal values: fewer leading zeros first.
+			if c := compareInt64(int64(len(xn)), int64(len(yn))); c != 0 {
+				return c
+			}
+			x, y = xrest, yrest
+			continue
+		}
+		if x[0] != y[0] {
+			return compareInt64(int64(x[0]), int64(y[0]))
+		}
+		x, y = x[1:], y[1:]
+	}
+	if c := compareInt64(int64(len(x)), int64(len(y))); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// splitDigits splits s into its leading run of digits and the rest.
+func splitDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
@@ -0,0 +1,117 @@
+package indexer
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// withSort sets the sort flags for the duration of a test.
+func withSort(t *testing.T, key string, desc bool) {
+	t.Helper()
+
+	oldKey, oldDesc := sortBy, sortDesc
+	t.Cleanup(func() { sortBy, sortDesc = oldKey, oldDesc })
+
+	if err := sortBy.Set(key); err != nil {
+		t.Fatalf("sortBy.Set(%q) failed: %v", key, err)
+	}
+	sortDesc = desc
+}
+
+func imageNames(images []Image) []string {
+	var names []string
+	for _, img := range images {
+		names = append(names, img.Name)
+	}
+	return names
+}
+
+func TestNaturalCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"IMG_2.jpg", "IMG_10.jpg", -1},
+		{"IMG_10.jpg", "IMG_2.jpg", 1},
+		{"img_2.jpg", "IMG_3.jpg", -1},
+		{"IMG_02.jpg", "IMG_2.jpg", 1},
+		{"a.jpg", "a.jpg", 0},
+		{"a", "a1", -1},
+		{"2023-03", "2023-12", -1},
+	}
+
+	for _, test := range tests {
+		if got := naturalCompare(test.a, test.b); got != test.want {
+			t.Errorf("naturalCompare(%q, %q) = %d; want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestSortImages(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	images := []Image{
+		{Name: "IMG_10.jpg", Size: 30, ModTime: base.Add(time.Hour)},
+		{Name: "IMG_2.jpg", Size: 10, ModTime: base.Add(3 * time.Hour)},
+		{Name: "IMG_1.jpg", Size: 20, ModTime: base.Add(2 * time.Hour)},
+	}
+
+	tests := []struct {
+		key  string
+		desc bool
+		want []string
+	}{
+		{SortName, false, []string{"IMG_1.jpg", "IMG_10.jpg", "IMG_2.jpg"}},
+		{SortNatural, false, []string{"IMG_1.jpg", "IMG_2.jpg", "IMG_10.jpg"}},
+		{SortNatural, true, []string{"IMG_10.jpg", "IMG_2.jpg", "IMG_1.jpg"}},
+		{SortMTime, false, []string{"IMG_10.jpg", "IMG_1.jpg", "IMG_2.jpg"}},
+		{SortSize, true, []string{"IMG_10.jpg", "IMG_1.jpg", "IMG_2.jpg"}},
+	}
+
+	for _, test := range tests {
+		withSort(t, test.key, test.desc)
+
+		got := slices.Clone(images)
+		sortImages(t.TempDir(), got)
+		if !slices.Equal(imageNames(got), test.want) {
+			t.Errorf("sort %s (desc=%v) = %v; want %v", test.key, test.desc, imageNames(got), test.want)
+		}
+	}
+}
+
+func TestSortKeyRejectsUnknown(t *testing.T) {
+	var k sortKey
+	if err := k.Set("colour"); err == nil {
+		t.Errorf("Set(%q) succeeded; want error", "colour")
+	}
+}
+
+func TestSortSubDirsByNewest(t *testing.T) {
+	tempDir := t.TempDir()
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	recent := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for name, mtime := range map[string]time.Time{"a": recent, "b": old} {
+		os.Mkdir(filepath.Join(tempDir, name), 0755)
+		path := filepath.Join(tempDir, name, "photo.jpg")
+		os.WriteFile(path, []byte{}, 0644)
+		os.Chtimes(path, mtime, mtime)
+	}
+
+	withSort(t, SortName, false)
+	sortDirsByNewest = true
+	t.Cleanup(func() { sortDirsByNewest = false })
+
+	subDirs := []SubDir{{Name: "..", Link: ".."}, {Name: "a", Link: "a"}, {Name: "b", Link: "b"}}
+	newArchive(tempDir).sortSubDirs(tempDir, subDirs)
+
+	var got []string
+	for _, s := range subDirs {
+		got = append(got, s.Name)
+	}
+	if want := []string{"..", "b", "a"}; !slices.Equal(got, want) {
+		t.Errorf("sortSubDirs = %v; want %v", got, want)
+	}
+}
@@ -0,0 +1,236 @@
+package indexer
+
+import (
+	"cmp"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+var tagsEnabled bool // --tags
+
+// tagsDir is the root-level directory holding the tag pages.
+const tagsDir = "tags"
+
+// TagLink is a keyword linking to its tag page.
+type TagLink struct {
+	Name   string
+	Link   string // Escaped link to the tag page, relative to the page
+	Count  int    // Number of images with the tag, only set in the tag cloud
+	Weight int    // Size in the tag cloud, from 1 to 5
+}
+
+// tag is a keyword along with the images carrying it.
+type tag struct {
+	slug    string
+	name    string         // Most common spelling
+	names   map[string]int // Spellings by number of images
+	entries []datedEntry   // In capture order
+}
+
+// tagSlug returns the directory name of the tag page of a keyword. Letters
+// and digits are kept in lower case and everything else becomes a single
+// dash, so keywords differing only in case or punctuation share a page.
+func tagSlug(keyword string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(keyword) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+// tagLinks returns the links from a page to the tag pages of keywords.
+// prefix is the link from that page back to the archive root. Without
+// --tags there are none.
+func tagLinks(prefix string, keywords []string) []TagLink {
+	if !tagsEnabled {
+		return nil
+	}
+	var links []TagLink
+	seen := make(map[string]bool)
+	for _, k := range keywords {
+		slug := tagSlug(k)
+		if seen[slug] {
+			continue
+		}
+		seen[slug] = true
+		links = append(links, TagLink{
+			Name: k,
+			Link: urlPath(path.Join(prefix, tagsDir, slug, "index.html")),
+		})
+	}
+	return links
+}
+
+// buildTags groups every catalogued image by keyword, in order of tag name.
+func (a *archive) buildTags() []*tag {
+	bySlug := make(map[string]*tag)
+	for dir, entries := range a.catalog {
+		for _, e := range entries {
+			seen := make(map[string]bool)
+			for _, k := range e.Meta.Keywords {
+				slug := tagSlug(k)
+				t := bySlug[slug]
+				if t == nil {
+					t = &tag{slug: slug, names: make(map[string]int)}
+					bySlug[slug] = t
+				}
+				t.names[k]++
+				if !seen[slug] {
+					seen[slug] = true
+					t.entries = append(t.entries, datedEntry{dir: dir, entry: e, taken: e.Taken()})
+				}
+			}
+		}
+	}
+
+	tags := make([]*tag, 0, len(bySlug))
+	for _, t := range bySlug {
+		for name, n := range t.names {
+			if n > t.names[t.name] || n == t.names[t.name] && name < t.name {
+				t.name = name
+			}
+		}
+		slices.SortFunc(t.entries, func(x, y datedEntry) int {
+			if c := x.taken.Compare(y.taken); c != 0 {
+				return c
+			}
+			if c := naturalCompare(x.dir, y.dir); c != 0 {
+				return c
+			}
+			return naturalCompare(x.entry.Name, y.entry.Name)
+		})
+		tags = append(tags, t)
+	}
+	slices.SortFunc(tags, func(x, y *tag) int {
+		return cmp.Or(naturalCompare(strings.ToLower(x.name), strings.ToLower(y.name)), cmp.Compare(x.slug, y.slug))
+	})
+	return tags
+}
+
+// cloudWeight scales the number of images of a tag to a size from 1 to 5,
+// logarithmically between the rarest and the most common tag.
+func cloudWeight(count, least, most int) int {
+	if most == least {
+		return 3
+	}
+	scale := (math.Log(float64(count)) - math.Log(float64(least))) / (math.Log(float64(most)) - math.Log(float64(least)))
+	return 1 + int(math.Round(4*scale))
+}
+
+// writeTags writes the tag cloud and one gallery page per tag, and removes
+// the pages of tags no image carries any more.
+func (a *archive) writeTags() error {
+	tags := a.buildTags()
+	written := make(map[string]bool)
+
+	least, most := math.MaxInt, 0
+	for _, t := range tags {
+		least, most = min(least, len(t.entries)), max(most, len(t.entries))
+	}
+	cloud := make([]TagLink, len(tags))
+	for i, t := range tags {
+		cloud[i] = TagLink{
+			Name:   t.name,
+			Link:   urlPath(path.Join(t.slug, "index.html")),
+			Count:  len(t.entries),
+			Weight: cloudWeight(len(t.entries), least, most),
+		}
+	}
+
+	dir, err := claimDir(a.root, tagsDir)
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(dir, "index.html")
+	data := PageData{
+		Title:       "Tags",
+		Root:        rootPrefix(tagsDir),
+		Breadcrumbs: a.sectionCrumbs(tagsDir, []string{"Tags"}),
+		Sections:    a.sections(tagsDir),
+		TagCloud:    cloud,
+		CurrentPath: dir,
+		Thumbs:      !noThumb,
+		Search:      searchEnabled,
+	}
+	if a.tree != nil {
+		data.Tree = []TreeItem{treeView(a.tree, tagsDir)}
+	}
+	if err := writePage(file, data); err != nil {
+		return err
+	}
+	written[file] = true
+
+	for _, t := range tags {
+		files, err := a.writeTagPage(t)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			written[f] = true
+		}
+	}
+	return removeStale(dir, written)
+}
+
+// writeTagPage writes the pages of one tag and returns the paths written.
+func (a *archive) writeTagPage(t *tag) ([]string, error) {
+	rel := path.Join(tagsDir, t.slug)
+	dir := filepath.Join(a.root, filepath.FromSlash(rel))
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	prefix := rootPrefix(rel)
+	images := make([]Image, len(t.entries))
+	for i, de := range t.entries {
+		images[i] = a.entryImage(prefix, de.dir, de.entry)
+	}
+	if sortDesc {
+		slices.Reverse(images)
+	}
+
+	trail := a.sectionCrumbs(rel, []string{"Tags", t.name})
+
+	var files []string
+	pages, pagers := paginate(images)
+	for i, pageImages := range pages {
+		data := PageData{
+			Title:       t.name,
+			Root:        prefix,
+			Breadcrumbs: trail,
+			Sections:    a.sections(rel),
+			Images:      pageImages,
+			CurrentPath: dir,
+			Thumbs:      !noThumb,
+			Search:      searchEnabled,
+			Pager:       pagers[i],
+		}
+		if a.tree != nil {
+			data.Tree = []TreeItem{treeView(a.tree, rel)}
+		}
+
+		file := filepath.Join(dir, pageFileName(i+1))
+		if err := writePage(file, data); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
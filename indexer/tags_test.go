@@ -0,0 +1,107 @@
+package indexer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeKeywords gives the image at path a sidecar listing keywords.
+func writeKeywords(t *testing.T, path string, keywords ...string) {
+	t.Helper()
+	var items strings.Builder
+	for _, k := range keywords {
+		items.WriteString("<rdf:li>" + k + "</rdf:li>")
+	}
+	sidecar := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:subject><rdf:Bag>` + items.String() + `</rdf:Bag></dc:subject>
+</rdf:Description></rdf:RDF></x:xmpmeta>`
+	if err := os.WriteFile(path+".xmp", []byte(sidecar), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTagSlug(t *testing.T) {
+	tests := map[string]string{
+		"beach":      "beach",
+		"New York":   "new-york",
+		"  Köln! ":   "köln",
+		"index.html": "index-html",
+		"a/../b":     "a-b",
+		"!!!":        "_",
+	}
+	for keyword, want := range tests {
+		if got := tagSlug(keyword); got != want {
+			t.Errorf("tagSlug(%q) = %q; want %q", keyword, got, want)
+		}
+	}
+}
+
+func TestTags(t *testing.T) {
+	tempDir := t.TempDir()
+	images := map[string][]string{
+		"2023/beach.jpg":  {"Beach", "Family"},
+		"2023/sunset.jpg": {"beach", "Sunset"},
+		"2024/park.jpg":   {"Family"},
+		"2024/plain.jpg":  nil,
+	}
+	for name, keywords := range images {
+		p := filepath.Join(tempDir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		os.WriteFile(p, []byte{}, 0644)
+		if keywords != nil {
+			writeKeywords(t, p, keywords...)
+		}
+	}
+
+	oldTags, oldNoThumb := tagsEnabled, noThumb
+	t.Cleanup(func() { tagsEnabled, noThumb = oldTags, oldNoThumb })
+	tagsEnabled, noThumb = true, true
+
+	SplitCreate(tempDir)
+
+	read := func(rel string) string {
+		t.Helper()
+		content, err := os.ReadFile(filepath.Join(tempDir, filepath.FromSlash(rel)))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", rel, err)
+		}
+		return string(content)
+	}
+
+	cloud := read("tags/index.html")
+	for _, want := range []string{`href="beach/index.html">Beach <small>(2)</small>`, `href="sunset/index.html">Sunset <small>(1)</small>`, `class="weight-1"`, `class="weight-5"`} {
+		if !strings.Contains(cloud, want) {
+			t.Errorf("tags/index.html does not contain %q", want)
+		}
+	}
+
+	beach := read("tags/beach/index.html")
+	for _, want := range []string{`src="../../2023/beach.jpg"`, `src="../../2023/sunset.jpg"`, "<h1>Beach</h1>"} {
+		if !strings.Contains(beach, want) {
+			t.Errorf("tags/beach/index.html does not contain %q", want)
+		}
+	}
+	if strings.Contains(beach, "park.jpg") {
+		t.Errorf("tags/beach/index.html lists an image without the tag")
+	}
+
+	// Modals link each keyword to its tag page.
+	folder := read("2023/index.html")
+	for _, want := range []string{`<a href="../tags/beach/index.html">Beach</a>`, `<a href="../tags/sunset/index.html">Sunset</a>`, `<a href="../tags/index.html">Tags</a>`} {
+		if !strings.Contains(folder, want) {
+			t.Errorf("2023/index.html does not contain %q", want)
+		}
+	}
+	if strings.Contains(read("index.html"), `class="folder" href="tags/index.html"`) {
+		t.Errorf("root page lists the tags as a folder")
+	}
+
+	// Dropping the last image with a tag removes its page.
+	writeKeywords(t, filepath.Join(tempDir, "2023", "sunset.jpg"), "beach")
+	Update(tempDir, filepath.Join(tempDir, "2023"))
+	if _, err := os.Stat(filepath.Join(tempDir, "tags", "sunset")); !os.IsNotExist(err) {
+		t.Errorf("tags/sunset was not removed")
+	}
+}
@@ -0,0 +1,159 @@
+package indexer
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gen2brain/avif"
+	"github.com/gen2brain/webp"
+)
+
+// Encoder writes thumbnails in one image format.
+type Encoder interface {
+	// Encode writes img to w.
+	Encode(w io.Writer, img image.Image) error
+	// Type returns the MIME type of the images it writes.
+	Type() string
+}
+
+// encoderFunc is an Encoder of the given MIME type.
+type encoderFunc struct {
+	mimeType string
+	encode   func(w io.Writer, img image.Image) error
+}
+
+func (e encoderFunc) Encode(w io.Writer, img image.Image) error { return e.encode(w, img) }
+
+func (e encoderFunc) Type() string { return e.mimeType }
+
+// Every image gets a thumbnail browsers are sure to show, named like the
+// image: a JPEG, or a PNG if the image has transparency, which JPEG would
+// turn black.
+var (
+	jpegEncoder Encoder = encoderFunc{"image/jpeg", func(w io.Writer, img image.Image) error {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 80})
+	}}
+	pngEncoder Encoder = encoderFunc{"image/png", png.Encode}
+)
+
+// encoders holds the additional thumbnail formats by name. Pages offer
+// them before the fallback thumbnail, and browsers pick the first they
+// support.
+var encoders = map[string]Encoder{
+	"avif": encoderFunc{"image/avif", func(w io.Writer, img image.Image) error {
+		return avif.Encode(w, img, avif.Options{Quality: 50, QualityAlpha: 50, Speed: 8, ChromaSubsampling: image.YCbCrSubsampleRatio420})
+	}},
+	"webp": encoderFunc{"image/webp", func(w io.Writer, img image.Image) error {
+		return webp.Encode(w, img, webp.Options{Quality: 75, Method: 4})
+	}},
+}
+
+// RegisterEncoder makes thumbnails available in another format, to be
+// chosen by its name with --thumb-formats. The thumbnails are named after
+// their image with the name of the format appended as extension, such as
+// .thumbs/IMG_0001.jpg.webp. It is meant to be called from init functions.
+func RegisterEncoder(format string, e Encoder) {
+	if format == "" || strings.ContainsAny(format, "./\\") || IsImageFile("."+format) {
+		panic("indexer: invalid thumbnail format " + format)
+	}
+	encoders[format] = e
+}
+
+// formatList is a pflag.Value that only accepts registered thumbnail
+// formats, separated by commas.
+type formatList []string
+
+func (l *formatList) String() string { return strings.Join(*l, ",") }
+
+func (l *formatList) Type() string { return "formats" }
+
+func (l *formatList) Set(s string) error {
+	var formats []string
+	for _, f := range strings.Split(s, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if _, ok := encoders[f]; !ok {
+			return fmt.Errorf("unknown format %q, must be among %s", f, strings.Join(slices.Sorted(maps.Keys(encoders)), ", "))
+		}
+		if !slices.Contains(formats, f) {
+			formats = append(formats, f)
+		}
+	}
+	*l = formats
+	return nil
+}
+
+var thumbFormats formatList // --thumb-formats
+
+// Source is a thumbnail in an additional format, offered in a <picture>.
+type Source struct {
+	Srcset string // Escaped link to the thumbnail
+	Type   string // MIME type
+}
+
+// Sources returns the thumbnails of the image in additional formats.
+func (img Image) Sources() []Source {
+	var sources []Source
+	for _, f := range img.Formats {
+		if e, ok := encoders[f]; ok {
+			sources = append(sources, Source{Srcset: img.Thumb + "." + urlPath(f), Type: e.Type()})
+		}
+	}
+	return sources
+}
+
+// ThumbnailNames returns the names, within .thumbs, of the thumbnails the
+// image called name may have, in every registered format.
+func ThumbnailNames(name string) []string {
+	names := []string{name}
+	for _, f := range slices.Sorted(maps.Keys(encoders)) {
+		names = append(names, name+"."+f)
+	}
+	return names
+}
+
+// thumbnailFiles returns the paths of the copies of the thumbnail at thumb
+// in the additional formats chosen with --thumb-formats.
+func thumbnailFiles(thumb string) []string {
+	var files []string
+	for _, f := range thumbFormats {
+		files = append(files, thumb+"."+f)
+	}
+	return files
+}
+
+// thumbnailFormats returns the additional formats of which the thumbnail
+// at thumb, in the fallback format, has an up-to-date copy.
+func thumbnailFormats(imagePath, thumb string) []string {
+	var formats []string
+	for _, f := range thumbFormats {
+		if !stale(imagePath, thumb+"."+f) {
+			formats = append(formats, f)
+		}
+	}
+	return formats
+}
+
+// encoderFor returns the encoder of the thumbnail at file of img, chosen
+// by its extension.
+func encoderFor(file string, img image.Image) Encoder {
+	if e, ok := encoders[strings.TrimPrefix(filepath.Ext(file), ".")]; ok {
+		return e
+	}
+	if hasAlpha(img) {
+		return pngEncoder
+	}
+	return jpegEncoder
+}
+
+// hasAlpha reports whether img has transparent pixels.
+func hasAlpha(img image.Image) bool {
+	o, ok := img.(interface{ Opaque() bool })
+	return ok && !o.Opaque()
+}
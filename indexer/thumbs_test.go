@@ -0,0 +1,74 @@
+package indexer
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestThumbnailFormats(t *testing.T) {
+	root := t.TempDir()
+	oldFormats := thumbFormats
+	t.Cleanup(func() { thumbFormats = oldFormats })
+	if err := thumbFormats.Set("webp,avif"); err != nil {
+		t.Fatal(err)
+	}
+	if err := new(formatList).Set("bmp"); err == nil {
+		t.Errorf("unknown format was accepted")
+	}
+
+	writeTestImage(t, filepath.Join(root, "photo.jpg"), 40, 30)
+	// A logo with a transparent background.
+	logo := image.NewNRGBA(image.Rect(0, 0, 40, 40))
+	for x := 10; x < 30; x++ {
+		for y := 10; y < 30; y++ {
+			logo.Set(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+	f, _ := os.Create(filepath.Join(root, "logo.png"))
+	png.Encode(f, logo)
+	f.Close()
+
+	SplitCreate(root)
+	for file, want := range map[string]string{
+		"photo.jpg":      "jpeg",
+		"photo.jpg.webp": "webp",
+		"photo.jpg.avif": "avif",
+		"logo.png":       "png",
+	} {
+		f, err := os.Open(filepath.Join(root, ".thumbs", file))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		img, format, err := image.Decode(f)
+		f.Close()
+		if err != nil || format != want {
+			t.Errorf("thumbnail %s is %q (%v); want %s", file, format, err, want)
+			continue
+		}
+		if file == "logo.png" {
+			if _, _, _, a := img.At(0, 0).RGBA(); a != 0 {
+				t.Errorf("transparent corner of the logo has alpha %d in its thumbnail", a)
+			}
+		}
+	}
+
+	page, _ := os.ReadFile(filepath.Join(root, "index.html"))
+	want := `<picture><source srcset=".thumbs/photo.jpg.webp" type="image/webp"><source srcset=".thumbs/photo.jpg.avif" type="image/avif"><img loading="lazy" src=".thumbs/photo.jpg"`
+	if !strings.Contains(string(page), want) {
+		t.Errorf("page lacks %s", want)
+	}
+
+	// Without the additional formats, only the fallback is offered.
+	thumbFormats = nil
+	SplitCreate(root)
+	page, _ = os.ReadFile(filepath.Join(root, "index.html"))
+	if strings.Contains(string(page), "<picture>") {
+		t.Errorf("page offers thumbnail formats no longer chosen")
+	}
+}
@@ -0,0 +1,232 @@
+package indexer
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+var timelineEnabled bool // --timeline
+
+// timelineDir is the root-level directory holding the timeline pages.
+const timelineDir = "timeline"
+
+// datedEntry is a catalog entry together with the directory holding it.
+type datedEntry struct {
+	dir   string
+	entry Entry
+	taken time.Time
+}
+
+// timelineNode is one year, month or day of the timeline.
+type timelineNode struct {
+	key      string // "2023", "03" or "14"
+	title    string
+	entries  []datedEntry // All images below this node, in capture order
+	children []*timelineNode
+}
+
+// buildTimeline groups every catalogued image by capture date into years,
+// months and days.
+func (a *archive) buildTimeline() *timelineNode {
+	var all []datedEntry
+	for dir, entries := range a.catalog {
+		for _, e := range entries {
+			all = append(all, datedEntry{dir: dir, entry: e, taken: e.Taken()})
+		}
+	}
+	slices.SortFunc(all, func(x, y datedEntry) int {
+		if c := x.taken.Compare(y.taken); c != 0 {
+			return c
+		}
+		if c := naturalCompare(x.dir, y.dir); c != 0 {
+			return c
+		}
+		return naturalCompare(x.entry.Name, y.entry.Name)
+	})
+
+	root := &timelineNode{title: "Timeline", entries: all}
+	levels := []struct {
+		key   func(time.Time) string
+		title func(time.Time) string
+	}{
+		{func(t time.Time) string { return t.Format("2006") }, func(t time.Time) string { return t.Format("2006") }},
+		{func(t time.Time) string { return t.Format("01") }, func(t time.Time) string { return t.Format("January 2006") }},
+		{func(t time.Time) string { return t.Format("02") }, func(t time.Time) string { return t.Format("2 January 2006") }},
+	}
+
+	var split func(n *timelineNode, depth int)
+	split = func(n *timelineNode, depth int) {
+		if depth == len(levels) {
+			return
+		}
+		for _, de := range n.entries {
+			key := levels[depth].key(de.taken)
+			if len(n.children) == 0 || n.children[len(n.children)-1].key != key {
+				n.children = append(n.children, &timelineNode{key: key, title: levels[depth].title(de.taken)})
+			}
+			last := n.children[len(n.children)-1]
+			last.entries = append(last.entries, de)
+		}
+		for _, child := range n.children {
+			split(child, depth+1)
+		}
+	}
+	split(root, 0)
+	return root
+}
+
+// writeTimeline writes the timeline pages and removes pages of dates that
+// no longer hold any image. Pages whose content is unchanged are left
+// alone, so updates after a watcher event only touch the affected dates.
+func (a *archive) writeTimeline() error {
+	if _, err := claimDir(a.root, timelineDir); err != nil {
+		return err
+	}
+	written := make(map[string]bool)
+	root := a.buildTimeline()
+
+	var write func(n *timelineNode, rel string, crumbs []string) error
+	write = func(n *timelineNode, rel string, crumbs []string) error {
+		crumbs = append(crumbs, n.title)
+		files, err := a.writeTimelinePage(n, rel, crumbs)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			written[f] = true
+		}
+		for _, child := range n.children {
+			if err := write(child, path.Join(rel, child.key), crumbs); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := write(root, timelineDir, nil); err != nil {
+		return err
+	}
+
+	return removeStale(filepath.Join(a.root, timelineDir), written)
+}
+
+// writeTimelinePage writes the pages of one timeline node and returns the
+// paths written.
+func (a *archive) writeTimelinePage(n *timelineNode, rel string, crumbs []string) ([]string, error) {
+	dir := filepath.Join(a.root, filepath.FromSlash(rel))
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	prefix := rootPrefix(rel)
+
+	var subDirs []SubDir
+	if rel != timelineDir {
+		subDirs = append(subDirs, SubDir{Name: "..", Link: ".."})
+	}
+	for _, child := range n.children {
+		first, last := child.entries[0], child.entries[len(child.entries)-1]
+		subDirs = append(subDirs, SubDir{
+			Name:  child.title,
+			Link:  child.key,
+			Count: len(child.entries),
+			Dates: formatDateRange(first.taken, last.taken),
+			Cover: urlPath(thumbPath(path.Join(prefix, first.dir, first.entry.Name))),
+		})
+	}
+
+	// Only days list images; years and months show cards for their children.
+	var images []Image
+	if len(n.children) == 0 {
+		for _, de := range n.entries {
+			images = append(images, a.entryImage(prefix, de.dir, de.entry))
+		}
+	}
+	if sortDesc {
+		start := 0
+		if rel != timelineDir {
+			start = 1 // Keep ".." first
+		}
+		slices.Reverse(subDirs[start:])
+		slices.Reverse(images)
+	}
+
+	trail := a.sectionCrumbs(rel, crumbs)
+
+	var files []string
+	pages, pagers := paginate(images)
+	for i, pageImages := range pages {
+		data := PageData{
+			Title:       crumbs[len(crumbs)-1],
+			Root:        prefix,
+			Breadcrumbs: trail,
+			Sections:    a.sections(rel),
+			SubDirs:     subDirs,
+			Images:      pageImages,
+			CurrentPath: dir,
+			Thumbs:      !noThumb,
+			Search:      searchEnabled,
+			Pager:       pagers[i],
+		}
+		if a.tree != nil {
+			data.Tree = []TreeItem{treeView(a.tree, rel)}
+		}
+
+		file := filepath.Join(dir, pageFileName(i+1))
+		if err := writePage(file, data); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// sectionCrumbs returns the breadcrumb trail of a page inside a generated
+// section, naming each step by its title rather than its directory name.
+func (a *archive) sectionCrumbs(rel string, titles []string) []Crumb {
+	crumbs := breadcrumbs(a.name, rel)
+	for i, title := range titles {
+		if i+1 < len(crumbs) {
+			crumbs[i+1].Name = title
+		}
+	}
+	return crumbs
+}
+
+// removeStale deletes the pages below dir that were not written during
+// this run, then any directories left empty.
+func removeStale(dir string, written map[string]bool) error {
+	var dirs []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			dirs = append(dirs, p)
+			return nil
+		}
+		if strings.HasSuffix(p, ".html") && !written[p] {
+			return os.Remove(p)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Deepest first, so parents become empty after their children.
+	slices.Reverse(dirs)
+	for _, d := range dirs {
+		if entries, err := os.ReadDir(d); err == nil && len(entries) == 0 {
+			if err := os.Remove(d); err != nil {
+				return fmt.Errorf("removing empty %s: %w", d, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package indexer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeline(t *testing.T) {
+	tempDir := t.TempDir()
+	files := map[string]time.Time{
+		"events/party/a.jpg": time.Date(2023, 3, 14, 20, 0, 0, 0, time.Local),
+		"events/party/b.jpg": time.Date(2023, 3, 14, 21, 0, 0, 0, time.Local),
+		"trips/x.jpg":        time.Date(2023, 6, 1, 9, 0, 0, 0, time.Local),
+		"trips/y.jpg":        time.Date(2024, 1, 2, 9, 0, 0, 0, time.Local),
+	}
+	for name, mtime := range files {
+		p := filepath.Join(tempDir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		os.WriteFile(p, []byte{}, 0644)
+		os.Chtimes(p, mtime, mtime)
+	}
+
+	oldTimeline, oldNoThumb := timelineEnabled, noThumb
+	t.Cleanup(func() { timelineEnabled, noThumb = oldTimeline, oldNoThumb })
+	timelineEnabled, noThumb = true, true
+
+	SplitCreate(tempDir)
+
+	read := func(rel string) string {
+		t.Helper()
+		content, err := os.ReadFile(filepath.Join(tempDir, filepath.FromSlash(rel)))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", rel, err)
+		}
+		return string(content)
+	}
+
+	years := read("timeline/index.html")
+	for _, want := range []string{`href="2023/index.html"`, `href="2024/index.html"`, "3 photos"} {
+		if !strings.Contains(years, want) {
+			t.Errorf("timeline/index.html does not contain %q", want)
+		}
+	}
+	if !strings.Contains(read("timeline/2023/index.html"), "March 2023") {
+		t.Errorf("timeline/2023/index.html does not list March")
+	}
+
+	day := read("timeline/2023/03/14/index.html")
+	for _, want := range []string{
+		`src="../../../../events/party/a.jpg"`,
+		`href="../../../../events/party/index.html#modal-` + imageID("b.jpg") + `"`,
+		"14 March 2023",
+	} {
+		if !strings.Contains(day, want) {
+			t.Errorf("day page does not contain %q", want)
+		}
+	}
+	if strings.Contains(day, "x.jpg") {
+		t.Errorf("day page lists an image from another day")
+	}
+
+	// The timeline is not indexed as a folder of the archive.
+	if strings.Contains(read("index.html"), `class="folder" href="timeline/index.html"`) {
+		t.Errorf("root page lists the timeline as a folder")
+	}
+	if !strings.Contains(read("index.html"), `<a href="timeline/index.html">Timeline</a>`) {
+		t.Errorf("root page does not link to the timeline")
+	}
+
+	// Removing the only image of a day removes that day's pages.
+	os.Remove(filepath.Join(tempDir, "trips", "y.jpg"))
+	Update(tempDir, filepath.Join(tempDir, "trips"))
+	if _, err := os.Stat(filepath.Join(tempDir, "timeline", "2024")); !os.IsNotExist(err) {
+		t.Errorf("timeline/2024 was not removed")
+	}
+}
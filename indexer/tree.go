@@ -0,0 +1,180 @@
+package indexer
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var treeSidebar bool // --tree
+
+// TreeNode is a directory of the archive, as found by the indexing walk.
+type TreeNode struct {
+	Name     string      // Directory name
+	Path     string      // Slash-separated path relative to the archive root
+	Children []*TreeNode // Subdirectories, sorted like SubDirs
+}
+
+// TreeItem is a TreeNode as seen from one particular page.
+type TreeItem struct {
+	Name     string
+	Link     string // Link to the directory's index.html, relative to the page
+	Open     bool   // The directory contains the current page
+	Current  bool   // The directory is the current page
+	Children []TreeItem
+}
+
+// Crumb is one step of the breadcrumb trail from the root to a page.
+type Crumb struct {
+	Name string
+	Link string // Empty for the current directory
+}
+
+// generatedDirs are directories at the archive root that may hold pages
+// built from the catalog rather than folders of images. They only do when
+// they carry the generated marker, so that a folder of images of the same
+// name is never hidden or overwritten.
+var generatedDirs = map[string]bool{
+	timelineDir: true,
+	mapDir:      true,
+	tagsDir:     true,
+	albumsDir:   true,
+}
+
+// generatedMarker is the file the indexer writes into the directories of
+// generatedDirs it creates.
+const generatedMarker = ".ima-generated"
+
+// IsGenerated reports whether the slash path rel, relative to the archive
+// root, lies in a directory of pages built from the catalog, such as tags/,
+// rather than in a folder of images.
+func IsGenerated(root, rel string) bool {
+	top, _, _ := strings.Cut(rel, "/")
+	return isGeneratedDir(root, top)
+}
+
+// isGeneratedDir reports whether the directory name at the archive root
+// holds generated pages.
+func isGeneratedDir(root, name string) bool {
+	if !generatedDirs[name] {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(root, name, generatedMarker))
+	return err == nil
+}
+
+// claimDir returns the directory name at the archive root for generated
+// pages, creating it along with its marker. It fails when a folder of the
+// archive already has that name.
+func claimDir(root, name string) (string, error) {
+	dir := filepath.Join(root, name)
+	if _, err := os.Stat(dir); err == nil && !isGeneratedDir(root, name) {
+		return "", fmt.Errorf("%s is a folder of the archive, rename it to generate these pages", dir)
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+	return dir, os.WriteFile(filepath.Join(dir, generatedMarker), nil, 0644)
+}
+
+// skipDir reports whether the directory name inside parent, a slash path
+// relative to the archive root, is left out of the index. Hidden
+// directories hold generated files such as .thumbs.
+func skipDir(root, parent, name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return true
+	}
+	return (parent == "." || parent == "") && isGeneratedDir(root, name)
+}
+
+// buildTree walks the directory tree below the archive root once and
+// returns its root node.
+func (a *archive) buildTree() *TreeNode {
+	node := &TreeNode{Name: filepath.Base(a.root)}
+	a.buildSubtree(a.root, node)
+	return node
+}
+
+func (a *archive) buildSubtree(dir string, node *TreeNode) {
+	items, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, item := range items {
+		if !item.IsDir() || skipDir(a.root, node.Path, item.Name()) {
+			continue
+		}
+		child := &TreeNode{Name: item.Name(), Path: path.Join(node.Path, item.Name())}
+		a.buildSubtree(filepath.Join(dir, item.Name()), child)
+		node.Children = append(node.Children, child)
+	}
+	sortDirs(node.Children,
+		func(n *TreeNode) string { return n.Name },
+		func(n *TreeNode) string { return filepath.Join(dir, n.Name) },
+		a.newestPhoto)
+}
+
+// relPath returns the slash-separated path of dir relative to root, or "."
+// for the root itself.
+func relPath(root, dir string) string {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return "."
+	}
+	return filepath.ToSlash(rel)
+}
+
+// rootPrefix returns the relative link from a page in the directory rel
+// back to the archive root, e.g. "../.." for "2023/march".
+func rootPrefix(rel string) string {
+	if rel == "." {
+		return "."
+	}
+	return strings.TrimSuffix(strings.Repeat("../", strings.Count(rel, "/")+1), "/")
+}
+
+// breadcrumbs returns the trail from the root to the directory rel.
+func breadcrumbs(rootName, rel string) []Crumb {
+	names := []string{rootName}
+	if rel != "." {
+		names = append(names, strings.Split(rel, "/")...)
+	}
+
+	crumbs := make([]Crumb, len(names))
+	for i, name := range names {
+		crumbs[i].Name = name
+		if up := len(names) - 1 - i; up > 0 {
+			crumbs[i].Link = strings.Repeat("../", up) + "index.html"
+		}
+	}
+	return crumbs
+}
+
+// treeView renders the tree relative to the page in the directory rel.
+func treeView(node *TreeNode, rel string) TreeItem {
+	prefix := rootPrefix(rel)
+	item := TreeItem{
+		Name:    node.Name,
+		Link:    urlPath(path.Join(prefix, node.Path, "index.html")),
+		Open:    true,
+		Current: rel == ".",
+	}
+	item.Children = treeChildren(node.Children, rel, prefix)
+	return item
+}
+
+func treeChildren(nodes []*TreeNode, rel, prefix string) []TreeItem {
+	var items []TreeItem
+	for _, n := range nodes {
+		items = append(items, TreeItem{
+			Name:     n.Name,
+			Link:     urlPath(path.Join(prefix, n.Path, "index.html")),
+			Open:     rel == n.Path || strings.HasPrefix(rel, n.Path+"/"),
+			Current:  rel == n.Path,
+			Children: treeChildren(n.Children, rel, prefix),
+		})
+	}
+	return items
+}
@@ -0,0 +1,124 @@
+package indexer
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRootPrefix(t *testing.T) {
+	tests := map[string]string{
+		".":            ".",
+		"2023":         "..",
+		"2023/march":   "../..",
+		"2023/march/a": "../../..",
+	}
+	for rel, want := range tests {
+		if got := rootPrefix(rel); got != want {
+			t.Errorf("rootPrefix(%q) = %q; want %q", rel, got, want)
+		}
+	}
+}
+
+func TestBreadcrumbs(t *testing.T) {
+	got := breadcrumbs("photos", "2023/march")
+	want := []Crumb{
+		{Name: "photos", Link: "../../index.html"},
+		{Name: "2023", Link: "../index.html"},
+		{Name: "march"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("breadcrumbs = %+v; want %+v", got, want)
+	}
+}
+
+func TestSplitCreateRelativeRoot(t *testing.T) {
+	tempDir := t.TempDir()
+	os.MkdirAll(filepath.Join(tempDir, "2023", "march"), 0755)
+
+	// Index "." from inside the archive, as a relative invocation would.
+	wd, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(wd) })
+	os.Chdir(tempDir)
+
+	// Without --tree, ".." is kept even though the parent is ".".
+	SplitCreate(".")
+	content, err := os.ReadFile(filepath.Join(tempDir, "2023", "index.html"))
+	if err != nil {
+		t.Fatalf("Failed to read index.html: %v", err)
+	}
+	if !strings.Contains(string(content), `<a href="../index.html">..</a>`) {
+		t.Errorf("2023/index.html has no link to its parent")
+	}
+
+	oldTree := treeSidebar
+	t.Cleanup(func() { treeSidebar = oldTree })
+	treeSidebar = true
+
+	SplitCreate(".")
+	content, err = os.ReadFile(filepath.Join(tempDir, "2023", "index.html"))
+	if err != nil {
+		t.Fatalf("Failed to read index.html: %v", err)
+	}
+	page := string(content)
+
+	rootName := filepath.Base(tempDir)
+	for _, want := range []string{
+		`<a href="../index.html">` + rootName + `</a>`, // Breadcrumb and tree link to the root
+		`href="../2023/march/index.html"`,              // Tree link to the child
+		`class="active">2023</a>`,                      // Current directory is highlighted
+		`<details open>`,                               // Branch holding the page is expanded
+		`<span>2023</span>`,                            // Current breadcrumb is not a link
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("2023/index.html does not contain %q", want)
+		}
+	}
+
+	root, err := os.ReadFile(filepath.Join(tempDir, "index.html"))
+	if err != nil {
+		t.Fatalf("Failed to read index.html: %v", err)
+	}
+	if strings.Contains(string(root), `class="breadcrumbs"`) {
+		t.Errorf("root index.html has a breadcrumb trail")
+	}
+}
+
+// Folders of images named like the generated directories are indexed as
+// usual, and left alone by the features that would otherwise write there.
+func TestGeneratedDirNames(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"tags/a.jpg", "timeline/b.jpg"} {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		writeTestImage(t, p, 10, 10)
+	}
+	notes := filepath.Join(root, "timeline", "notes.html")
+	os.WriteFile(notes, []byte("mine"), 0644)
+
+	oldTimeline, oldNoThumb := timelineEnabled, noThumb
+	t.Cleanup(func() { timelineEnabled, noThumb = oldTimeline, oldNoThumb })
+	timelineEnabled, noThumb = true, true
+	SplitCreate(root)
+
+	page, _ := os.ReadFile(filepath.Join(root, "index.html"))
+	for _, link := range []string{`href="tags/index.html"`, `href="timeline/index.html"`} {
+		if !strings.Contains(string(page), link) {
+			t.Errorf("root page does not list the folder %s", link)
+		}
+	}
+	for _, name := range []string{"tags/index.html", "timeline/index.html"} {
+		page, _ := os.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+		if !strings.Contains(string(page), ".jpg") {
+			t.Errorf("%s is not the page of its folder", name)
+		}
+	}
+	if _, err := os.Stat(notes); err != nil {
+		t.Errorf("a file of the timeline folder was removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "timeline", generatedMarker)); err == nil {
+		t.Errorf("the timeline folder was claimed for generated pages")
+	}
+}
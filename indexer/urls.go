@@ -0,0 +1,50 @@
+package indexer
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// urlPath escapes a slash-separated relative path for use in href and src
+// attributes. Every segment is percent-encoded so that characters such as
+// '#', '?' and '%' in file names stay part of the path, and ':' is encoded
+// so that no segment can be mistaken for a URL scheme.
+func urlPath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		if s == "." || s == ".." {
+			continue
+		}
+		segments[i] = strings.ReplaceAll(url.PathEscape(s), ":", "%3A")
+	}
+	return strings.Join(segments, "/")
+}
+
+// imageID returns a stable identifier for an image that is safe to use as
+// an HTML id and URL fragment whatever the file name contains.
+func imageID(name string) string {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return fmt.Sprintf("i%016x", h.Sum64())
+}
+
+// fillImageLinks sets the ID and escaped links of each image of a page.
+// base is the slash path of the image's directory relative to the page.
+func fillImageLinks(base string, images []Image) {
+	seen := make(map[string]int, len(images))
+	for i := range images {
+		img := &images[i]
+		id := imageID(img.Name)
+		if n := seen[id]; n > 0 {
+			id = fmt.Sprintf("%s-%d", id, n) // Guard against hash collisions
+		}
+		seen[imageID(img.Name)]++
+
+		img.ID = id
+		img.Src = urlPath(path.Join(base, img.Name))
+		img.Thumb = urlPath(thumbPath(path.Join(base, img.Name)))
+	}
+}
@@ -0,0 +1,169 @@
+package indexer
+
+import (
+	"archive/zip"
+	"image/jpeg"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var zipEnabled bool // --zip
+
+// ZipFile is the name of the ZIP of a folder's images, next to its pages.
+const ZipFile = "index.zip"
+
+// webSize is the longest side, in pixels, of the images of web-size ZIPs.
+const webSize = 2048
+
+// WriteZip writes to w a ZIP of the images in the folder at the slash path
+// rel of the archive at root, named by their path relative to the folder.
+// With recursive, the images of the folders below it are added too, except
+// those of the folders for which include returns false, if include is not
+// nil. With web, images are replaced by their preview, and JPEG images
+// without one scaled down to webSize if larger.
+// Images are stored uncompressed, as they hardly compress.
+func WriteZip(w io.Writer, root, rel string, recursive, web bool, include func(folder string) bool) error {
+	root = filepath.Clean(root)
+	dir := filepath.Join(root, filepath.FromSlash(rel))
+	zw := zip.NewWriter(w)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p == dir {
+				return nil
+			}
+			if !recursive || skipDir(root, relPath(root, filepath.Dir(p)), d.Name()) {
+				return fs.SkipDir
+			}
+			if include != nil && !include(relPath(root, p)) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !IsImageFile(d.Name()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		name, _ := filepath.Rel(dir, p)
+		out, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     filepath.ToSlash(name),
+			Method:   zip.Store,
+			Modified: info.ModTime(),
+		})
+		if err != nil {
+			return err
+		}
+		if web {
+			if preview := filepath.Join(filepath.Dir(p), previewsDir, d.Name()); !stale(p, preview) {
+				return copyFile(out, preview)
+			}
+			if isJPEG(d.Name()) {
+				return writeWebSize(out, p)
+			}
+		}
+		return copyFile(out, p)
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func isJPEG(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".jpg" || ext == ".jpeg"
+}
+
+func copyFile(w io.Writer, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// writeWebSize writes the JPEG image at file scaled down to fit webSize,
+// or as it is if it already fits.
+func writeWebSize(w io.Writer, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cfg, err := jpeg.DecodeConfig(f)
+	if err != nil || max(cfg.Width, cfg.Height) <= webSize {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		_, err = io.Copy(w, f)
+		return err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	img, err := jpeg.Decode(f)
+	if err != nil {
+		return err
+	}
+	return jpeg.Encode(w, scaleToFit(img, webSize), &jpeg.Options{Quality: 85})
+}
+
+// writeFolderZip writes the ZIP of the images of dir for download from its
+// pages, unless it already holds these images.
+func writeFolderZip(dir string, images []Image) error {
+	file := filepath.Join(dir, ZipFile)
+	if zipCurrent(file, images) {
+		return nil
+	}
+	tmp, err := os.CreateTemp(dir, ".ima-zip-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	err = WriteZip(tmp, dir, ".", false, false, nil)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// zipCurrent reports whether the ZIP at file holds exactly images, in the
+// same versions.
+func zipCurrent(file string, images []Image) bool {
+	r, err := zip.OpenReader(file)
+	if err != nil {
+		return false
+	}
+	defer r.Close()
+	if len(r.File) != len(images) {
+		return false
+	}
+	want := make(map[string]Image, len(images))
+	for _, img := range images {
+		want[img.Name] = img
+	}
+	for _, f := range r.File {
+		img, ok := want[f.Name]
+		if !ok || f.UncompressedSize64 != uint64(img.Size) || f.Modified.Unix() != img.ModTime.Unix() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+package indexer
+
+import (
+	"archive/zip"
+	"bytes"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func zipNames(t *testing.T, data []byte) []string {
+	t.Helper()
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestWriteZip(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"trip/a.jpg", "trip/day-2/b.jpg", "trip/private/c.jpg", "trip/.trash/d.jpg"} {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		writeTestImage(t, p, 3000, 1500)
+	}
+	os.WriteFile(filepath.Join(root, "trip", "notes.txt"), []byte("notes"), 0644)
+
+	var buf bytes.Buffer
+	if err := WriteZip(&buf, root, "trip", false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := zipNames(t, buf.Bytes()); !slices.Equal(got, []string{"a.jpg"}) {
+		t.Errorf("ZIP of trip = %v; want [a.jpg]", got)
+	}
+
+	buf.Reset()
+	include := func(folder string) bool { return folder != "trip/private" }
+	if err := WriteZip(&buf, root, "trip", true, true, include); err != nil {
+		t.Fatal(err)
+	}
+	if got := zipNames(t, buf.Bytes()); !slices.Equal(got, []string{"a.jpg", "day-2/b.jpg"}) {
+		t.Errorf("recursive ZIP of trip = %v; want [a.jpg day-2/b.jpg]", got)
+	}
+	r, _ := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	f, _ := r.Open("a.jpg")
+	cfg, err := jpeg.DecodeConfig(f)
+	if err != nil || cfg.Width != webSize || cfg.Height != webSize/2 {
+		t.Errorf("web-size image is %dx%d (%v); want %dx%d", cfg.Width, cfg.Height, err, webSize, webSize/2)
+	}
+}
+
+func TestFolderZip(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "trip"), 0755)
+	writeTestImage(t, filepath.Join(root, "trip", "a.jpg"), 10, 10)
+	oldZip, oldNoThumb := zipEnabled, noThumb
+	t.Cleanup(func() { zipEnabled, noThumb = oldZip, oldNoThumb })
+	zipEnabled, noThumb = true, true
+
+	SplitCreate(root)
+	file := filepath.Join(root, "trip", ZipFile)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := zipNames(t, data); !slices.Equal(got, []string{"a.jpg"}) {
+		t.Errorf("index.zip holds %v", got)
+	}
+	page, _ := os.ReadFile(filepath.Join(root, "trip", "index.html"))
+	if !strings.Contains(string(page), `<a class="download" href="index.zip" download="trip.zip">Download all</a>`) {
+		t.Errorf("page has no Download all link")
+	}
+	if _, err := os.Stat(filepath.Join(root, ZipFile)); err == nil {
+		t.Errorf("a ZIP was written for the root, which has no images")
+	}
+
+	// The ZIP is only rebuilt when images change.
+	info, _ := os.Stat(file)
+	SplitCreate(root)
+	if again, _ := os.Stat(file); !again.ModTime().Equal(info.ModTime()) {
+		t.Errorf("unchanged ZIP was rebuilt")
+	}
+	writeTestImage(t, filepath.Join(root, "trip", "b.jpg"), 10, 10)
+	SplitCreate(root)
+	data, _ = os.ReadFile(file)
+	if got := zipNames(t, data); !slices.Equal(got, []string{"a.jpg", "b.jpg"}) {
+		t.Errorf("index.zip holds %v after adding b.jpg", got)
+	}
+}
@@ -7,7 +7,14 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/image-archive/dupes"
+	"github.com/image-archive/fixity"
+	"github.com/image-archive/importer"
 	"github.com/image-archive/indexer"
+	"github.com/image-archive/protect"
+	"github.com/image-archive/renamer"
+	"github.com/image-archive/server"
+	"github.com/image-archive/share"
 	"github.com/image-archive/watcher"
 	"github.com/spf13/cobra"
 )
@@ -36,6 +43,13 @@ func main() {
 	}
 	indexer.AddFlags(rootCmd)
 	rootCmd.Flags().BoolVar(&watchFlag, "watch", false, "Start watching the directory for changes")
+	rootCmd.AddCommand(dupes.NewCommand())
+	rootCmd.AddCommand(fixity.NewCommand())
+	rootCmd.AddCommand(importer.NewCommand())
+	rootCmd.AddCommand(protect.NewCommand())
+	rootCmd.AddCommand(renamer.NewCommand())
+	rootCmd.AddCommand(server.NewCommand())
+	rootCmd.AddCommand(share.NewCommand())
 
 	if err := rootCmd.Execute(); err != nil {
 		log.Fatalf("Error: %v", err)
@@ -46,7 +60,7 @@ func watcherCmd(dir string) {
 	cfg := watcher.Config{
 		Path:        dir,
 		EventBuffer: 100,
-		ExcludeDirs: []string{"index.html", ".thumbs"},
+		ExcludeDirs: watcher.GeneratedFiles,
 	}
 
 	fileWatcher, err := watcher.New(cfg)
@@ -63,7 +77,7 @@ func watcherCmd(dir string) {
 
 	eventChan := fileWatcher.Start(ctx)
 
-	go watcher.EventConsumer(ctx, eventChan)
+	go watcher.EventConsumer(ctx, dir, eventChan)
 
 	log.Printf("Watching Directory: %s (PID: %d)", dir, os.Getpid())
 
@@ -0,0 +1,484 @@
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// EXIF tag IDs we care about.
+const (
+	tagImageDescription = 0x010E
+	tagMake             = 0x010F
+	tagModel            = 0x0110
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagDateTimeOriginal = 0x9003
+	tagXPKeywords       = 0x9C9E
+)
+
+// GPS IFD tag IDs.
+const (
+	tagGPSLatitudeRef  = 0x0001
+	tagGPSLatitude     = 0x0002
+	tagGPSLongitudeRef = 0x0003
+	tagGPSLongitude    = 0x0004
+)
+
+// exifTimeLayout is the fixed timestamp format used by EXIF.
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// maxPNGChunk bounds the eXIf and iTXt chunks read from PNG files, whose
+// length field could otherwise claim up to 2 GB.
+const maxPNGChunk = 16 << 20
+
+var (
+	errNotTIFF    = errors.New("exif: invalid TIFF header")
+	errShortEntry = errors.New("exif: entry out of range")
+)
+
+// Version is bumped whenever Read extracts different values from the same
+// file, so that Info cached by other packages is read again.
+const Version = 2
+
+// Info holds the metadata extracted from an image file.
+type Info struct {
+	DateTimeOriginal time.Time `json:",omitzero"`  // Capture time, zero if unknown
+	Make             string    `json:",omitempty"` // Camera manufacturer
+	Model            string    `json:",omitempty"` // Camera model
+	Title            string    `json:",omitempty"`
+	Description      string    `json:",omitempty"` // Caption
+	Keywords         []string  `json:",omitempty"`
+	Rating           int       `json:",omitempty"` // Stars from 1 to 5, -1 if rejected, 0 if unrated
+	Location         *Location `json:",omitempty"` // GPS position, nil if not geotagged
+}
+
+// Location is a GPS position in decimal degrees.
+type Location struct {
+	Lat float64
+	Lon float64
+}
+
+// Camera returns a display name for the camera, such as "Canon EOS R5".
+func (info Info) Camera() string {
+	if info.Make == "" || strings.HasPrefix(strings.ToLower(info.Model), strings.ToLower(info.Make)) {
+		return info.Model
+	}
+	return strings.TrimSpace(info.Make + " " + info.Model)
+}
+
+// Read extracts metadata from a JPEG or PNG file, combining EXIF, IPTC and
+// XMP, and then an XMP sidecar if the image has one (see Sidecar). Later
+// sources take precedence, as that is where editors write changes. Files
+// without any metadata return a zero Info and no error.
+func Read(path string) (Info, error) {
+	var info Info
+
+	f, err := os.Open(path)
+	if err != nil {
+		return info, err
+	}
+	defer f.Close()
+
+	b, err := findBlocks(bufio.NewReader(f))
+	if err != nil {
+		return info, err
+	}
+
+	var errs []error
+	if b.exif != nil {
+		errs = append(errs, info.parseEXIF(b.exif))
+	}
+	if b.iptc != nil {
+		info.parseIPTC(b.iptc)
+	}
+	if b.xmp != nil {
+		errs = append(errs, info.parseXMP(b.xmp))
+	}
+	if sidecar := Sidecar(path); sidecar != "" {
+		data, err := os.ReadFile(sidecar)
+		if err == nil {
+			err = info.parseXMP(data)
+		}
+		errs = append(errs, err)
+	}
+	return info, errors.Join(errs...)
+}
+
+// blocks holds the raw metadata blocks embedded in an image file.
+type blocks struct {
+	exif []byte // TIFF block
+	iptc []byte // Photoshop image resources, carrying IPTC-IIM
+	xmp  []byte // XMP packet
+}
+
+// Prefixes identifying the metadata blocks of JPEG APP segments.
+var (
+	exifPrefix = []byte("Exif\x00\x00")
+	xmpPrefix  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	psPrefix   = []byte("Photoshop 3.0\x00")
+)
+
+// findBlocks returns the metadata blocks embedded in JPEG APP segments or
+// PNG chunks. Missing blocks are nil.
+func findBlocks(r *bufio.Reader) (blocks, error) {
+	magic, err := r.Peek(8)
+	if err != nil {
+		return blocks{}, nil // Too short to be an image we understand
+	}
+
+	switch {
+	case magic[0] == 0xFF && magic[1] == 0xD8:
+		return findJPEGBlocks(r)
+	case bytes.Equal(magic, []byte("\x89PNG\r\n\x1a\n")):
+		return findPNGBlocks(r)
+	}
+	return blocks{}, nil
+}
+
+// findJPEGBlocks scans JPEG markers up to the start of scan for the APP1
+// segments holding EXIF and XMP and the APP13 segment holding IPTC.
+func findJPEGBlocks(r *bufio.Reader) (blocks, error) {
+	var b blocks
+	if _, err := r.Discard(2); err != nil { // SOI
+		return b, err
+	}
+
+	for {
+		var hdr [4]byte
+		if _, err := io.ReadFull(r, hdr[:2]); err != nil {
+			return b, nil
+		}
+		if hdr[0] != 0xFF {
+			return b, nil // Lost sync, give up quietly
+		}
+		m := hdr[1]
+		if m == 0xFF { // Padding byte, marker follows
+			r.UnreadByte()
+			continue
+		}
+		if m == 0xD9 || m == 0xDA { // EOI or SOS: no more metadata
+			return b, nil
+		}
+		if m >= 0xD0 && m <= 0xD7 || m == 0x01 { // Standalone markers
+			continue
+		}
+
+		if _, err := io.ReadFull(r, hdr[2:]); err != nil {
+			return b, nil
+		}
+		size := int(binary.BigEndian.Uint16(hdr[2:])) - 2
+		if size < 0 {
+			return b, nil
+		}
+		if m != 0xE1 && m != 0xED {
+			if _, err := r.Discard(size); err != nil {
+				return b, nil
+			}
+			continue
+		}
+
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return b, err
+		}
+		switch {
+		case m == 0xE1 && b.exif == nil && bytes.HasPrefix(data, exifPrefix):
+			b.exif = data[len(exifPrefix):]
+		case m == 0xE1 && b.xmp == nil && bytes.HasPrefix(data, xmpPrefix):
+			b.xmp = data[len(xmpPrefix):]
+		case m == 0xED && b.iptc == nil && bytes.HasPrefix(data, psPrefix):
+			b.iptc = data[len(psPrefix):]
+		}
+	}
+}
+
+// findPNGBlocks returns the data of the eXIf chunk and the text of the iTXt
+// chunk holding XMP.
+func findPNGBlocks(r *bufio.Reader) (blocks, error) {
+	var b blocks
+	if _, err := r.Discard(8); err != nil { // Signature
+		return b, err
+	}
+
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return b, nil
+		}
+		size := int(binary.BigEndian.Uint32(hdr[:4]))
+		name := string(hdr[4:])
+		if name == "IEND" || size < 0 {
+			return b, nil
+		}
+		if name != "eXIf" && name != "iTXt" || size > maxPNGChunk {
+			if _, err := r.Discard(size + 4); err != nil { // Data and CRC
+				return b, nil
+			}
+			continue
+		}
+
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return b, err
+		}
+		if _, err := r.Discard(4); err != nil { // CRC
+			return b, nil
+		}
+		switch name {
+		case "eXIf":
+			b.exif = data
+		case "iTXt":
+			if text, ok := pngXMP(data); ok {
+				b.xmp = text
+			}
+		}
+	}
+}
+
+// pngXMP returns the text of an iTXt chunk if it carries XMP. Its layout is
+// keyword, NUL, compression flag and method, language tag, NUL, translated
+// keyword, NUL and text, which may be zlib compressed.
+func pngXMP(data []byte) ([]byte, bool) {
+	keyword, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok || string(keyword) != "XML:com.adobe.xmp" || len(rest) < 2 {
+		return nil, false
+	}
+	compressed := rest[0] == 1
+	_, rest, ok = bytes.Cut(rest[2:], []byte{0}) // Language tag
+	if !ok {
+		return nil, false
+	}
+	_, text, ok := bytes.Cut(rest, []byte{0}) // Translated keyword
+	if !ok {
+		return nil, false
+	}
+	if !compressed {
+		return text, true
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(text))
+	if err != nil {
+		return nil, false
+	}
+	defer zr.Close()
+	text, err = io.ReadAll(io.LimitReader(zr, 1<<20))
+	return text, err == nil
+}
+
+// ifdEntry is a single raw directory entry of a TIFF IFD.
+type ifdEntry struct {
+	typ   uint16
+	count uint32
+	value []byte // Raw value bytes, already resolved from their offset
+}
+
+// tiffReader decodes IFDs from a TIFF block.
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+func newTIFFReader(data []byte) (*tiffReader, uint32, error) {
+	if len(data) < 8 {
+		return nil, 0, errNotTIFF
+	}
+
+	t := &tiffReader{data: data}
+	switch string(data[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, 0, errNotTIFF
+	}
+	if t.order.Uint16(data[2:]) != 42 {
+		return nil, 0, errNotTIFF
+	}
+	return t, t.order.Uint32(data[4:]), nil
+}
+
+// typeSize returns the byte size of a single value of a TIFF field type.
+func typeSize(typ uint16) uint32 {
+	switch typ {
+	case 1, 2, 6, 7: // BYTE, ASCII, SBYTE, UNDEFINED
+		return 1
+	case 3, 8: // SHORT, SSHORT
+		return 2
+	case 4, 9, 11: // LONG, SLONG, FLOAT
+		return 4
+	case 5, 10, 12: // RATIONAL, SRATIONAL, DOUBLE
+		return 8
+	}
+	return 0
+}
+
+// readIFD decodes the IFD at offset into a map keyed by tag.
+func (t *tiffReader) readIFD(offset uint32) (map[uint16]ifdEntry, error) {
+	if uint64(offset)+2 > uint64(len(t.data)) {
+		return nil, errShortEntry
+	}
+	n := uint32(t.order.Uint16(t.data[offset:]))
+	start := offset + 2
+	if uint64(start)+uint64(n)*12 > uint64(len(t.data)) {
+		return nil, errShortEntry
+	}
+
+	entries := make(map[uint16]ifdEntry, n)
+	for i := uint32(0); i < n; i++ {
+		raw := t.data[start+i*12 : start+i*12+12]
+		e := ifdEntry{
+			typ:   t.order.Uint16(raw[2:]),
+			count: t.order.Uint32(raw[4:]),
+		}
+
+		size := uint64(typeSize(e.typ)) * uint64(e.count)
+		if size <= 4 {
+			e.value = raw[8 : 8+size]
+		} else {
+			off := uint64(t.order.Uint32(raw[8:]))
+			if off+size > uint64(len(t.data)) {
+				continue // Skip entries pointing outside the block
+			}
+			e.value = t.data[off : off+size]
+		}
+		entries[t.order.Uint16(raw)] = e
+	}
+	return entries, nil
+}
+
+// ascii returns the value of an ASCII entry without trailing NULs.
+func (t *tiffReader) ascii(ifd map[uint16]ifdEntry, tag uint16) string {
+	e, ok := ifd[tag]
+	if !ok || e.typ != 2 {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(e.value), "\x00"))
+}
+
+// utf16 returns the value of a Windows XP tag, stored as UTF-16LE bytes
+// regardless of the block's byte order.
+func (t *tiffReader) utf16(ifd map[uint16]ifdEntry, tag uint16) string {
+	e, ok := ifd[tag]
+	if !ok || e.typ != 1 {
+		return ""
+	}
+	units := make([]uint16, 0, len(e.value)/2)
+	for i := 0; i+1 < len(e.value); i += 2 {
+		units = append(units, binary.LittleEndian.Uint16(e.value[i:]))
+	}
+	return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+}
+
+// splitKeywords splits a semicolon separated keyword list.
+func splitKeywords(s string) []string {
+	var keywords []string
+	for _, k := range strings.Split(s, ";") {
+		if k = strings.TrimSpace(k); k != "" {
+			keywords = append(keywords, k)
+		}
+	}
+	return keywords
+}
+
+// long returns the first value of a SHORT or LONG entry.
+func (t *tiffReader) long(ifd map[uint16]ifdEntry, tag uint16) (uint32, bool) {
+	e, ok := ifd[tag]
+	if !ok || e.count == 0 {
+		return 0, false
+	}
+	switch e.typ {
+	case 3:
+		return uint32(t.order.Uint16(e.value)), true
+	case 4:
+		return t.order.Uint32(e.value), true
+	}
+	return 0, false
+}
+
+// rationals returns the values of a RATIONAL entry as floats.
+func (t *tiffReader) rationals(ifd map[uint16]ifdEntry, tag uint16) []float64 {
+	e, ok := ifd[tag]
+	if !ok || e.typ != 5 {
+		return nil
+	}
+	values := make([]float64, e.count)
+	for i := range values {
+		num := t.order.Uint32(e.value[i*8:])
+		den := t.order.Uint32(e.value[i*8+4:])
+		if den == 0 {
+			return nil
+		}
+		values[i] = float64(num) / float64(den)
+	}
+	return values
+}
+
+// location decodes the position stored in a GPS IFD as degrees, minutes
+// and seconds.
+func (t *tiffReader) location(gps map[uint16]ifdEntry) *Location {
+	lat := t.rationals(gps, tagGPSLatitude)
+	lon := t.rationals(gps, tagGPSLongitude)
+	if len(lat) != 3 || len(lon) != 3 {
+		return nil
+	}
+
+	loc := &Location{
+		Lat: lat[0] + lat[1]/60 + lat[2]/3600,
+		Lon: lon[0] + lon[1]/60 + lon[2]/3600,
+	}
+	if t.ascii(gps, tagGPSLatitudeRef) == "S" {
+		loc.Lat = -loc.Lat
+	}
+	if t.ascii(gps, tagGPSLongitudeRef) == "W" {
+		loc.Lon = -loc.Lon
+	}
+	if loc.Lat < -90 || loc.Lat > 90 || loc.Lon < -180 || loc.Lon > 180 {
+		return nil
+	}
+	return loc
+}
+
+// parseEXIF fills the Info from a raw TIFF block.
+func (info *Info) parseEXIF(data []byte) error {
+	t, offset, err := newTIFFReader(data)
+	if err != nil {
+		return err
+	}
+
+	ifd0, err := t.readIFD(offset)
+	if err != nil {
+		return err
+	}
+
+	var exif map[uint16]ifdEntry
+	if off, ok := t.long(ifd0, tagExifIFD); ok {
+		exif, _ = t.readIFD(off)
+	}
+
+	info.Make = t.ascii(ifd0, tagMake)
+	info.Model = t.ascii(ifd0, tagModel)
+	info.Description = t.ascii(ifd0, tagImageDescription)
+	info.Keywords = splitKeywords(t.utf16(ifd0, tagXPKeywords))
+
+	if off, ok := t.long(ifd0, tagGPSIFD); ok {
+		if gps, err := t.readIFD(off); err == nil {
+			info.Location = t.location(gps)
+		}
+	}
+
+	// Only the capture time counts. The IFD0 DateTime is when the file was
+	// last edited, which says nothing of when the photo was taken.
+	if ts, err := time.ParseInLocation(exifTimeLayout, t.ascii(exif, tagDateTimeOriginal), time.Local); err == nil {
+		info.DateTimeOriginal = ts
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// tiffEntry describes one IFD entry for buildTIFF.
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// buildTIFF assembles a little-endian TIFF block from a list of IFDs. An
+// entry pointing to a sub-IFD in IFD n is patched to point at IFD n+1.
+func buildTIFF(ifds ...[]tiffEntry) []byte {
+	le := binary.LittleEndian
+	var buf bytes.Buffer
+	buf.WriteString("II")
+	binary.Write(&buf, le, uint16(42))
+	binary.Write(&buf, le, uint32(8))
+
+	// Lay out IFDs back to back, each followed by its out-of-line values.
+	offsets := make([]uint32, len(ifds))
+	pos := uint32(8)
+	for i, ifd := range ifds {
+		offsets[i] = pos
+		pos += 2 + uint32(len(ifd))*12 + 4
+		for _, e := range ifd {
+			if len(e.value) > 4 {
+				pos += uint32(len(e.value))
+			}
+		}
+	}
+
+	for i, ifd := range ifds {
+		binary.Write(&buf, le, uint16(len(ifd)))
+		extra := offsets[i] + 2 + uint32(len(ifd))*12 + 4
+		var tail bytes.Buffer
+		for _, e := range ifd {
+			binary.Write(&buf, le, e.tag)
+			binary.Write(&buf, le, e.typ)
+			binary.Write(&buf, le, e.count)
+			value := e.value
+			if (e.tag == tagExifIFD || e.tag == tagGPSIFD) && i+1 < len(ifds) {
+				value = le.AppendUint32(nil, offsets[i+1])
+			}
+			if len(value) > 4 {
+				binary.Write(&buf, le, extra+uint32(tail.Len()))
+				tail.Write(value)
+			} else {
+				var v [4]byte
+				copy(v[:], value)
+				buf.Write(v[:])
+			}
+		}
+		binary.Write(&buf, le, uint32(0))
+		buf.Write(tail.Bytes())
+	}
+	return buf.Bytes()
+}
+
+func asciiEntry(tag uint16, s string) tiffEntry {
+	return tiffEntry{tag: tag, typ: 2, count: uint32(len(s) + 1), value: append([]byte(s), 0)}
+}
+
+// writeJPEGWithEXIF writes a small JPEG carrying the TIFF block in APP1.
+func writeJPEGWithEXIF(t *testing.T, path string, tiff []byte) {
+	t.Helper()
+
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewRGBA(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatalf("Failed to encode mock image: %v", err)
+	}
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	var out bytes.Buffer
+	out.Write(img.Bytes()[:2]) // SOI
+	out.Write([]byte{0xFF, 0xE1})
+	binary.Write(&out, binary.BigEndian, uint16(len(payload)+2))
+	out.Write(payload)
+	out.Write(img.Bytes()[2:])
+
+	if err := os.WriteFile(path, out.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write mock image: %v", err)
+	}
+}
+
+func TestReadDateTimeOriginal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	writeJPEGWithEXIF(t, path, buildTIFF(
+		[]tiffEntry{
+			asciiEntry(tagDateTime, "2024:01:01 00:00:00"),
+			{tag: tagExifIFD, typ: 4, count: 1},
+		},
+		[]tiffEntry{asciiEntry(tagDateTimeOriginal, "2023:03:14 15:09:26")},
+	))
+
+	info, err := Read(path)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+
+	want := time.Date(2023, 3, 14, 15, 9, 26, 0, time.Local)
+	if !info.DateTimeOriginal.Equal(want) {
+		t.Errorf("DateTimeOriginal = %v; want %v", info.DateTimeOriginal, want)
+	}
+}
+
+func TestReadIgnoresDateTime(t *testing.T) {
+	// DateTime is when the file was last changed, not a capture time.
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	writeJPEGWithEXIF(t, path, buildTIFF(
+		[]tiffEntry{asciiEntry(tagDateTime, "2024:01:02 03:04:05")},
+	))
+
+	info, err := Read(path)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !info.DateTimeOriginal.IsZero() {
+		t.Errorf("DateTimeOriginal = %v; want none", info.DateTimeOriginal)
+	}
+}
+
+func TestReadWithoutMetadata(t *testing.T) {
+	tests := map[string][]byte{
+		"empty.jpg": {},
+		"text.jpg":  []byte("not an image at all"),
+		// A truncated PNG whose eXIf chunk claims to hold 2 GB.
+		"huge.png": []byte("\x89PNG\r\n\x1a\n\x7f\xff\xff\xffeXIfMM\x00\x2a"),
+	}
+
+	var plain bytes.Buffer
+	jpeg.Encode(&plain, image.NewRGBA(image.Rect(0, 0, 8, 8)), nil)
+	tests["plain.jpg"] = plain.Bytes()
+
+	dir := t.TempDir()
+	for name, data := range tests {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, data, 0644)
+
+		info, err := Read(path)
+		if err != nil {
+			t.Errorf("Read(%s) returned error: %v", name, err)
+		}
+		if !info.DateTimeOriginal.IsZero() {
+			t.Errorf("Read(%s) found a capture time: %v", name, info.DateTimeOriginal)
+		}
+	}
+}
+
+func TestReadCameraAndCaption(t *testing.T) {
+	var keywords []byte
+	for _, r := range "beach; family ;" {
+		keywords = binary.LittleEndian.AppendUint16(keywords, uint16(r))
+	}
+	keywords = append(keywords, 0, 0)
+
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	writeJPEGWithEXIF(t, path, buildTIFF(
+		[]tiffEntry{
+			asciiEntry(tagImageDescription, "Sunset at the pier"),
+			asciiEntry(tagMake, "Canon"),
+			asciiEntry(tagModel, "Canon EOS R5"),
+			{tag: tagXPKeywords, typ: 1, count: uint32(len(keywords)), value: keywords},
+		},
+	))
+
+	info, err := Read(path)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if got := info.Camera(); got != "Canon EOS R5" {
+		t.Errorf("Camera() = %q; want %q", got, "Canon EOS R5")
+	}
+	if info.Description != "Sunset at the pier" {
+		t.Errorf("Description = %q; want %q", info.Description, "Sunset at the pier")
+	}
+	if len(info.Keywords) != 2 || info.Keywords[0] != "beach" || info.Keywords[1] != "family" {
+		t.Errorf("Keywords = %q; want [beach family]", info.Keywords)
+	}
+
+	if got := (Info{Make: "FUJIFILM", Model: "X100V"}).Camera(); got != "FUJIFILM X100V" {
+		t.Errorf("Camera() = %q; want %q", got, "FUJIFILM X100V")
+	}
+}
+
+func rationalEntry(tag uint16, values ...[2]uint32) tiffEntry {
+	var b []byte
+	for _, v := range values {
+		b = binary.LittleEndian.AppendUint32(b, v[0])
+		b = binary.LittleEndian.AppendUint32(b, v[1])
+	}
+	return tiffEntry{tag: tag, typ: 5, count: uint32(len(values)), value: b}
+}
+
+func TestReadLocation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	writeJPEGWithEXIF(t, path, buildTIFF(
+		[]tiffEntry{{tag: tagGPSIFD, typ: 4, count: 1}},
+		[]tiffEntry{
+			asciiEntry(tagGPSLatitudeRef, "S"),
+			rationalEntry(tagGPSLatitude, [2]uint32{33, 1}, [2]uint32{51, 1}, [2]uint32{3600, 100}),
+			asciiEntry(tagGPSLongitudeRef, "E"),
+			rationalEntry(tagGPSLongitude, [2]uint32{151, 1}, [2]uint32{12, 1}, [2]uint32{0, 1}),
+		},
+	))
+
+	info, err := Read(path)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if info.Location == nil {
+		t.Fatalf("Location = nil; want a position")
+	}
+	if lat := info.Location.Lat; lat > -33.86 || lat < -33.87 {
+		t.Errorf("Lat = %f; want -33.86…", lat)
+	}
+	if lon := info.Location.Lon; lon != 151.2 {
+		t.Errorf("Lon = %f; want 151.2", lon)
+	}
+}
@@ -0,0 +1,96 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"unicode/utf8"
+)
+
+// Photoshop image resource holding IPTC-IIM records.
+const iptcResource = 0x0404
+
+// IPTC-IIM datasets we care about, as record and dataset numbers.
+const (
+	iimCharset  = 1<<8 | 90  // CodedCharacterSet
+	iimTitle    = 2<<8 | 5   // ObjectName
+	iimKeywords = 2<<8 | 25  // Keywords, repeated
+	iimCaption  = 2<<8 | 120 // Caption-Abstract
+)
+
+// parseIPTC fills the Info from the Photoshop image resources of a JPEG
+// APP13 segment. Malformed data is ignored.
+func (info *Info) parseIPTC(data []byte) {
+	iim := photoshopResource(data, iptcResource)
+	if iim == nil {
+		return
+	}
+
+	var keywords []string
+	utf8Text := false
+	for len(iim) >= 5 && iim[0] == 0x1C {
+		tag := int(iim[1])<<8 | int(iim[2])
+		size := int(binary.BigEndian.Uint16(iim[3:]))
+		if size&0x8000 != 0 || 5+size > len(iim) {
+			break // Extended datasets only hold binary data
+		}
+		value := iim[5 : 5+size]
+		iim = iim[5+size:]
+
+		switch tag {
+		case iimCharset:
+			utf8Text = bytes.Equal(value, []byte("\x1b%G"))
+		case iimTitle:
+			info.Title = iimString(value, utf8Text)
+		case iimCaption:
+			info.Description = iimString(value, utf8Text)
+		case iimKeywords:
+			if k := iimString(value, utf8Text); k != "" {
+				keywords = append(keywords, k)
+			}
+		}
+	}
+	if keywords != nil {
+		info.Keywords = keywords
+	}
+}
+
+// photoshopResource returns the data of the image resource with the given
+// ID. Each resource is the signature "8BIM", a 16-bit ID, a Pascal string
+// name padded to an even length, a 32-bit size and the data, also padded.
+func photoshopResource(data []byte, id uint16) []byte {
+	for len(data) >= 12 && string(data[:4]) == "8BIM" {
+		rid := binary.BigEndian.Uint16(data[4:])
+		nameLen := int(data[6]) + 1
+		nameLen += nameLen % 2
+		if 6+nameLen+4 > len(data) {
+			return nil
+		}
+		size := int(binary.BigEndian.Uint32(data[6+nameLen:]))
+		start := 6 + nameLen + 4
+		if size < 0 || start+size > len(data) {
+			return nil
+		}
+		if rid == id {
+			return data[start : start+size]
+		}
+		// The padding byte of the last resource may be missing.
+		data = data[min(start+size+size%2, len(data)):]
+	}
+	return nil
+}
+
+// iimString decodes an IIM text value. Without a UTF-8 character set
+// declaration, text that is not valid UTF-8 is taken as Latin-1, which is
+// what older software writes.
+func iimString(value []byte, utf8Text bool) string {
+	s := string(value)
+	if !utf8Text && !utf8.ValidString(s) {
+		runes := make([]rune, len(value))
+		for i, b := range value {
+			runes[i] = rune(b)
+		}
+		s = string(runes)
+	}
+	return strings.TrimSpace(strings.TrimRight(s, "\x00"))
+}
@@ -0,0 +1,259 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Edit is a change to the descriptive metadata of an image. Nil fields are
+// left as they are.
+type Edit struct {
+	Title       *string
+	Description *string
+	Keywords    *[]string
+	Rating      *int
+}
+
+// touches reports whether the edit sets the property parseXMP reads under
+// name, as returned by xmpName.
+func (e Edit) touches(name string) bool {
+	switch name {
+	case "title":
+		return e.Title != nil
+	case "description":
+		return e.Description != nil
+	case "subject":
+		return e.Keywords != nil
+	case "Rating":
+		return e.Rating != nil
+	}
+	return false
+}
+
+// properties returns the XMP property elements setting the edited fields.
+// Each declares the namespaces it uses, whatever prefixes the enclosing
+// document binds.
+func (e Edit) properties() string {
+	var b strings.Builder
+	alt := func(name, value string) {
+		b.WriteString("\n   <dc:" + name + ` xmlns:dc="` + nsDC + `" xmlns:rdf="` + nsRDF + `"><rdf:Alt><rdf:li xml:lang="x-default">`)
+		xml.EscapeText(&b, []byte(value))
+		b.WriteString("</rdf:li></rdf:Alt></dc:" + name + ">")
+	}
+	if e.Title != nil {
+		alt("title", *e.Title)
+	}
+	if e.Description != nil {
+		alt("description", *e.Description)
+	}
+	if e.Keywords != nil {
+		b.WriteString("\n   <dc:subject" + ` xmlns:dc="` + nsDC + `" xmlns:rdf="` + nsRDF + `"><rdf:Bag>`)
+		for _, k := range *e.Keywords {
+			b.WriteString("<rdf:li>")
+			xml.EscapeText(&b, []byte(k))
+			b.WriteString("</rdf:li>")
+		}
+		b.WriteString("</rdf:Bag></dc:subject>")
+	}
+	if e.Rating != nil {
+		b.WriteString("\n   <xmp:Rating" + ` xmlns:xmp="` + nsXMP + `">` + strconv.Itoa(*e.Rating) + "</xmp:Rating>")
+	}
+	return b.String()
+}
+
+// emptySidecar is the XMP packet a new sidecar starts from.
+const emptySidecar = `<?xpacket begin="` + "\uFEFF" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="` + nsRDF + `">
+  <rdf:Description rdf:about="">
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>
+`
+
+// WriteSidecar applies edit to the XMP sidecar of the image at path,
+// creating one named like IMG_0001.JPG.xmp if the image has none. Anything
+// else the sidecar holds, such as the edit history darktable keeps there,
+// is left as it is, and the image itself is never modified. It returns the
+// path of the sidecar.
+func WriteSidecar(path string, edit Edit) (string, error) {
+	sidecar := Sidecar(path)
+	data := []byte(emptySidecar)
+	if sidecar == "" {
+		sidecar = path + ".xmp"
+	} else {
+		var err error
+		if data, err = os.ReadFile(sidecar); err != nil {
+			return "", err
+		}
+	}
+
+	data, err := editXMP(data, edit)
+	if err != nil {
+		return "", err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(sidecar), ".ima-xmp-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return "", err
+	}
+	return sidecar, os.Rename(tmp.Name(), sidecar)
+}
+
+// splice replaces the bytes from start to end of a document with text.
+type splice struct {
+	start, end int64
+	text       string
+}
+
+// editXMP rewrites an XMP packet so that it holds the edited properties.
+// Rather than re-encoding the document, which would lose its prefixes and
+// layout, the old properties are cut out and the new ones inserted into
+// the first top-level rdf:Description, using the byte offsets of the
+// tokens.
+func editXMP(data []byte, edit Edit) ([]byte, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+
+	// Namespace bindings of the open elements, RawToken leaves prefixes
+	// unresolved.
+	scopes := []map[string]string{{"xml": "http://www.w3.org/XML/1998/namespace"}}
+	resolve := func(n xml.Name) xml.Name {
+		for i := len(scopes) - 1; i >= 0; i-- {
+			if uri, ok := scopes[i][n.Space]; ok {
+				return xml.Name{Space: uri, Local: n.Local}
+			}
+		}
+		return n
+	}
+
+	var (
+		splices   []splice
+		open      []xml.Name // Resolved names of the open elements
+		inserted  bool
+		skipDepth int // Depth within a replaced property element
+		skipStart int64
+	)
+	parentIs := func(space, local string) bool {
+		return len(open) > 0 && open[len(open)-1] == xml.Name{Space: space, Local: local}
+	}
+
+	for {
+		start := d.InputOffset()
+		tok, err := d.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		end := d.InputOffset()
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			scope := make(map[string]string)
+			for _, a := range t.Attr {
+				if a.Name.Space == "xmlns" {
+					scope[a.Name.Local] = a.Value
+				} else if a.Name.Space == "" && a.Name.Local == "xmlns" {
+					scope[""] = a.Value
+				}
+			}
+			scopes = append(scopes, scope)
+			name := resolve(t.Name)
+			topLevel := parentIs(nsRDF, "RDF")
+			property := parentIs(nsRDF, "Description") && len(open) >= 2 && open[len(open)-2] == xml.Name{Space: nsRDF, Local: "RDF"}
+			open = append(open, name)
+
+			switch {
+			case skipDepth > 0:
+				skipDepth++
+			case property && edit.touches(xmpName(name)):
+				skipDepth, skipStart = 1, start
+			case topLevel && name == xml.Name{Space: nsRDF, Local: "Description"}:
+				var kept []xml.Attr
+				for _, a := range t.Attr {
+					if a.Name.Space != "xmlns" && a.Name.Space != "" && edit.touches(xmpName(resolve(a.Name))) {
+						continue
+					}
+					kept = append(kept, a)
+				}
+				selfClosing := bytes.HasSuffix(data[start:end], []byte("/>"))
+				if len(kept) == len(t.Attr) && inserted {
+					break
+				}
+
+				tag := startTag(t.Name, kept)
+				if !inserted {
+					tag += edit.properties()
+					if selfClosing {
+						tag += "\n  </" + qualified(t.Name) + ">"
+					}
+					inserted = true
+				} else if selfClosing {
+					tag = strings.TrimSuffix(tag, ">") + "/>"
+				}
+				splices = append(splices, splice{start, end, tag})
+			}
+		case xml.EndElement:
+			scopes = scopes[:len(scopes)-1]
+			open = open[:len(open)-1]
+			if skipDepth > 0 {
+				if skipDepth--; skipDepth == 0 {
+					splices = append(splices, splice{skipStart, end, ""})
+				}
+			}
+		}
+	}
+	if !inserted {
+		return nil, errors.New("xmp: no rdf:Description to hold the properties")
+	}
+
+	var out bytes.Buffer
+	var pos int64
+	for _, s := range splices {
+		out.Write(data[pos:s.start])
+		out.WriteString(s.text)
+		pos = s.end
+	}
+	out.Write(data[pos:])
+	return out.Bytes(), nil
+}
+
+// qualified returns the name as written in the document, prefix included.
+func qualified(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
+
+// startTag renders a start tag from its raw name and attributes.
+func startTag(name xml.Name, attrs []xml.Attr) string {
+	var b strings.Builder
+	b.WriteString("<" + qualified(name))
+	for _, a := range attrs {
+		b.WriteString(" " + qualified(a.Name) + `="`)
+		xml.EscapeText(&b, []byte(a.Value))
+		b.WriteString(`"`)
+	}
+	b.WriteString(">")
+	return b.String()
+}
@@ -0,0 +1,144 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// XML namespaces of the XMP properties we care about.
+const (
+	nsRDF = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsDC  = "http://purl.org/dc/elements/1.1/"
+	nsXMP = "http://ns.adobe.com/xap/1.0/"
+)
+
+// Sidecar returns the path of the XMP sidecar of the image at path, or ""
+// if it has none. darktable names sidecars after the whole file name, as
+// in IMG_0001.JPG.xmp, while Lightroom replaces the extension, as in
+// IMG_0001.xmp.
+func Sidecar(path string) string {
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	for _, p := range []string{path + ".xmp", path + ".XMP", base + ".xmp", base + ".XMP"} {
+		if info, err := os.Stat(p); err == nil && info.Mode().IsRegular() {
+			return p
+		}
+	}
+	return ""
+}
+
+// xmpProperty collects the value of a simple, language alternative or bag
+// property while its element is decoded.
+type xmpProperty struct {
+	name   string   // Local name of the property element
+	values []string // Values in document order, the default language first
+	text   strings.Builder
+	lang   string // xml:lang of the current rdf:li
+}
+
+// parseXMP fills the Info from an XMP packet, overriding what earlier
+// sources set: dc:title, dc:description, dc:subject and xmp:Rating. Both
+// the element and the attribute forms of RDF are understood.
+func (info *Info) parseXMP(data []byte) error {
+	d := xml.NewDecoder(bytes.NewReader(bytes.TrimRight(data, "\x00")))
+	var prop *xmpProperty
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch {
+			case t.Name.Space == nsRDF && t.Name.Local == "Description":
+				for _, attr := range t.Attr {
+					info.setXMP(attr.Name, []string{attr.Value})
+				}
+			case prop == nil && xmpName(t.Name) != "":
+				prop = &xmpProperty{name: xmpName(t.Name)}
+			case prop != nil && t.Name.Space == nsRDF && t.Name.Local == "li":
+				prop.text.Reset()
+				prop.lang = ""
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "lang" {
+						prop.lang = attr.Value
+					}
+				}
+			}
+		case xml.CharData:
+			if prop != nil {
+				prop.text.Write(t)
+			}
+		case xml.EndElement:
+			if prop == nil {
+				continue
+			}
+			switch {
+			case t.Name.Space == nsRDF && t.Name.Local == "li":
+				v := strings.TrimSpace(prop.text.String())
+				if prop.lang == "x-default" {
+					prop.values = append([]string{v}, prop.values...)
+				} else {
+					prop.values = append(prop.values, v)
+				}
+				prop.text.Reset()
+			case xmpName(t.Name) == prop.name:
+				if prop.values == nil { // Simple property, such as xmp:Rating
+					prop.values = []string{strings.TrimSpace(prop.text.String())}
+				}
+				info.setXMP(t.Name, prop.values)
+				prop = nil
+			}
+		}
+	}
+}
+
+// xmpName returns the name of a property parseXMP reads, or "".
+func xmpName(name xml.Name) string {
+	switch {
+	case name.Space == nsDC && (name.Local == "title" || name.Local == "description" || name.Local == "subject"):
+		return name.Local
+	case name.Space == nsXMP && name.Local == "Rating":
+		return name.Local
+	}
+	return ""
+}
+
+// setXMP sets the field of a property from its values. A property that is
+// present but empty clears the field, so a sidecar can remove a caption
+// embedded in the image.
+func (info *Info) setXMP(name xml.Name, values []string) {
+	var nonEmpty []string
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			nonEmpty = append(nonEmpty, v)
+		}
+	}
+	first := ""
+	if len(nonEmpty) > 0 {
+		first = nonEmpty[0]
+	}
+
+	switch xmpName(name) {
+	case "title":
+		info.Title = first
+	case "description":
+		info.Description = first
+	case "subject":
+		info.Keywords = nonEmpty
+	case "Rating":
+		info.Rating = 0
+		if r, err := strconv.ParseFloat(first, 64); err == nil {
+			info.Rating = int(math.Max(-1, math.Min(5, math.Round(r))))
+		}
+	}
+}
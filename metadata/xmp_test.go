@@ -0,0 +1,246 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+const testXMP = `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmp:Rating="4">
+   <dc:title><rdf:Alt>
+    <rdf:li xml:lang="de">Der Pier</rdf:li>
+    <rdf:li xml:lang="x-default">The pier</rdf:li>
+   </rdf:Alt></dc:title>
+   <dc:description><rdf:Alt>
+    <rdf:li xml:lang="x-default">Sunset &amp; boats</rdf:li>
+   </rdf:Alt></dc:description>
+   <dc:subject><rdf:Bag>
+    <rdf:li>beach</rdf:li>
+    <rdf:li>sunset</rdf:li>
+   </rdf:Bag></dc:subject>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+// segment is a JPEG marker segment for writeJPEGWithSegments.
+type segment struct {
+	marker  byte
+	payload []byte
+}
+
+// writeJPEGWithSegments writes a small JPEG carrying the given segments
+// right after SOI.
+func writeJPEGWithSegments(t *testing.T, path string, segments ...segment) {
+	t.Helper()
+
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewRGBA(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatalf("Failed to encode mock image: %v", err)
+	}
+
+	var out bytes.Buffer
+	out.Write(img.Bytes()[:2]) // SOI
+	for _, s := range segments {
+		out.Write([]byte{0xFF, s.marker})
+		binary.Write(&out, binary.BigEndian, uint16(len(s.payload)+2))
+		out.Write(s.payload)
+	}
+	out.Write(img.Bytes()[2:])
+
+	if err := os.WriteFile(path, out.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write mock image: %v", err)
+	}
+}
+
+// iptcSegment returns an APP13 payload holding the IIM datasets.
+func iptcSegment(datasets ...[]byte) segment {
+	var iim []byte
+	for _, d := range datasets {
+		iim = append(iim, d...)
+	}
+	var b bytes.Buffer
+	b.Write(psPrefix)
+	b.WriteString("8BIM")
+	binary.Write(&b, binary.BigEndian, uint16(iptcResource))
+	b.Write([]byte{0, 0}) // Empty name, padded
+	binary.Write(&b, binary.BigEndian, uint32(len(iim)))
+	b.Write(iim)
+	if len(iim)%2 == 1 {
+		b.WriteByte(0)
+	}
+	return segment{0xED, b.Bytes()}
+}
+
+func dataset(tag int, value string) []byte {
+	return append([]byte{0x1C, byte(tag >> 8), byte(tag), byte(len(value) >> 8), byte(len(value))}, value...)
+}
+
+func TestReadIPTC(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	writeJPEGWithSegments(t, path, iptcSegment(
+		dataset(iimTitle, "Caf\xe9"), // Latin-1
+		dataset(iimCaption, "Lunch break"),
+		dataset(iimKeywords, "food"),
+		dataset(iimKeywords, "paris"),
+	))
+
+	info, err := Read(path)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if info.Title != "Café" || info.Description != "Lunch break" {
+		t.Errorf("Title, Description = %q, %q; want Café, Lunch break", info.Title, info.Description)
+	}
+	if !slices.Equal(info.Keywords, []string{"food", "paris"}) {
+		t.Errorf("Keywords = %q; want [food paris]", info.Keywords)
+	}
+}
+
+func TestPhotoshopResourceWithoutPadding(t *testing.T) {
+	// An odd-sized resource ending the block without its padding byte.
+	data := []byte("8BIM\x03\xed\x00\x00\x00\x00\x00\x01X")
+	if got := photoshopResource(data, iptcResource); got != nil {
+		t.Errorf("photoshopResource = %q; want nil", got)
+	}
+	if got := photoshopResource(data, 0x03ed); string(got) != "X" {
+		t.Errorf("photoshopResource = %q; want X", got)
+	}
+}
+
+func TestReadXMP(t *testing.T) {
+	want := Info{
+		Title:       "The pier",
+		Description: "Sunset & boats",
+		Keywords:    []string{"beach", "sunset"},
+		Rating:      4,
+	}
+	check := func(name string, info Info, err error) {
+		t.Helper()
+		if err != nil {
+			t.Errorf("%s: Read failed: %v", name, err)
+		}
+		if info.Title != want.Title || info.Description != want.Description ||
+			!slices.Equal(info.Keywords, want.Keywords) || info.Rating != want.Rating {
+			t.Errorf("%s: Read() = %+v; want %+v", name, info, want)
+		}
+	}
+	dir := t.TempDir()
+
+	// Embedded XMP takes precedence over IPTC.
+	embedded := filepath.Join(dir, "embedded.jpg")
+	writeJPEGWithSegments(t, embedded,
+		iptcSegment(dataset(iimTitle, "Old title"), dataset(iimKeywords, "old")),
+		segment{0xE1, append(append([]byte{}, xmpPrefix...), testXMP...)},
+	)
+	info, err := Read(embedded)
+	check("embedded", info, err)
+
+	// So does a sidecar over anything embedded, whichever way it is named.
+	for name, sidecar := range map[string]string{"lightroom.jpg": "lightroom.xmp", "darktable.jpg": "darktable.jpg.xmp"} {
+		path := filepath.Join(dir, name)
+		writeJPEGWithSegments(t, path, iptcSegment(dataset(iimTitle, "Old title")))
+		os.WriteFile(filepath.Join(dir, sidecar), []byte(testXMP), 0644)
+
+		if got := Sidecar(path); got != filepath.Join(dir, sidecar) {
+			t.Errorf("Sidecar(%s) = %q", name, got)
+		}
+		info, err := Read(path)
+		check(sidecar, info, err)
+	}
+
+	// PNG files carry XMP in an iTXt chunk.
+	var img bytes.Buffer
+	png.Encode(&img, image.NewGray(image.Rect(0, 0, 4, 4)))
+	chunk := append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), testXMP...)
+	var out bytes.Buffer
+	out.Write(img.Bytes()[:33]) // Signature and IHDR
+	binary.Write(&out, binary.BigEndian, uint32(len(chunk)))
+	typed := append([]byte("iTXt"), chunk...)
+	out.Write(typed)
+	binary.Write(&out, binary.BigEndian, crc32.ChecksumIEEE(typed))
+	out.Write(img.Bytes()[33:])
+	pngPath := filepath.Join(dir, "image.png")
+	os.WriteFile(pngPath, out.Bytes(), 0644)
+	info, err = Read(pngPath)
+	check("png", info, err)
+
+	if got := Sidecar(filepath.Join(dir, "image.png")); got != "" {
+		t.Errorf("Sidecar(image.png) = %q; want none", got)
+	}
+}
+
+func TestWriteSidecar(t *testing.T) {
+	dir := t.TempDir()
+	title, caption, rating := "Harbour", "", 2
+	keywords := []string{"boats", "a & b"}
+
+	// Without a sidecar, one named after the whole file is created, and an
+	// empty caption hides the one embedded in the image.
+	path := filepath.Join(dir, "new.jpg")
+	writeJPEGWithSegments(t, path, iptcSegment(dataset(iimCaption, "Embedded"), dataset(iimKeywords, "old")))
+	sidecar, err := WriteSidecar(path, Edit{Title: &title, Description: &caption, Rating: &rating})
+	if err != nil {
+		t.Fatalf("WriteSidecar failed: %v", err)
+	}
+	if sidecar != path+".xmp" {
+		t.Errorf("WriteSidecar created %s; want %s", sidecar, path+".xmp")
+	}
+	info, err := Read(path)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if info.Title != title || info.Description != "" || info.Rating != rating || !slices.Equal(info.Keywords, []string{"old"}) {
+		t.Errorf("Read() = %+v after creating the sidecar", info)
+	}
+
+	// An existing sidecar keeps what other tools wrote, and its prefixes.
+	path = filepath.Join(dir, "edited.jpg")
+	writeJPEGWithSegments(t, path)
+	existing := `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <r:RDF xmlns:r="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <r:Description r:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:darktable="http://darktable.sf.net/" xmp:Rating="5" darktable:xmp_version="5">
+   <dc:title><r:Alt><r:li xml:lang="x-default">Kept title</r:li></r:Alt></dc:title>
+   <dc:subject><r:Bag><r:li>old</r:li></r:Bag></dc:subject>
+   <darktable:history><r:Seq><r:li darktable:operation="exposure"/></r:Seq></darktable:history>
+  </r:Description>
+ </r:RDF>
+</x:xmpmeta>`
+	os.WriteFile(filepath.Join(dir, "edited.xmp"), []byte(existing), 0644)
+	if _, err := WriteSidecar(path, Edit{Keywords: &keywords, Rating: &rating}); err != nil {
+		t.Fatalf("WriteSidecar failed: %v", err)
+	}
+	info, err = Read(path)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if info.Title != "Kept title" || info.Rating != rating || !slices.Equal(info.Keywords, keywords) {
+		t.Errorf("Read() = %+v after editing the sidecar", info)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "edited.xmp"))
+	for _, want := range []string{`darktable:operation="exposure"`, `darktable:xmp_version="5"`, "<r:RDF"} {
+		if !bytes.Contains(data, []byte(want)) {
+			t.Errorf("edited sidecar lost %q:\n%s", want, data)
+		}
+	}
+	if bytes.Contains(data, []byte(`xmp:Rating="5"`)) || bytes.Contains(data, []byte("<r:li>old</r:li>")) {
+		t.Errorf("edited sidecar still holds the old values:\n%s", data)
+	}
+	if _, err := os.Stat(path + ".xmp"); !os.IsNotExist(err) {
+		t.Errorf("a second sidecar was created next to the existing one")
+	}
+}
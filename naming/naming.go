@@ -0,0 +1,156 @@
+// Package naming expands path patterns such as "{year}/{year}-{month}-{day}"
+// from the metadata of an image.
+package naming
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fields are the values a pattern can refer to.
+type Fields struct {
+	Time   time.Time // Capture time
+	Name   string    // Original file name without its extension
+	Ext    string    // Original extension without the dot
+	Camera string    // Camera model, may be empty
+	Seq    int       // Position of the image in its batch, from 1
+}
+
+// field describes a placeholder. Placeholders taking an argument, written
+// as {name:arg}, have a default used when it is left out.
+type field struct {
+	value      func(f Fields, arg string) string
+	defaultArg string
+	check      func(arg string) error // nil for placeholders without argument
+}
+
+// fields maps each placeholder to its value.
+var fields = map[string]field{
+	"year":   {value: func(f Fields, _ string) string { return f.Time.Format("2006") }},
+	"month":  {value: func(f Fields, _ string) string { return f.Time.Format("01") }},
+	"day":    {value: func(f Fields, _ string) string { return f.Time.Format("02") }},
+	"hour":   {value: func(f Fields, _ string) string { return f.Time.Format("15") }},
+	"minute": {value: func(f Fields, _ string) string { return f.Time.Format("04") }},
+	"second": {value: func(f Fields, _ string) string { return f.Time.Format("05") }},
+	"name":   {value: func(f Fields, _ string) string { return f.Name }},
+	"ext":    {value: func(f Fields, _ string) string { return f.Ext }},
+	"camera": {value: func(f Fields, _ string) string {
+		if f.Camera == "" {
+			return "unknown"
+		}
+		return f.Camera
+	}},
+	// {date:2006-01-02_150405} formats the capture time with a Go layout.
+	"date": {
+		value:      func(f Fields, layout string) string { return f.Time.Format(layout) },
+		defaultArg: "2006-01-02",
+		check:      func(string) error { return nil },
+	},
+	// {seq:3} is the sequence number padded to the given width.
+	"seq": {
+		value: func(f Fields, width string) string {
+			n, _ := strconv.Atoi(width)
+			return fmt.Sprintf("%0*d", n, f.Seq)
+		},
+		defaultArg: "4",
+		check: func(width string) error {
+			if n, err := strconv.Atoi(width); err != nil || n < 1 || n > 9 {
+				return fmt.Errorf("width %q is not a number from 1 to 9", width)
+			}
+			return nil
+		},
+	},
+}
+
+// part is a literal run of a pattern or a placeholder.
+type part struct {
+	literal string
+	field   string
+	arg     string
+}
+
+// Pattern is a parsed naming pattern. Placeholders are written in braces:
+// {year}, {month}, {day}, {hour}, {minute}, {second}, {date:layout},
+// {name}, {ext}, {camera} and {seq:width}.
+type Pattern struct {
+	source string
+	parts  []part
+}
+
+// Parse parses a pattern, rejecting unknown placeholders.
+func Parse(s string) (Pattern, error) {
+	p := Pattern{source: s}
+	for rest := s; rest != ""; {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			p.parts = append(p.parts, part{literal: rest})
+			break
+		}
+		if open > 0 {
+			p.parts = append(p.parts, part{literal: rest[:open]})
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return Pattern{}, fmt.Errorf("pattern %q: unclosed {", s)
+		}
+		name, arg, hasArg := strings.Cut(rest[open+1:open+end], ":")
+		f, ok := fields[name]
+		if !ok {
+			return Pattern{}, fmt.Errorf("pattern %q: unknown placeholder {%s}", s, name)
+		}
+		switch {
+		case hasArg && f.check == nil:
+			return Pattern{}, fmt.Errorf("pattern %q: {%s} takes no argument", s, name)
+		case hasArg:
+			if err := f.check(arg); err != nil {
+				return Pattern{}, fmt.Errorf("pattern %q: {%s}: %w", s, name, err)
+			}
+		default:
+			arg = f.defaultArg
+		}
+		p.parts = append(p.parts, part{field: name, arg: arg})
+		rest = rest[open+end+1:]
+	}
+	return p, nil
+}
+
+// Has reports whether the pattern uses the placeholder name.
+func (p Pattern) Has(name string) bool {
+	for _, pt := range p.parts {
+		if pt.field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the pattern as it was written.
+func (p Pattern) String() string {
+	return p.source
+}
+
+// Expand returns the pattern filled in from f. Values are stripped of path
+// separators, so only the pattern itself can create directories.
+func (p Pattern) Expand(f Fields) string {
+	var b strings.Builder
+	for _, pt := range p.parts {
+		if pt.field == "" {
+			b.WriteString(pt.literal)
+			continue
+		}
+		b.WriteString(sanitize(fields[pt.field].value(f, pt.arg)))
+	}
+	return b.String()
+}
+
+var separators = strings.NewReplacer("/", "-", "\\", "-", "\x00", "")
+
+func sanitize(s string) string {
+	s = strings.TrimSpace(separators.Replace(s))
+	if s == "." || s == ".." {
+		return strings.Repeat("_", len(s))
+	}
+	return s
+}
@@ -0,0 +1,56 @@
+package naming
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpand(t *testing.T) {
+	f := Fields{
+		Time:   time.Date(2023, 3, 4, 15, 9, 26, 0, time.Local),
+		Name:   "IMG_0001",
+		Ext:    "jpg",
+		Camera: "EOS R5/II",
+		Seq:    7,
+	}
+	tests := map[string]string{
+		"{year}/{year}-{month}-{day}":                     "2023/2023-03-04",
+		"{year}{month}{day}_{hour}{minute}{second}.{ext}": "20230304_150926.jpg",
+		"{camera}/{name}":                                 "EOS R5-II/IMG_0001",
+		"plain":                                           "plain",
+	}
+	for pattern, want := range tests {
+		p, err := Parse(pattern)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", pattern, err)
+			continue
+		}
+		if got := p.Expand(f); got != want {
+			t.Errorf("Expand(%q) = %q; want %q", pattern, got, want)
+		}
+	}
+
+	if got := mustParse(t, "{camera}").Expand(Fields{}); got != "unknown" {
+		t.Errorf("Expand({camera}) without a camera = %q; want unknown", got)
+	}
+	if got := mustParse(t, "{name}").Expand(Fields{Name: ".."}); got != "__" {
+		t.Errorf("Expand({name}) of .. = %q; want __", got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, pattern := range []string{"{year", "{yaer}/x", "{}", "{year:06}", "{seq:x}", "{seq:0}"} {
+		if _, err := Parse(pattern); err == nil {
+			t.Errorf("Parse(%q) succeeded; want an error", pattern)
+		}
+	}
+}
+
+func mustParse(t *testing.T, s string) Pattern {
+	t.Helper()
+	p, err := Parse(s)
+	if err != nil {
+		t.Fatalf("Parse(%q) failed: %v", s, err)
+	}
+	return p
+}
@@ -0,0 +1,57 @@
+// Package protect provides the command protecting folders of the generated
+// gallery with a password, for galleries published without a server.
+package protect
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/image-archive/indexer"
+	"github.com/image-archive/prompt"
+	"github.com/image-archive/state"
+	"github.com/spf13/cobra"
+)
+
+var remove bool // --remove
+
+// NewCommand returns the protect subcommand.
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "protect [directory] [folder]",
+		Short: "Encrypt a folder of the gallery so that its pages ask for a password",
+		Long: "Encrypt the pages and images of folder, relative to the archive, and of the\n" +
+			"folders below it. Visitors of the published gallery enter the password in\n" +
+			"the browser, which decrypts the folder; no server is involved. The password\n" +
+			"is read from the terminal, or else from the first line of the standard input.\n\n" +
+			"The originals stay unencrypted next to the pages: publish the archive without\n" +
+			"the files listed in " + filepath.Join(state.Dir, indexer.ExcludeFile) + ", for instance with\n" +
+			"rsync --exclude-from.",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			root := filepath.Clean(args[0])
+			folder := filepath.ToSlash(args[1])
+			if remove {
+				if err := indexer.Unprotect(root, folder); err != nil {
+					return err
+				}
+			} else {
+				password, err := prompt.NewPassword()
+				if err != nil {
+					return err
+				}
+				if err := indexer.Protect(root, folder, password); err != nil {
+					return err
+				}
+			}
+			indexer.Update(root, filepath.Join(root, filepath.FromSlash(folder)))
+			if remove {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s is no longer protected\n", folder)
+			} else {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s is protected; publish without the files in %s\n", folder, state.Path(root, indexer.ExcludeFile))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&remove, "remove", false, "Publish the folder unencrypted again")
+	return cmd
+}
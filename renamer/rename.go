@@ -0,0 +1,480 @@
+// Package renamer gives the images of an archive consistent names built
+// from their metadata, keeping thumbnails and pages in step.
+package renamer
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/image-archive/indexer"
+	"github.com/image-archive/metadata"
+	"github.com/image-archive/naming"
+	"github.com/image-archive/share"
+	"github.com/image-archive/state"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pattern string // --pattern
+	dryRun  bool   // --dry-run
+	undo    bool   // --undo
+)
+
+// logFile is the log of applied renames, one JSON Move per line, kept in
+// the state directory.
+const logFile = "rename-log.jsonl"
+
+// Move renames one image within its folder.
+type Move struct {
+	Run  string `json:",omitempty"` // Time of the run, shared by all its moves
+	Dir  string // Slash path of the folder relative to the root
+	From string
+	To   string
+}
+
+// NewCommand returns the rename subcommand.
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rename <root> [folder...]",
+		Short: "Rename images from a pattern of their capture date, camera and sequence",
+		Long: "Rename the images of the archive at root, or only those below the given\n" +
+			"folders, from a pattern such as {date:2006-01-02_150405}_{camera}_{seq}.\n" +
+			"The original extension is kept. Images are numbered per folder in capture\n" +
+			"order, thumbnails and previews are renamed along with them and pages are regenerated.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			root := filepath.Clean(args[0])
+			if undo {
+				return Undo(cmd.OutOrStdout(), root)
+			}
+			p, err := naming.Parse(pattern)
+			if err != nil {
+				return err
+			}
+			if strings.ContainsAny(pattern, `/\`) {
+				return fmt.Errorf("pattern %q must not contain path separators; rename only changes file names", pattern)
+			}
+			return Rename(cmd.OutOrStdout(), root, args[1:], p)
+		},
+	}
+	cmd.Flags().StringVar(&pattern, "pattern", "{date:2006-01-02_150405}_{camera}_{seq}", "New name without extension, from {date:layout} {year} {month} {day} {hour} {minute} {second} {camera} {name} {seq:width}")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only print the new names")
+	cmd.Flags().BoolVar(&undo, "undo", false, "Revert the last applied rename")
+	return cmd
+}
+
+// Rename renames the images below folders, slash paths relative to root,
+// or the whole archive if there are none.
+func Rename(w io.Writer, root string, folders []string, p naming.Pattern) error {
+	moves, err := Plan(root, folders, p)
+	if err != nil {
+		return err
+	}
+	for _, m := range moves {
+		fmt.Fprintf(w, "%s -> %s\n", path.Join(m.Dir, m.From), m.To)
+	}
+	if dryRun {
+		fmt.Fprintf(w, "Dry run: %d images would be renamed\n", len(moves))
+		return nil
+	}
+	if len(moves) == 0 {
+		fmt.Fprintln(w, "Nothing to rename.")
+		return nil
+	}
+
+	// Each move is logged as soon as it is done, so that an interrupted run
+	// can be reverted too.
+	f, err := openLog(root)
+	if err != nil {
+		return fmt.Errorf("writing rename log: %w", err)
+	}
+	run := time.Now().Format(time.RFC3339Nano)
+	enc := json.NewEncoder(f)
+	var done []Move
+	err = apply(root, moves, func(m Move) {
+		m.Run = run
+		if err := enc.Encode(m); err != nil {
+			log.Printf("Failed to log the rename of %s: %v", path.Join(m.Dir, m.From), err)
+		}
+		done = append(done, m)
+	})
+	if cerr := f.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("writing rename log: %w", cerr)
+	}
+	updateReferences(w, root, done)
+	reindex(root, done)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Renamed %d images. Run with --undo to revert.\n", len(moves))
+	return nil
+}
+
+// photo is an image to rename along with what its new name is built from.
+type photo struct {
+	name   string
+	fields naming.Fields
+}
+
+// Plan returns the renames giving every image below folders its name from
+// the pattern. Images numbered by {seq} are taken in capture order. Names
+// that collide with another image or file of the folder get a "-2", "-3"
+// suffix.
+func Plan(root string, folders []string, p naming.Pattern) ([]Move, error) {
+	for i, f := range folders {
+		folders[i] = path.Clean(filepath.ToSlash(f))
+	}
+
+	byDir := make(map[string][]photo)
+	err := indexer.WalkImages(root, func(rel string, info fs.FileInfo) error {
+		dir, name := path.Split(rel)
+		dir = path.Clean(dir)
+		if len(folders) > 0 && !slices.ContainsFunc(folders, func(f string) bool {
+			return f == "." || dir == f || strings.HasPrefix(dir, f+"/")
+		}) {
+			return nil
+		}
+
+		meta, err := metadata.Read(filepath.Join(root, filepath.FromSlash(rel)))
+		if err != nil {
+			log.Printf("Failed to read metadata of %s: %v", rel, err)
+		}
+		taken := meta.DateTimeOriginal
+		if taken.IsZero() {
+			taken = info.ModTime()
+		}
+		ext := path.Ext(name)
+		byDir[dir] = append(byDir[dir], photo{name: name, fields: naming.Fields{
+			Time:   taken,
+			Name:   strings.TrimSuffix(name, ext),
+			Ext:    strings.TrimPrefix(ext, "."),
+			Camera: meta.Camera(),
+		}})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := make([]string, 0, len(byDir))
+	for dir := range byDir {
+		dirs = append(dirs, dir)
+	}
+	slices.Sort(dirs)
+
+	var moves []Move
+	for _, dir := range dirs {
+		images := byDir[dir]
+		slices.SortFunc(images, func(a, b photo) int {
+			if c := a.fields.Time.Compare(b.fields.Time); c != 0 {
+				return c
+			}
+			return cmp.Compare(a.name, b.name)
+		})
+
+		// Names held by files that are not renamed, such as other files
+		// of the folder, can't be taken.
+		used := make(map[string]bool)
+		entries, err := os.ReadDir(filepath.Join(root, filepath.FromSlash(dir)))
+		if err != nil {
+			return nil, err
+		}
+		renamed := make(map[string]bool, len(images))
+		for _, img := range images {
+			renamed[strings.ToLower(img.name)] = true
+		}
+		for _, e := range entries {
+			if !renamed[strings.ToLower(e.Name())] {
+				used[strings.ToLower(e.Name())] = true
+			}
+		}
+
+		for i, img := range images {
+			img.fields.Seq = i + 1
+			to := freeName(p.Expand(img.fields), path.Ext(img.name), used)
+			if to != img.name {
+				moves = append(moves, Move{Dir: dir, From: img.name, To: to})
+			}
+		}
+	}
+	return moves, nil
+}
+
+// freeName returns base+ext, numbered if the name is used, and marks it as
+// used. Names are compared without case, as some file systems do.
+func freeName(base, ext string, used map[string]bool) string {
+	if base == "" {
+		base = "_"
+	}
+	name := base + ext
+	for i := 2; used[strings.ToLower(name)]; i++ {
+		name = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+	used[strings.ToLower(name)] = true
+	return name
+}
+
+// apply performs the moves along with their thumbnails, previews and XMP
+// sidecars, calling done after each image got its new name.
+// Every file is first given a temporary name, so that swaps and chains of
+// renames within a folder never overwrite each other.
+func apply(root string, moves []Move, done func(Move)) error {
+	type file struct{ from, tmp, to string }
+	renames := make([][]file, len(moves)) // Image first, then its companions
+	for i, m := range moves {
+		dir := filepath.Join(root, filepath.FromSlash(m.Dir))
+		thumbs := filepath.Join(dir, ".thumbs")
+		previews := filepath.Join(dir, ".previews")
+		tmp := ".ima-rename-" + m.To
+		renames[i] = []file{
+			{filepath.Join(dir, m.From), filepath.Join(dir, tmp), filepath.Join(dir, m.To)},
+			{filepath.Join(previews, m.From), filepath.Join(previews, tmp), filepath.Join(previews, m.To)},
+		}
+		// Thumbnail names only differ by the extension of their format.
+		from, tmps, to := indexer.ThumbnailNames(m.From), indexer.ThumbnailNames(tmp), indexer.ThumbnailNames(m.To)
+		for j := range from {
+			renames[i] = append(renames[i], file{filepath.Join(thumbs, from[j]), filepath.Join(thumbs, tmps[j]), filepath.Join(thumbs, to[j])})
+		}
+		if from := metadata.Sidecar(filepath.Join(dir, m.From)); from != "" {
+			to := sidecarName(m, filepath.Base(from))
+			renames[i] = append(renames[i], file{from, filepath.Join(dir, ".ima-rename-"+to), filepath.Join(dir, to)})
+		}
+	}
+
+	// rename renames a file. Only the image itself has to exist.
+	rename := func(from, to string, optional bool) error {
+		err := os.Rename(from, to)
+		if optional && errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	for i, files := range renames {
+		if err := rename(files[0].from, files[0].tmp, false); err != nil {
+			// Put back what was moved so far.
+			for _, done := range renames[:i] {
+				for _, f := range done {
+					os.Rename(f.tmp, f.from)
+				}
+			}
+			return err
+		}
+		for _, f := range files[1:] {
+			rename(f.from, f.tmp, true)
+		}
+	}
+
+	var failed int
+	var moved []Move
+	for i, files := range renames {
+		for j, f := range files {
+			if err := rename(f.tmp, f.to, j > 0); err != nil {
+				log.Printf("Failed to rename %s to %s: %v", f.tmp, f.to, err)
+				failed++
+			} else if j == 0 {
+				moved = append(moved, moves[i])
+				done(moves[i])
+			}
+		}
+	}
+	movePaths(root, moved)
+	if failed > 0 {
+		return fmt.Errorf("%d files were left under a temporary .ima-rename- name", failed)
+	}
+	return nil
+}
+
+// sidecarName returns the new name of the sidecar of a moved image, keeping
+// its naming style: IMG_0001.JPG.xmp or IMG_0001.xmp.
+func sidecarName(m Move, sidecar string) string {
+	if suffix, ok := strings.CutPrefix(sidecar, m.From); ok {
+		return m.To + suffix
+	}
+	return strings.TrimSuffix(m.To, path.Ext(m.To)) + path.Ext(sidecar)
+}
+
+// movePaths carries the cached digests and fixity manifest entries of the
+// renamed images over to their new names, so that a rename is not taken
+// for a change of content.
+func movePaths(root string, moves []Move) {
+	store, err := state.Open(root)
+	if err != nil {
+		log.Printf("Ignoring unreadable state of %s: %v", root, err)
+		store = nil
+	}
+	hashes := state.NewHashes(store, root)
+	for _, m := range moves {
+		hashes.Move(path.Join(m.Dir, m.From), path.Join(m.Dir, m.To))
+	}
+	if err := hashes.Save(); err != nil {
+		log.Printf("Failed to save hashes of %s: %v", root, err)
+	}
+
+	sums, err := indexer.ReadManifest(root)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to read fixity manifest of %s: %v", root, err)
+		return
+	}
+	moved := make(map[string]string)
+	for _, m := range moves {
+		from := path.Join(m.Dir, m.From)
+		if sum, ok := sums[from]; ok {
+			moved[path.Join(m.Dir, m.To)] = sum
+			delete(sums, from)
+		}
+	}
+	for p, sum := range moved {
+		sums[p] = sum
+	}
+	if err := indexer.WriteManifest(root, sums); err != nil {
+		log.Printf("Failed to write fixity manifest of %s: %v", root, err)
+	}
+}
+
+// reindex regenerates the pages of the folders whose images were renamed,
+// unless the archive has not been indexed.
+func reindex(root string, moves []Move) {
+	if _, err := os.Stat(filepath.Join(root, "index.html")); err != nil {
+		return
+	}
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, m := range moves {
+		if !seen[m.Dir] {
+			seen[m.Dir] = true
+			dirs = append(dirs, filepath.Join(root, filepath.FromSlash(m.Dir)))
+		}
+	}
+	indexer.Update(root, dirs...)
+}
+
+// openLog opens the rename log for appending the moves of a run.
+func openLog(root string) (*os.File, error) {
+	p := state.Path(root, logFile)
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(p, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+}
+
+// updateReferences points the album definitions listing moved images at
+// their new names. Share links to moved images are signed for the old
+// name and stop working, so they are reported to be issued again.
+func updateReferences(w io.Writer, root string, moves []Move) {
+	renamed := make(map[string]string, len(moves))
+	for _, m := range moves {
+		renamed[path.Join(m.Dir, m.From)] = path.Join(m.Dir, m.To)
+	}
+	albums, err := indexer.RenameAlbumImages(root, renamed)
+	for _, name := range albums {
+		fmt.Fprintf(w, "Updated album %s\n", name)
+	}
+	if err != nil {
+		log.Printf("Failed to update the albums of %s: %v", root, err)
+	}
+
+	links, err := share.List(root)
+	if err != nil {
+		log.Printf("Failed to read the share links of %s: %v", root, err)
+	}
+	for _, l := range links {
+		if to, ok := renamed[l.Path]; ok && !l.Folder && time.Now().Before(l.Expires) {
+			fmt.Fprintf(w, "Share link %s to %s no longer works; share %s again\n", l.ID, l.Path, to)
+		}
+	}
+}
+
+// Undo reverts the last applied rename of the archive at root.
+func Undo(w io.Writer, root string) error {
+	var moves []Move
+	f, err := os.Open(state.Path(root, logFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintln(w, "Nothing to undo.")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var m Move
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			f.Close()
+			return fmt.Errorf("reading rename log: %w", err)
+		}
+		moves = append(moves, m)
+	}
+	f.Close()
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(moves) == 0 {
+		fmt.Fprintln(w, "Nothing to undo.")
+		return nil
+	}
+
+	last := moves[len(moves)-1].Run
+	i := len(moves)
+	for i > 0 && moves[i-1].Run == last {
+		i--
+	}
+	kept, reverted := moves[:i], moves[i:]
+
+	// Images an interrupted undo already renamed back are not renamed
+	// again, but their references are still updated.
+	var inverse, reverting []Move
+	for _, m := range reverted {
+		back := Move{Dir: m.Dir, From: m.To, To: m.From}
+		inverse = append(inverse, back)
+		dir := filepath.Join(root, filepath.FromSlash(m.Dir))
+		if _, err := os.Lstat(filepath.Join(dir, m.To)); errors.Is(err, fs.ErrNotExist) {
+			if _, err := os.Lstat(filepath.Join(dir, m.From)); err == nil {
+				continue
+			}
+		}
+		reverting = append(reverting, back)
+		fmt.Fprintf(w, "%s -> %s\n", path.Join(m.Dir, m.To), m.From)
+	}
+	if dryRun {
+		fmt.Fprintf(w, "Dry run: %d images would be renamed back\n", len(reverting))
+		return nil
+	}
+	var done []Move
+	if err := apply(root, reverting, func(m Move) { done = append(done, m) }); err != nil {
+		updateReferences(w, root, done)
+		reindex(root, done)
+		return err
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, m := range kept {
+		if err := enc.Encode(m); err != nil {
+			return err
+		}
+	}
+	if err := state.WriteFile(state.Path(root, logFile), buf.Bytes()); err != nil {
+		return fmt.Errorf("writing rename log: %w", err)
+	}
+	fmt.Fprintf(w, "Renamed %d images back.\n", len(reverting))
+	updateReferences(w, root, inverse)
+	reindex(root, inverse)
+	return nil
+}
@@ -0,0 +1,158 @@
+package renamer
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/image-archive/naming"
+	"github.com/image-archive/share"
+)
+
+func writeJPEG(t *testing.T, path string, shade uint8, mtime time.Time) {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = shade
+	}
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, img, nil)
+	os.MkdirAll(filepath.Dir(path), 0755)
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+	os.Chtimes(path, mtime, mtime)
+}
+
+func names(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	return names
+}
+
+func TestRename(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "trip")
+	day := time.Date(2023, 3, 14, 10, 0, 0, 0, time.Local)
+
+	// b.jpg was taken first, so it is numbered 01.
+	writeJPEG(t, filepath.Join(dir, "a.jpg"), 10, day.Add(time.Hour))
+	writeJPEG(t, filepath.Join(dir, "b.jpg"), 20, day)
+	writeJPEG(t, filepath.Join(dir, ".thumbs", "a.jpg"), 10, day)
+	os.WriteFile(filepath.Join(dir, "a.xmp"), []byte("<x:xmpmeta/>"), 0644)
+	writeJPEG(t, filepath.Join(root, "other", "c.jpg"), 30, day)
+	writeJPEG(t, filepath.Join(root, "other", "d.jpg"), 40, day)
+	os.MkdirAll(filepath.Join(root, ".albums"), 0755)
+	album := filepath.Join(root, ".albums", "best.json")
+	os.WriteFile(album, []byte(`{"title": "Best", "cover": "trip/b.jpg", "images": ["trip/a.jpg", "other/c.jpg"]}`), 0644)
+	link, _, err := share.Create(root, "trip/a.jpg", time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := naming.Parse("{date:2006-01-02}_{seq:2}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	moves, err := Plan(root, []string{"trip"}, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Move{
+		{Dir: "trip", From: "b.jpg", To: "2023-03-14_01.jpg"},
+		{Dir: "trip", From: "a.jpg", To: "2023-03-14_02.jpg"},
+	}
+	if !slices.Equal(moves, want) {
+		t.Fatalf("Plan() = %v, want %v", moves, want)
+	}
+
+	var out bytes.Buffer
+	if err := Rename(&out, root, []string{"trip"}, p); err != nil {
+		t.Fatalf("Rename failed: %v\n%s", err, out.String())
+	}
+	if got, want := names(t, dir), []string{"2023-03-14_01.jpg", "2023-03-14_02.jpg", "2023-03-14_02.xmp"}; !slices.Equal(got, want) {
+		t.Errorf("after rename %v, want %v", got, want)
+	}
+	if got, want := names(t, filepath.Join(dir, ".thumbs")), []string{"2023-03-14_02.jpg"}; !slices.Equal(got, want) {
+		t.Errorf("thumbnails after rename %v, want %v", got, want)
+	}
+	if got := names(t, filepath.Join(root, "other")); !slices.Equal(got, []string{"c.jpg", "d.jpg"}) {
+		t.Errorf("folder outside the selection was renamed: %v", got)
+	}
+
+	// Albums follow the renamed images; share links cannot and are
+	// reported.
+	var def struct {
+		Title  string
+		Cover  string
+		Images []string
+	}
+	data, _ := os.ReadFile(album)
+	if err := json.Unmarshal(data, &def); err != nil || def.Title != "Best" || def.Cover != "trip/2023-03-14_01.jpg" ||
+		!slices.Equal(def.Images, []string{"trip/2023-03-14_02.jpg", "other/c.jpg"}) {
+		t.Errorf("album after rename = %s", data)
+	}
+	if !strings.Contains(out.String(), "Share link "+link.ID+" to trip/a.jpg no longer works") {
+		t.Errorf("broken share link was not reported:\n%s", out.String())
+	}
+
+	// Renaming again leaves the renamed folder alone.
+	if moves, err := Plan(root, []string{"trip"}, p); err != nil || len(moves) != 0 {
+		t.Errorf("second Plan() = %v, %v; want no moves", moves, err)
+	}
+
+	// Images taken the same day get numbered names without {seq}.
+	byDay, err := naming.Parse("{date:2006-01-02}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	moves, err = Plan(root, []string{"other"}, byDay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []Move{
+		{Dir: "other", From: "c.jpg", To: "2023-03-14.jpg"},
+		{Dir: "other", From: "d.jpg", To: "2023-03-14-2.jpg"},
+	}
+	if !slices.Equal(moves, want) {
+		t.Errorf("Plan() = %v, want %v", moves, want)
+	}
+
+	// An undo interrupted after reverting b.jpg can be run again.
+	os.Rename(filepath.Join(dir, "2023-03-14_01.jpg"), filepath.Join(dir, "b.jpg"))
+	out.Reset()
+	if err := Undo(&out, root); err != nil {
+		t.Fatalf("Undo failed: %v\n%s", err, out.String())
+	}
+	if got, want := names(t, dir), []string{"a.jpg", "a.xmp", "b.jpg"}; !slices.Equal(got, want) {
+		t.Errorf("after undo %v, want %v", got, want)
+	}
+	if got := names(t, filepath.Join(dir, ".thumbs")); !slices.Equal(got, []string{"a.jpg"}) {
+		t.Errorf("thumbnails after undo %v", got)
+	}
+	if data, _ := os.ReadFile(album); !strings.Contains(string(data), `"trip/a.jpg"`) || !strings.Contains(string(data), `"trip/b.jpg"`) {
+		t.Errorf("album after undo = %s", data)
+	}
+
+	out.Reset()
+	if err := Undo(&out, root); err != nil || out.String() != "Nothing to undo.\n" {
+		t.Errorf("second Undo() = %q, %v", out.String(), err)
+	}
+}
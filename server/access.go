@@ -0,0 +1,222 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/image-archive/indexer"
+	"github.com/image-archive/state"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var usersPath string // --users
+
+// accessFile is the name of the files listing who may view a folder and
+// the folders below it.
+const accessFile = ".imaccess"
+
+// anyUser in an access file allows every signed-in user.
+const anyUser = "*"
+
+// viewer is who makes a request.
+type viewer struct {
+	name  string // Signed-in user, "" if anonymous
+	admin bool   // Carries the token, and may see and edit everything
+}
+
+// rules are the users allowed by each access file, by the slash path of
+// its folder relative to the root.
+type rules map[string][]string
+
+// loadRules reads the access files of the archive at root. Unreadable files
+// allow nobody, so that a typo never opens a folder.
+func loadRules(root string) rules {
+	r := make(rules)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != root && (strings.HasPrefix(d.Name(), ".") || filepath.Dir(p) == root && indexer.IsGenerated(root, d.Name())) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.Name() != accessFile {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, filepath.Dir(p))
+		users, err := readAccess(p)
+		if err != nil {
+			log.Printf("Allowing nobody into %s: %v", filepath.Dir(p), err)
+		}
+		r[filepath.ToSlash(rel)] = users
+		return nil
+	})
+	if err != nil {
+		log.Printf("Reading access files of %s: %v", root, err)
+	}
+	return r
+}
+
+// readAccess reads an access file: user names separated by spaces or
+// lines, with # starting a comment.
+func readAccess(file string) ([]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	users := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		users = append(users, strings.Fields(line)...)
+	}
+	return users, nil
+}
+
+// allows reports whether v may view the folder at the slash path dir. Every
+// access file from the root down to the folder must allow the viewer.
+func (r rules) allows(dir string, v viewer) bool {
+	if v.admin {
+		return true
+	}
+	for d := dir; ; d = path.Dir(d) {
+		if users, ok := r[d]; ok && !permits(users, v) {
+			return false
+		}
+		if d == "." {
+			return true
+		}
+	}
+}
+
+// allowsAll reports whether v may view every folder of the archive.
+func (r rules) allowsAll(v viewer) bool {
+	if v.admin {
+		return true
+	}
+	for _, users := range r {
+		if !permits(users, v) {
+			return false
+		}
+	}
+	return true
+}
+
+func permits(users []string, v viewer) bool {
+	return v.name != "" && (slices.Contains(users, anyUser) || slices.Contains(users, v.name))
+}
+
+// userStore checks passwords against a file of bcrypt hashes, one
+// "name:hash" line per user as written by htpasswd -B. The file is read
+// again when it changes.
+type userStore struct {
+	file string
+
+	mu       sync.Mutex
+	modTime  time.Time
+	hashes   map[string][]byte
+	verified map[[sha256.Size]byte]bool // Passwords already checked, as bcrypt is slow on purpose
+}
+
+func newUserStore(file string) *userStore {
+	return &userStore{file: file, verified: make(map[[sha256.Size]byte]bool)}
+}
+
+// check reports whether password is the password of the user name.
+func (u *userStore) check(name, password string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if info, err := os.Stat(u.file); err != nil {
+		u.hashes = nil
+	} else if !info.ModTime().Equal(u.modTime) {
+		hashes, err := readUsers(u.file)
+		if err != nil {
+			log.Printf("Reading users from %s: %v", u.file, err)
+		}
+		u.hashes, u.modTime = hashes, info.ModTime()
+	}
+
+	hash, ok := u.hashes[name]
+	if !ok {
+		return false
+	}
+	key := sha256.Sum256([]byte(name + "\x00" + string(hash) + "\x00" + password))
+	if u.verified[key] {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return false
+	}
+	u.verified[key] = true
+	return true
+}
+
+// readUsers reads a users file into bcrypt hashes by user name.
+func readUsers(file string) (map[string][]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hashes := make(map[string][]byte)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if name, hash, ok := strings.Cut(line, ":"); ok {
+			hashes[name] = []byte(hash)
+		}
+	}
+	return hashes, scanner.Err()
+}
+
+// SetPassword sets the password of the user name in a users file, adding
+// the user if needed.
+func SetPassword(file, name, password string) error {
+	if name == "" || strings.ContainsAny(name, ": \t\n#") || name == anyUser {
+		return fmt.Errorf("invalid user name %q", name)
+	}
+	if password == "" {
+		return errors.New("empty password")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	var out bytes.Buffer
+	found := false
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if n, _, ok := strings.Cut(strings.TrimSpace(line), ":"); ok && n == name {
+			line, found = name+":"+string(hash)+"\n", true
+		}
+		out.WriteString(line)
+	}
+	if !found {
+		if out.Len() > 0 && !bytes.HasSuffix(out.Bytes(), []byte("\n")) {
+			out.WriteByte('\n')
+		}
+		out.WriteString(name + ":" + string(hash) + "\n")
+	}
+	return state.WriteFile(file, out.Bytes())
+}
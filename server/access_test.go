@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/image-archive/indexer"
+	"github.com/image-archive/state"
+	"github.com/image-archive/watcher"
+)
+
+func TestAccess(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"public/p.jpg", "hr/h.jpg", "hr/sub/s.jpg", "clients/c.jpg"} {
+		writeJPEG(t, filepath.Join(root, filepath.FromSlash(name)))
+	}
+	os.WriteFile(filepath.Join(root, "hr", accessFile), []byte("# HR only\nalice\n"), 0644)
+	os.WriteFile(filepath.Join(root, "clients", accessFile), []byte("*"), 0644)
+	indexer.SplitCreate(root)
+	for _, user := range []string{"alice", "bob"} {
+		if err := SetPassword(state.Path(root, "users"), user, user+"-pw"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := New(root, "secret")
+	get := func(target, user, password string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest("GET", target, nil)
+		if user != "" {
+			r.SetBasicAuth(user, password)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		target, user string
+		want         int
+	}{
+		{"/public/p.jpg", "", http.StatusOK},
+		{"/hr/", "", http.StatusUnauthorized},
+		{"/hr/h.jpg", "", http.StatusUnauthorized},
+		{"/hr/.thumbs/h.jpg", "", http.StatusUnauthorized},
+		{"/hr/sub/s.jpg", "", http.StatusUnauthorized},
+		{"/hr/h.jpg", "bob", http.StatusForbidden},
+		{"/hr/sub/", "alice", http.StatusOK},
+		{"/clients/c.jpg", "", http.StatusUnauthorized},
+		{"/clients/c.jpg", "bob", http.StatusOK},
+		{"/hr/.imaccess", "alice", http.StatusNotFound},
+		{"/search-index.js", "bob", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		if w := get(test.target, test.user, test.user+"-pw"); w.Code != test.want {
+			t.Errorf("GET %s as %q = %d; want %d", test.target, test.user, w.Code, test.want)
+		}
+	}
+	if w := get("/hr/h.jpg", "alice", "wrong"); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("GET with a wrong password = %d; want 401 asking to sign in", w.Code)
+	}
+
+	// Hidden folders are left out of the pages listing them.
+	page := get("/", "bob", "bob-pw").Body.String()
+	if strings.Contains(page, "hr/index.html") || !strings.Contains(page, "clients/index.html") || !strings.Contains(page, "public/index.html") {
+		t.Errorf("root page for bob does not list exactly public and clients:\n%s", page)
+	}
+	page = get("/", "alice", "alice-pw").Body.String()
+	if !strings.Contains(page, "hr/index.html") {
+		t.Errorf("root page for alice does not list hr")
+	}
+	r := httptest.NewRequest("GET", "/hr/h.jpg", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("GET /hr/h.jpg with the token = %d; want 200", w.Code)
+	}
+}
+
+func TestAccessAfterRename(t *testing.T) {
+	root := t.TempDir()
+	writeJPEG(t, filepath.Join(root, "hr", "h.jpg"))
+	os.WriteFile(filepath.Join(root, "hr", accessFile), []byte("alice\n"), 0644)
+	indexer.SplitCreate(root)
+	s := New(root, "secret")
+
+	// The watcher reports the old name as renamed and the new one as a
+	// created directory.
+	os.Rename(filepath.Join(root, "hr"), filepath.Join(root, "people"))
+	events := make(chan watcher.FileEvent, 2)
+	events <- watcher.FileEvent{Op: fsnotify.Rename, Name: filepath.Join(root, "hr")}
+	events <- watcher.FileEvent{Op: fsnotify.Create, Name: filepath.Join(root, "people"), IsDir: true}
+	close(events)
+	s.watch(context.Background(), events)
+
+	for _, target := range []string{"/people/", "/people/h.jpg"} {
+		r := httptest.NewRequest("GET", target, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("GET %s of the renamed folder = %d; want 401", target, w.Code)
+		}
+	}
+}
+
+func TestSetPassword(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "users")
+	os.WriteFile(file, []byte("# Users\ncarol:$2y$10$invalid"), 0600)
+	SetPassword(file, "alice", "old")
+	SetPassword(file, "alice", "new")
+
+	users := newUserStore(file)
+	if users.check("alice", "old") || !users.check("alice", "new") || !users.check("alice", "new") {
+		t.Errorf("the password of alice was not changed")
+	}
+	data, _ := os.ReadFile(file)
+	if !strings.HasPrefix(string(data), "# Users\ncarol:$2y$10$invalid\nalice:") || strings.Count(string(data), "alice:") != 1 {
+		t.Errorf("users file = %q", data)
+	}
+	if err := SetPassword(file, "a:b", "pw"); err == nil {
+		t.Errorf("SetPassword accepted a name with a colon")
+	}
+}
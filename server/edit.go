@@ -0,0 +1,189 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/image-archive/dupes"
+	"github.com/image-archive/indexer"
+	"github.com/image-archive/metadata"
+	"github.com/image-archive/state"
+)
+
+// Meta is the editable metadata of an image.
+type Meta struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Keywords    []string `json:"keywords"`
+	Rating      int      `json:"rating"` // Stars from 1 to 5, -1 if rejected, 0 if unrated
+}
+
+// metaEdit is a request to change the metadata of the image at Path.
+// Missing fields are left as they are.
+type metaEdit struct {
+	Path        string    `json:"path"`
+	Title       *string   `json:"title"`
+	Description *string   `json:"description"`
+	Keywords    *[]string `json:"keywords"`
+	Rating      *int      `json:"rating"`
+}
+
+// handleMeta returns the metadata of an image on GET, and writes the
+// changes posted to its sidecar on POST.
+func (s *Server) handleMeta(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		p, err := s.imagePath(r.URL.Query().Get("path"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.writeMeta(w, p)
+	case http.MethodPost:
+		var req metaEdit
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p, err := s.imagePath(req.Path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Rating != nil && (*req.Rating < -1 || *req.Rating > 5) {
+			http.Error(w, "rating must be from -1 to 5", http.StatusBadRequest)
+			return
+		}
+		if req.Keywords != nil {
+			keywords := cleanKeywords(*req.Keywords)
+			req.Keywords = &keywords
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		edit := metadata.Edit{Title: req.Title, Description: req.Description, Keywords: req.Keywords, Rating: req.Rating}
+		sidecar, err := metadata.WriteSidecar(p, edit)
+		if err != nil {
+			log.Printf("Failed to write sidecar of %s: %v", p, err)
+			http.Error(w, "failed to write the sidecar", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Updated %s", sidecar)
+		indexer.Update(s.root, filepath.Dir(p))
+		s.writeMeta(w, p)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// writeMeta responds with the metadata of the image at p.
+func (s *Server) writeMeta(w http.ResponseWriter, p string) {
+	info, err := metadata.Read(p)
+	if err != nil {
+		log.Printf("Reading metadata of %s: %v", p, err) // Partial metadata is still shown
+	}
+	meta := Meta{Title: info.Title, Description: info.Description, Keywords: info.Keywords, Rating: info.Rating}
+	if meta.Keywords == nil {
+		meta.Keywords = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(meta)
+}
+
+// cleanKeywords trims keywords and drops empty and repeated ones.
+func cleanKeywords(keywords []string) []string {
+	cleaned := []string{}
+	for _, k := range keywords {
+		k = strings.TrimSpace(k)
+		if k != "" && !slices.Contains(cleaned, k) {
+			cleaned = append(cleaned, k)
+		}
+	}
+	return cleaned
+}
+
+// handleTrash moves an image to the trash along with its sidecar, and
+// drops its thumbnail and preview.
+func (s *Server) handleTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Path string `json:"path"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, err := s.imagePath(req.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dst, err := s.moveToTrash(strings.TrimPrefix(req.Path, "/"))
+	if err != nil {
+		log.Printf("Failed to move %s to the trash: %v", p, err)
+		http.Error(w, "failed to move the image to the trash", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Trashed %s", p)
+	indexer.Update(s.root, filepath.Dir(p))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"trash": dst})
+}
+
+// moveToTrash moves the image at the slash path rel into the trash
+// directory and returns its new slash path. Its sidecar follows it and its
+// thumbnail and preview are removed.
+func (s *Server) moveToTrash(rel string) (string, error) {
+	p := filepath.Join(s.root, filepath.FromSlash(rel))
+	sidecar := metadata.Sidecar(p)
+	dst, err := dupes.MoveToTrash(s.root, rel)
+	if err != nil {
+		return "", err
+	}
+	dstPath := filepath.Join(s.root, filepath.FromSlash(dst))
+
+	if sidecar != "" {
+		name := filepath.Base(sidecar)
+		sidecarDst := strings.TrimSuffix(dstPath, filepath.Ext(dstPath)) + filepath.Ext(name)
+		if suffix, ok := strings.CutPrefix(name, filepath.Base(p)); ok {
+			sidecarDst = dstPath + suffix // IMG_0001.JPG.xmp
+		}
+		if err := os.Rename(sidecar, sidecarDst); err != nil {
+			log.Printf("Failed to move %s to the trash: %v", sidecar, err)
+		}
+	}
+	derived := []string{filepath.Join(filepath.Dir(p), ".previews", filepath.Base(p))}
+	for _, name := range indexer.ThumbnailNames(filepath.Base(p)) {
+		derived = append(derived, filepath.Join(filepath.Dir(p), ".thumbs", name))
+	}
+	for _, file := range derived {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove %s: %v", file, err)
+		}
+	}
+
+	store, err := state.Open(s.root)
+	if err != nil {
+		log.Printf("Ignoring unreadable state of %s: %v", s.root, err)
+		store = nil
+	}
+	hashes := state.NewHashes(store, s.root)
+	hashes.Forget(rel)
+	if err := hashes.Save(); err != nil {
+		log.Printf("Failed to save hashes of %s: %v", s.root, err)
+	}
+	return dst, nil
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"bytes"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// folderOf returns the slash path of the folder holding the file at the
+// slash path p, relative to the root. Thumbnails and previews belong to the
+// folder of their image.
+func folderOf(p string) string {
+	dir := path.Dir(path.Clean("/" + p))
+	if base := path.Base(dir); base == ".thumbs" || base == ".previews" {
+		dir = path.Dir(dir)
+	}
+	if dir == "/" {
+		return "."
+	}
+	return strings.TrimPrefix(dir, "/")
+}
+
+// filterPage removes from a page served at the slash path file everything
+// leading to the folders for which hide holds: their entries in the sidebar
+// and folder list, other links to them, and their images.
+func filterPage(page []byte, file string, hide func(folder string) bool) ([]byte, error) {
+	doc, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		return nil, err
+	}
+
+	// hidden reports whether a link from the page leads into a hidden
+	// folder.
+	hidden := func(link string) bool {
+		u, err := url.Parse(link)
+		if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+			return false
+		}
+		target := u.Path
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir("/"+file), target)
+		}
+		folder := folderOf(target)
+		if folder == ".." || strings.HasPrefix(folder, "../") {
+			return false
+		}
+		return hide(folder)
+	}
+
+	var drop []*html.Node
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch {
+			case n.DataAtom == atom.A && hidden(attr(n, "href")):
+				drop = append(drop, container(n, atom.Li))
+				return
+			case n.DataAtom == atom.Img && hidden(attr(n, "src")):
+				if folder := ancestor(n, func(p *html.Node) bool { return hasClass(p, "folder") }); folder != nil {
+					drop = append(drop, n) // A visible folder with a hidden cover
+				} else {
+					drop = append(drop, container(n, atom.A))
+				}
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	for _, n := range drop {
+		if n.Parent != nil {
+			n.Parent.RemoveChild(n)
+		}
+	}
+	var out bytes.Buffer
+	err = html.Render(&out, doc)
+	return out.Bytes(), err
+}
+
+// hideOriginals removes the "View original" links from a page, and the
+// links to originals that full-size images carry for scripts, for pages
+// shared without download permission.
+func hideOriginals(page []byte) ([]byte, error) {
+	doc, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		return nil, err
+	}
+
+	var drop []*html.Node
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			if n.DataAtom == atom.A && hasClass(n, "original") {
+				drop = append(drop, n)
+				return
+			}
+			n.Attr = slices.DeleteFunc(n.Attr, func(a html.Attribute) bool { return a.Key == "data-original" })
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	for _, n := range drop {
+		n.Parent.RemoveChild(n)
+	}
+	var out bytes.Buffer
+	err = html.Render(&out, doc)
+	return out.Bytes(), err
+}
+
+// container returns the element to remove along with n: the closest
+// enclosing element of type a, or the modal or folder card holding n, or
+// else n itself.
+func container(n *html.Node, a atom.Atom) *html.Node {
+	if c := ancestor(n, func(p *html.Node) bool {
+		return p.DataAtom == a || hasClass(p, "modal") || hasClass(p, "folder")
+	}); c != nil {
+		return c
+	}
+	return n
+}
+
+// ancestor returns the closest enclosing element of n, n included, for
+// which match holds.
+func ancestor(n *html.Node, match func(*html.Node) bool) *html.Node {
+	for p := n; p != nil; p = p.Parent {
+		if p.Type == html.ElementNode && match(p) {
+			return p
+		}
+	}
+	return nil
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasClass(n *html.Node, class string) bool {
+	return slices.Contains(strings.Fields(attr(n, "class")), class)
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/image-archive/prompt"
+	"github.com/image-archive/state"
+	"github.com/spf13/cobra"
+)
+
+// newPasswdCommand returns the serve passwd subcommand.
+func newPasswdCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "passwd [directory] [user]",
+		Short: "Add a user who may sign in to the served gallery, or change their password",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file := usersPath
+			if file == "" {
+				file = state.Path(filepath.Clean(args[0]), "users")
+			}
+			password, err := prompt.NewPassword()
+			if err != nil {
+				return err
+			}
+			if err := SetPassword(file, args[1], password); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Password of %s set in %s\n", args[1], file)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,209 @@
+package server
+
+// editScript adds editing controls to the modals of a page served to a
+// signed-in browser, and an upload zone to the page of a folder. The image
+// path is taken from the src of the modal image, which the server resolves
+// against the archive root.
+const editScript = `(() => {
+  const folder = document.currentScript.dataset.upload;
+  const style = document.createElement('style');
+  style.textContent = ` + "`" + `
+    .ima-edit { position: absolute; top: 20px; right: 20px; width: 260px; padding: 12px;
+      background: rgba(0, 0, 0, 0.75); color: #fff; border-radius: 6px; font-size: 14px; }
+    .ima-edit label { display: block; margin-top: 8px; }
+    .ima-edit input, .ima-edit textarea { width: 100%; box-sizing: border-box; margin-top: 2px; }
+    .ima-edit .stars button { background: none; border: none; color: #fc0; font-size: 20px; cursor: pointer; padding: 0 2px; }
+    .ima-edit .actions { display: flex; justify-content: space-between; margin-top: 10px; }
+    .ima-edit .status { display: block; min-height: 1em; margin-top: 6px; color: #ccc; }
+    .ima-drop { margin-bottom: 16px; padding: 16px; border: 2px dashed #bbb; border-radius: 6px;
+      text-align: center; color: #666; cursor: pointer; }
+    .ima-share { margin-bottom: 8px; }
+    .ima-drop.over { border-color: #07c; background: #eef6ff; }
+  ` + "`" + `;
+  document.head.appendChild(style);
+
+  const call = async (api, options) => {
+    const response = await fetch('/_ima/api/' + api, options);
+    if (!response.ok) throw new Error(await response.text());
+    return response.json();
+  };
+  const post = (api, body) => call(api, {
+    method: 'POST',
+    headers: {'Content-Type': 'application/json'},
+    body: JSON.stringify(body),
+  });
+
+  // Issue a share link and show it for copying.
+  const shareLink = async (path) => {
+    const days = prompt('Share ' + (path || 'the whole archive') + ' for how many days?', '7');
+    if (!days) return;
+    const download = confirm('Allow downloading the originals? Cancel to only let them be viewed.');
+    try {
+      const link = await post('share', {path, expires: days + 'd', download});
+      prompt('Share link, valid until ' + new Date(link.expires).toLocaleString(), link.url);
+    } catch (err) {
+      alert(err.message);
+    }
+  };
+
+  const field = (panel, label, tag) => {
+    const wrapper = document.createElement('label');
+    wrapper.textContent = label;
+    const input = document.createElement(tag);
+    wrapper.appendChild(input);
+    panel.appendChild(wrapper);
+    return input;
+  };
+
+  const attach = (modal) => {
+    const img = modal.querySelector('img');
+    const path = decodeURIComponent(new URL(img.dataset.original || img.src, location.href).pathname).slice(1);
+
+    const panel = document.createElement('form');
+    panel.className = 'ima-edit';
+    // Keep typing from closing the modal or paging through images.
+    panel.addEventListener('keydown', (e) => e.stopPropagation());
+
+    const stars = document.createElement('div');
+    stars.className = 'stars';
+    let rating = 0;
+    const showRating = () => stars.querySelectorAll('button').forEach((b, i) => {
+      b.textContent = i < rating ? '★' : '☆';
+    });
+    for (let n = 1; n <= 5; n++) {
+      const star = document.createElement('button');
+      star.type = 'button';
+      star.title = n + ' of 5';
+      star.addEventListener('click', () => {
+        rating = rating === n ? 0 : n; // Clicking the current rating clears it
+        showRating();
+      });
+      stars.appendChild(star);
+    }
+    showRating();
+    panel.appendChild(stars);
+
+    const title = field(panel, 'Title', 'input');
+    const caption = field(panel, 'Caption', 'textarea');
+    const tags = field(panel, 'Tags, separated by commas', 'input');
+    const status = document.createElement('small');
+    status.className = 'status';
+
+    const actions = document.createElement('div');
+    actions.className = 'actions';
+    const save = document.createElement('button');
+    save.textContent = 'Save';
+    const shareButton = document.createElement('button');
+    shareButton.type = 'button';
+    shareButton.textContent = 'Share';
+    shareButton.addEventListener('click', () => shareLink(path));
+    const trash = document.createElement('button');
+    trash.type = 'button';
+    trash.textContent = 'Move to trash';
+    actions.append(save, shareButton, trash);
+    panel.append(actions, status);
+    modal.appendChild(panel);
+
+    let loaded = false;
+    const load = async () => {
+      if (loaded) return;
+      loaded = true;
+      try {
+        const meta = await call('meta?path=' + encodeURIComponent(path));
+        rating = meta.rating;
+        title.value = meta.title;
+        caption.value = meta.description;
+        tags.value = meta.keywords.join(', ');
+        showRating();
+      } catch (err) {
+        status.textContent = err.message;
+      }
+    };
+    if (location.hash === '#' + modal.id) load();
+    window.addEventListener('hashchange', () => {
+      if (location.hash === '#' + modal.id) load();
+    });
+
+    panel.addEventListener('submit', async (e) => {
+      e.preventDefault();
+      status.textContent = 'Saving…';
+      try {
+        await post('meta', {
+          path,
+          rating,
+          title: title.value,
+          description: caption.value,
+          keywords: tags.value.split(','),
+        });
+        location.reload(); // Show the regenerated page
+      } catch (err) {
+        status.textContent = err.message;
+      }
+    });
+    trash.addEventListener('click', async () => {
+      if (!confirm('Move ' + path + ' to the trash?')) return;
+      try {
+        await post('trash', {path});
+        location.hash = '';
+        location.reload();
+      } catch (err) {
+        status.textContent = err.message;
+      }
+    });
+  };
+
+  document.querySelectorAll('.modal').forEach(attach);
+
+  if (folder === undefined) return;
+  const zone = document.createElement('div');
+  zone.className = 'ima-drop';
+  const message = document.createElement('span');
+  message.textContent = 'Drop images here or click to upload';
+  const picker = document.createElement('input');
+  picker.type = 'file';
+  picker.multiple = true;
+  picker.accept = 'image/jpeg,image/png,image/gif';
+  picker.hidden = true;
+  zone.append(message, picker);
+  const shareFolder = document.createElement('button');
+  shareFolder.className = 'ima-share';
+  shareFolder.textContent = 'Share this folder';
+  shareFolder.addEventListener('click', () => shareLink(folder === '.' ? '' : folder));
+  document.querySelector('.content').prepend(shareFolder, zone);
+
+  const upload = async (files) => {
+    if (!files.length) return;
+    const form = new FormData();
+    for (const file of files) form.append('file', file);
+    message.textContent = 'Uploading ' + files.length + ' files…';
+    try {
+      const results = await call('upload?dir=' + encodeURIComponent(folder), {method: 'POST', body: form});
+      const lines = results.map((r) => r.error ? r.name + ': ' + r.error
+        : r.duplicate ? r.name + ': already in the archive as ' + r.duplicate
+        : r.name + ': uploaded');
+      message.innerText = lines.join('\n');
+      // The watcher regenerates the page shortly after the files land.
+      if (results.some((r) => r.path)) setTimeout(() => location.reload(), 1500);
+    } catch (err) {
+      message.textContent = err.message;
+    }
+  };
+  zone.addEventListener('click', () => picker.click());
+  picker.addEventListener('change', () => {
+    upload(picker.files);
+    picker.value = ''; // Let the same files be picked again
+  });
+  document.addEventListener('dragover', (e) => {
+    e.preventDefault();
+    zone.classList.add('over');
+  });
+  document.addEventListener('dragleave', (e) => {
+    if (!e.relatedTarget) zone.classList.remove('over');
+  });
+  document.addEventListener('drop', (e) => {
+    e.preventDefault();
+    zone.classList.remove('over');
+    upload(e.dataTransfer.files);
+  });
+})();
+`
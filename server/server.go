@@ -0,0 +1,408 @@
+// Package server serves an indexed archive over HTTP and lets signed-in
+// browsers edit it: star ratings, titles, captions and keywords are written
+// to XMP sidecars, images can be moved to the trash, and new images can be
+// uploaded into folders. Every change regenerates the affected pages.
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/image-archive/indexer"
+	"github.com/image-archive/share"
+	"github.com/image-archive/state"
+	"github.com/image-archive/watcher"
+	"github.com/spf13/cobra"
+)
+
+var (
+	addr  string // --addr
+	token string // --token
+)
+
+// cookieName is the cookie holding the token of a signed-in browser.
+const cookieName = "ima_token"
+
+// servedDirs are the only hidden directories served. Others hold state, the
+// trash and album definitions.
+var servedDirs = map[string]bool{
+	".thumbs":   true, // Thumbnails
+	".previews": true, // Screen-size previews
+	".locked":   true, // Encrypted copies for protected folders
+}
+
+// NewCommand returns the serve subcommand.
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve [directory]",
+		Short: "Serve the gallery over HTTP, with editing for signed-in browsers",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run(filepath.Clean(args[0]))
+		},
+	}
+	cmd.PersistentFlags().StringVar(&usersPath, "users", "", "File of user names and bcrypt password hashes (default: .ima/users in the directory)")
+	cmd.AddCommand(newPasswdCommand())
+	cmd.Flags().StringVar(&addr, "addr", "127.0.0.1:8080", "Address to listen on")
+	cmd.Flags().StringVar(&token, "token", "", "Token signing browsers in for editing (default: a random one, printed at start)")
+	cmd.Flags().Int64Var(&maxUpload, "max-upload", 200, "Largest image accepted for upload, in megabytes")
+	return cmd
+}
+
+func run(root string) error {
+	if token == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		token = hex.EncodeToString(b)
+	}
+	s := New(root, token)
+
+	// Uploads and changes made outside the browser are picked up by the
+	// watcher.
+	fileWatcher, err := watcher.New(watcher.Config{Path: root, EventBuffer: 100, ExcludeDirs: watcher.GeneratedFiles})
+	if err != nil {
+		return err
+	}
+	defer fileWatcher.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.watch(ctx, fileWatcher.Start(ctx))
+
+	log.Printf("Serving %s on http://%s/", root, addr)
+	log.Printf("Open http://%s/?token=%s to sign in for editing", addr, token)
+	return http.ListenAndServe(addr, s)
+}
+
+// Server serves the archive at root. Anyone may browse the folders without
+// an access file; users sign in with basic authentication to see the others.
+// Requests changing the archive must carry the token, either in a cookie set
+// by visiting any page with ?token=, or as a bearer token.
+type Server struct {
+	root  string
+	token string
+	files http.Handler
+	users *userStore
+
+	mu sync.Mutex // Serializes changes and the page updates following them
+
+	rulesMu sync.RWMutex
+	rules   rules
+}
+
+// New returns a server for the archive at root.
+func New(root, token string) *Server {
+	root = filepath.Clean(root)
+	file := usersPath
+	if file == "" {
+		file = state.Path(root, "users")
+	}
+	return &Server{
+		root:  root,
+		token: token,
+		files: http.FileServer(http.Dir(root)),
+		users: newUserStore(file),
+		rules: loadRules(root),
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, share.Prefix) {
+		s.serveShare(w, r)
+		return
+	}
+	switch r.URL.Path {
+	case "/_ima/edit.js":
+		if !s.authorized(r) {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		w.Write([]byte(editScript))
+	case "/_ima/api/meta":
+		s.api(s.handleMeta)(w, r)
+	case "/_ima/api/trash":
+		s.api(s.handleTrash)(w, r)
+	case "/_ima/api/upload":
+		s.api(s.handleUpload)(w, r)
+	case "/_ima/api/share":
+		s.api(s.handleShare)(w, r)
+	case "/_ima/login":
+		s.handleLogin(w, r)
+	default:
+		s.serveFile(w, r)
+	}
+}
+
+// authorized reports whether the request carries the token.
+func (s *Server) authorized(r *http.Request) bool {
+	given := ""
+	if c, err := r.Cookie(cookieName); err == nil {
+		given = c.Value
+	}
+	if auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		given = auth
+	}
+	return given != "" && subtle.ConstantTimeCompare([]byte(given), []byte(s.token)) == 1
+}
+
+// viewer returns who makes the request.
+func (s *Server) viewer(r *http.Request) viewer {
+	if s.authorized(r) {
+		return viewer{admin: true}
+	}
+	if name, password, ok := r.BasicAuth(); ok && s.users.check(name, password) {
+		return viewer{name: name}
+	}
+	return viewer{}
+}
+
+// deny refuses a request for something v may not view, asking anonymous
+// viewers to sign in.
+func deny(w http.ResponseWriter, v viewer) {
+	if v.name == "" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="image-archive", charset="UTF-8"`)
+		http.Error(w, "sign in to view this folder", http.StatusUnauthorized)
+		return
+	}
+	http.Error(w, "you may not view this folder", http.StatusForbidden)
+}
+
+// handleLogin asks the browser to sign in, then returns to the page given
+// by the next parameter.
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	v := s.viewer(r)
+	if v.name == "" && !v.admin {
+		deny(w, v)
+		return
+	}
+	next := r.URL.Query().Get("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		next = "/"
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// api wraps an API handler, refusing requests without the token and writes
+// made from pages of other sites.
+func (s *Server) api(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorized(r) {
+			http.Error(w, "sign in by opening the URL printed by the serve command", http.StatusUnauthorized)
+			return
+		}
+		if origin := r.Header.Get("Origin"); r.Method != http.MethodGet && origin != "" {
+			if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+				http.Error(w, "cross-origin request", http.StatusForbidden)
+				return
+			}
+		}
+		h(w, r)
+	}
+}
+
+// serveFile serves the files of the archive. Visiting a page with the
+// token signs the browser in, and pages served to signed-in browsers load
+// the editing script. The ZIP of a folder is built on request.
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request) {
+	if given := r.URL.Query().Get("token"); given != "" {
+		if subtle.ConstantTimeCompare([]byte(given), []byte(s.token)) != 1 {
+			http.Error(w, "wrong token", http.StatusForbidden)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     cookieName,
+			Value:    s.token,
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		})
+		u := *r.URL
+		q := u.Query()
+		q.Del("token")
+		u.RawQuery = q.Encode()
+		http.Redirect(w, r, u.String(), http.StatusSeeOther)
+		return
+	}
+
+	for _, segment := range strings.Split(r.URL.Path, "/") {
+		if strings.HasPrefix(segment, ".") && !servedDirs[segment] {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	file := path.Clean(r.URL.Path)
+	if info, err := os.Stat(filepath.Join(s.root, filepath.FromSlash(file))); err == nil && info.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			s.files.ServeHTTP(w, r) // Redirects to the path with a slash
+			return
+		}
+		file = path.Join(file, "index.html") // Folders without a page are not listed
+		if _, err := os.Stat(filepath.Join(s.root, filepath.FromSlash(file))); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	v := s.viewer(r)
+	s.rulesMu.RLock()
+	rules := s.rules
+	s.rulesMu.RUnlock()
+	if !rules.allows(folderOf(file), v) || !rules.allowsAll(v) && listsAll(file) {
+		deny(w, v)
+		return
+	}
+	if path.Base(file) == indexer.ZipFile {
+		s.serveZip(w, r, folderOf(file), func(folder string) bool { return rules.allows(folder, v) })
+		return
+	}
+
+	folder := s.pageFolder(file)
+	filter := !rules.allowsAll(v)
+	if path.Ext(file) != ".html" || !filter && !v.admin && folder == "" {
+		s.files.ServeHTTP(w, r)
+		return
+	}
+	page, err := os.ReadFile(filepath.Join(s.root, filepath.FromSlash(file)))
+	if err != nil {
+		s.files.ServeHTTP(w, r) // Redirects and errors as usual
+		return
+	}
+	if filter {
+		hide := func(folder string) bool { return !rules.allows(folder, v) }
+		if page, err = filterPage(page, file, hide); err != nil {
+			log.Printf("Failed to filter %s: %v", file, err)
+			http.Error(w, "failed to render the page", http.StatusInternalServerError)
+			return
+		}
+	}
+	if folder != "" {
+		page = insertBeforeBodyEnd(page, []byte(zipScript))
+	}
+	if v.admin {
+		page = injectScript(page, folder)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store") // Pages change with every edit and differ by user
+	w.Write(page)
+}
+
+// listsAll reports whether the file at the slash path file lists images of
+// the whole archive outside of pages, which cannot be filtered by user.
+func listsAll(file string) bool {
+	switch strings.TrimPrefix(file, "/") {
+	case "search-index.js", "manifest-sha256.txt":
+		return true
+	}
+	top, _, _ := strings.Cut(strings.TrimPrefix(file, "/"), "/")
+	return top == "map"
+}
+
+// pageFolder returns the slash path of the folder whose page is at the
+// slash path file, or "" if it is not the page of a folder of images.
+func (s *Server) pageFolder(file string) string {
+	dir, name := path.Split(strings.TrimPrefix(file, "/"))
+	dir = path.Clean(dir)
+	if indexer.IsGenerated(s.root, dir) {
+		return ""
+	}
+	if ok, _ := path.Match("index-*.html", name); !ok && name != "index.html" {
+		return ""
+	}
+	return dir
+}
+
+// injectScript adds the editing script to a page. On the page of a folder,
+// the script also offers to upload images into it.
+func injectScript(page []byte, folder string) []byte {
+	attr := ""
+	if folder != "" {
+		attr = ` data-upload="` + html.EscapeString(folder) + `"`
+	}
+	return insertBeforeBodyEnd(page, []byte(`<script src="/_ima/edit.js"`+attr+` defer></script>`+"\n"))
+}
+
+// insertBeforeBodyEnd inserts markup at the end of the body of a page.
+func insertBeforeBodyEnd(page, markup []byte) []byte {
+	i := bytes.LastIndex(page, []byte("</body>"))
+	if i < 0 {
+		return append(page, markup...)
+	}
+	return append(page[:i:i], append(markup, page[i:]...)...)
+}
+
+// watch regenerates the pages affected by each event from the watcher, in
+// turn with the changes made through the server.
+func (s *Server) watch(ctx context.Context, events <-chan watcher.FileEvent) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			dir := event.Name
+			if !event.IsDir {
+				dir = filepath.Dir(dir)
+			}
+			if changesRules(event) {
+				s.rulesMu.Lock()
+				s.rules = loadRules(s.root)
+				s.rulesMu.Unlock()
+			}
+			s.mu.Lock()
+			indexer.Update(s.root, dir)
+			s.mu.Unlock()
+		}
+	}
+}
+
+// changesRules reports whether an event may add, drop or move access
+// files: a change to one, a directory created or moved in, or a path moved
+// away or removed, which may have been a directory. Rules are keyed by
+// folder, so a renamed folder must not keep being served under its new
+// path without them.
+func changesRules(event watcher.FileEvent) bool {
+	return filepath.Base(event.Name) == accessFile || event.IsDir ||
+		event.Op&(fsnotify.Rename|fsnotify.Remove) != 0
+}
+
+// imagePath checks that the slash path rel names an image of the archive
+// and returns its file path.
+func (s *Server) imagePath(rel string) (string, error) {
+	rel = strings.TrimPrefix(rel, "/")
+	clean := path.Clean(rel)
+	if rel == "" || clean != rel || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("invalid path %q", rel)
+	}
+	for _, segment := range strings.Split(path.Dir(clean), "/") {
+		if strings.HasPrefix(segment, ".") && segment != "." {
+			return "", fmt.Errorf("invalid path %q", rel)
+		}
+	}
+	if !indexer.IsImageFile(path.Base(clean)) {
+		return "", fmt.Errorf("%q is not an image", rel)
+	}
+	p := filepath.Join(s.root, filepath.FromSlash(clean))
+	if info, err := os.Stat(p); err != nil || !info.Mode().IsRegular() {
+		return "", fmt.Errorf("no image at %q", rel)
+	}
+	return p, nil
+}
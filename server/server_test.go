@@ -0,0 +1,214 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/image-archive/indexer"
+	"github.com/image-archive/metadata"
+)
+
+func writeJPEG(t *testing.T, path string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil)
+	os.MkdirAll(filepath.Dir(path), 0755)
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestServe(t *testing.T) {
+	root := t.TempDir()
+	original := writeJPEG(t, filepath.Join(root, "trip", "a.jpg"))
+	writeJPEG(t, filepath.Join(root, "trip", "b.jpg"))
+	indexer.SplitCreate(root)
+
+	s := New(root, "secret")
+	do := func(method, target, body string, header map[string]string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+	auth := map[string]string{"Authorization": "Bearer secret"}
+
+	// Browsing needs no token, and only signed-in browsers get the editor.
+	if w := do("GET", "/trip/", "", nil); w.Code != http.StatusOK || strings.Contains(w.Body.String(), "edit.js") {
+		t.Errorf("anonymous GET /trip/ = %d, editor included: %v", w.Code, strings.Contains(w.Body.String(), "edit.js"))
+	}
+	if w := do("GET", "/trip/", "", auth); !strings.Contains(w.Body.String(), `<script src="/_ima/edit.js" data-upload="trip" defer></script>`) {
+		t.Errorf("signed-in GET /trip/ does not load the editor")
+	}
+	w := do("GET", "/trip/?token=secret", "", nil)
+	if w.Code != http.StatusSeeOther || !strings.Contains(w.Header().Get("Set-Cookie"), cookieName+"=secret") {
+		t.Errorf("GET ?token= = %d, Set-Cookie %q; want a redirect setting the cookie", w.Code, w.Header().Get("Set-Cookie"))
+	}
+	if w := do("GET", "/.ima/state.json", "", auth); w.Code != http.StatusNotFound {
+		t.Errorf("GET /.ima/state.json = %d; want 404", w.Code)
+	}
+
+	edit := `{"path": "trip/a.jpg", "title": "Lake", "keywords": ["water", " lake ", ""], "rating": 4}`
+	if w := do("POST", "/_ima/api/meta", edit, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("POST without token = %d; want 401", w.Code)
+	}
+	if w := do("POST", "/_ima/api/meta", edit, map[string]string{"Authorization": "Bearer secret", "Origin": "https://evil.example"}); w.Code != http.StatusForbidden {
+		t.Errorf("cross-origin POST = %d; want 403", w.Code)
+	}
+	for _, p := range []string{"../a.jpg", "trip/../../a.jpg", ".ima/state.json", "trip/index.html"} {
+		if w := do("GET", "/_ima/api/meta?path="+p, "", auth); w.Code != http.StatusBadRequest {
+			t.Errorf("GET meta of %s = %d; want 400", p, w.Code)
+		}
+	}
+
+	w = do("POST", "/_ima/api/meta", edit, auth)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"keywords":["water","lake"],"rating":4`) {
+		t.Fatalf("POST meta = %d %s", w.Code, w.Body)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "trip", "a.jpg")); !bytes.Equal(data, original) {
+		t.Errorf("the original image was modified")
+	}
+	info, err := metadata.Read(filepath.Join(root, "trip", "a.jpg"))
+	if err != nil || info.Title != "Lake" || info.Rating != 4 {
+		t.Errorf("metadata after edit = %+v, %v", info, err)
+	}
+	page, _ := os.ReadFile(filepath.Join(root, "trip", "index.html"))
+	if !strings.Contains(string(page), "Lake") || !strings.Contains(string(page), "★★★★") {
+		t.Errorf("the folder page was not regenerated with the edit")
+	}
+
+	// Trashing moves the image with its sidecar and drops it from the page.
+	w = do("POST", "/_ima/api/trash", `{"path": "trip/a.jpg"}`, auth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST trash = %d %s", w.Code, w.Body)
+	}
+	for _, p := range []string{".trash/trip/a.jpg", ".trash/trip/a.jpg.xmp"} {
+		if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(p))); err != nil {
+			t.Errorf("%s is missing: %v", p, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "trip", "a.jpg.xmp")); !os.IsNotExist(err) {
+		t.Errorf("the sidecar was left behind")
+	}
+	page, _ = os.ReadFile(filepath.Join(root, "trip", "index.html"))
+	if strings.Contains(string(page), "a.jpg") || !strings.Contains(string(page), "b.jpg") {
+		t.Errorf("the folder page was not regenerated after trashing")
+	}
+
+	// A second image of the same name gets a numbered name in the trash.
+	writeJPEG(t, filepath.Join(root, "trip", "a.jpg"))
+	if w := do("POST", "/_ima/api/trash", `{"path": "trip/a.jpg"}`, auth); !strings.Contains(w.Body.String(), ".trash/trip/a~2.jpg") {
+		t.Errorf("POST trash = %s; want a~2.jpg", w.Body)
+	}
+}
+
+func TestUpload(t *testing.T) {
+	root := t.TempDir()
+	existing := writeJPEG(t, filepath.Join(root, "trip", "a.jpg"))
+	// Tag pages, as the indexer marks them.
+	os.MkdirAll(filepath.Join(root, "tags"), 0755)
+	os.WriteFile(filepath.Join(root, "tags", ".ima-generated"), nil, 0644)
+
+	oldMax := maxUpload
+	t.Cleanup(func() { maxUpload = oldMax })
+	maxUpload = 1
+
+	var buf bytes.Buffer
+	img := image.NewGray(image.Rect(0, 0, 16, 16))
+	img.Pix[0] = 255
+	jpeg.Encode(&buf, img, nil)
+	fresh := buf.Bytes()
+
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"new.jpg", fresh},
+		{`C:\Users\me\copy.jpg`, existing},
+		{"a.jpg", fresh}, // Same content as new.jpg, uploaded just before
+		{"notes.txt", []byte("hello")},
+		{"fake.png", []byte("not a png")},
+		{"huge.jpg", append(fresh[:len(fresh):len(fresh)], make([]byte, 2<<20)...)},
+	}
+	upload := func(dir string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for _, f := range files {
+			fw, _ := mw.CreateFormFile("file", f.name)
+			fw.Write(f.data)
+		}
+		mw.Close()
+		r := httptest.NewRequest("POST", "/_ima/api/upload?dir="+dir, &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		New(root, "secret").ServeHTTP(w, r)
+		return w
+	}
+
+	for _, dir := range []string{"tags", "..", ".ima", "missing"} {
+		if w := upload(dir); w.Code != http.StatusBadRequest {
+			t.Errorf("upload to %s = %d; want 400", dir, w.Code)
+		}
+	}
+
+	w := upload("trip")
+	var got []Upload
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("upload = %d %s", w.Code, w.Body)
+	}
+	want := []Upload{
+		{Name: "new.jpg", Path: "trip/new.jpg"},
+		{Name: "copy.jpg", Duplicate: "trip/a.jpg"},
+		{Name: "a.jpg", Duplicate: "trip/new.jpg"},
+		{Name: "notes.txt", Error: "not a supported image format"},
+		{Name: "fake.png", Error: "content is text/plain; charset=utf-8, not image/png"},
+		{Name: "huge.jpg", Error: "file too large, the limit is 1 MB"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("upload results = %+v; want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("upload result %d = %+v; want %+v", i, got[i], want[i])
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(root, "trip", "new.jpg")); err != nil || !bytes.Equal(data, fresh) {
+		t.Errorf("trip/new.jpg was not saved: %v", err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(root, "trip"))
+	if len(entries) != 2 {
+		t.Errorf("trip holds %d files; want a.jpg and new.jpg without leftovers", len(entries))
+	}
+
+	// Once the archive is indexed, its catalog names the images to compare
+	// with.
+	indexer.SplitCreate(root)
+	files = files[:1]
+	got = nil
+	if w := upload("trip"); json.Unmarshal(w.Body.Bytes(), &got) != nil || len(got) != 1 || got[0].Duplicate != "trip/new.jpg" {
+		t.Errorf("upload to an indexed archive = %s", w.Body)
+	}
+
+	// Protected folders take no uploads.
+	if err := indexer.Protect(root, "trip", "pw"); err != nil {
+		t.Fatal(err)
+	}
+	if w := upload("trip"); w.Code != http.StatusForbidden {
+		t.Errorf("upload to a protected folder = %d; want 403", w.Code)
+	}
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/image-archive/indexer"
+	"github.com/image-archive/share"
+)
+
+// serveShare serves what a share link grants access to, at the paths of the
+// archive below share.Prefix and the link's token. Pages are served without
+// the links leading out of the shared folder.
+func (s *Server) serveShare(w http.ResponseWriter, r *http.Request) {
+	token, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, share.Prefix), "/")
+	l, err := share.Verify(s.root, token)
+	switch {
+	case errors.Is(err, share.ErrExpired):
+		http.Error(w, "this link has expired", http.StatusGone)
+		return
+	case errors.Is(err, share.ErrInvalid):
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		log.Printf("Checking share link: %v", err)
+		http.Error(w, "failed to check the link", http.StatusInternalServerError)
+		return
+	}
+
+	for _, segment := range strings.Split(rest, "/") {
+		if strings.HasPrefix(segment, ".") && !servedDirs[segment] {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	file := strings.TrimPrefix(path.Clean("/"+rest), "/")
+	if file == "" {
+		file = "."
+	}
+	sub := r.Clone(r.Context())
+	sub.URL.Path = "/" + file
+	if info, err := os.Stat(filepath.Join(s.root, filepath.FromSlash(file))); err == nil && info.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			http.Redirect(w, r, path.Base(r.URL.Path)+"/", http.StatusMovedPermanently)
+			return
+		}
+		file = path.Join(file, "index.html")
+	}
+	if !l.Covers(file) {
+		http.Error(w, "this link does not give access here", http.StatusForbidden)
+		return
+	}
+
+	if path.Base(file) == indexer.ZipFile {
+		if !l.Download {
+			http.Error(w, "this link does not allow downloads", http.StatusForbidden)
+			return
+		}
+		s.serveZip(w, r, folderOf(file), l.Covers)
+		return
+	}
+	if path.Ext(file) != ".html" {
+		// Without download permission, originals larger than the screen
+		// are only seen through their preview.
+		if preview := previewOf(file); !l.Download && preview != "" {
+			if _, err := os.Stat(filepath.Join(s.root, filepath.FromSlash(preview))); err == nil {
+				sub.URL.Path = "/" + preview
+			}
+		}
+		if r.URL.Query().Has("download") {
+			if !l.Download {
+				http.Error(w, "this link does not allow downloads", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Content-Disposition", `attachment; filename="`+strings.ReplaceAll(path.Base(file), `"`, "")+`"`)
+		}
+		s.files.ServeHTTP(w, sub)
+		return
+	}
+	page, err := os.ReadFile(filepath.Join(s.root, filepath.FromSlash(file)))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	page, err = filterPage(page, file, func(folder string) bool { return !l.Covers(folder) })
+	if err != nil {
+		log.Printf("Failed to filter %s: %v", file, err)
+		http.Error(w, "failed to render the page", http.StatusInternalServerError)
+		return
+	}
+	if !l.Download {
+		page, err = hideOriginals(page)
+		if err != nil {
+			log.Printf("Failed to filter %s: %v", file, err)
+			http.Error(w, "failed to render the page", http.StatusInternalServerError)
+			return
+		}
+	}
+	if l.Download {
+		page = insertBeforeBodyEnd(page, []byte(downloadScript))
+		if s.pageFolder(file) != "" {
+			page = insertBeforeBodyEnd(page, []byte(zipScript))
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(page)
+}
+
+// previewOf returns the slash path of the preview of the original image at
+// the slash path file, or "" if file is not an original image.
+func previewOf(file string) string {
+	dir := path.Dir(file)
+	if !indexer.IsImageFile(file) || strings.HasPrefix(path.Base(dir), ".") {
+		return ""
+	}
+	return path.Join(dir, ".previews", path.Base(file))
+}
+
+// downloadScript adds a download link below each full-size image of a page
+// shared with download permission.
+const downloadScript = `<script>
+  document.querySelectorAll('.modal img').forEach((img) => {
+    const link = document.createElement('a');
+    link.href = (img.dataset.original || img.getAttribute('src')) + '?download';
+    link.textContent = 'Download original';
+    link.style.color = '#fff';
+    img.after(link);
+  });
+</script>
+`
+
+// handleShare issues a share link to the folder or image at the posted
+// path, and responds with its URL on this server.
+func (s *Server) handleShare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Path     string `json:"path"`
+		Expires  string `json:"expires"`
+		Download bool   `json:"download"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := s.imagePath(req.Path); err != nil {
+		if _, err := s.uploadDir(req.Path); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	ttl, err := share.ParseTTL(req.Expires)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	l, urlPath, err := share.Create(s.root, req.Path, ttl, req.Download)
+	if err != nil {
+		log.Printf("Failed to share %s: %v", req.Path, err)
+		http.Error(w, "failed to create the link", http.StatusInternalServerError)
+		return
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	log.Printf("Shared %s until %s (ID %s)", l.Path, l.Expires.Format(time.DateTime), l.ID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"url":     scheme + "://" + r.Host + urlPath,
+		"expires": l.Expires.Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/image-archive/indexer"
+	"github.com/image-archive/share"
+)
+
+func TestShare(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"trip/a.jpg", "trip/day-2/b.jpg", "other/c.jpg"} {
+		writeJPEG(t, filepath.Join(root, filepath.FromSlash(name)))
+	}
+	indexer.SplitCreate(root)
+
+	s := New(root, "secret")
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if method == "POST" {
+			r.Header.Set("Authorization", "Bearer secret")
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("POST", "/_ima/api/share", `{"path": "trip", "expires": "2d"}`)
+	var link struct{ URL string }
+	if err := json.Unmarshal(w.Body.Bytes(), &link); err != nil {
+		t.Fatalf("POST share = %d %s", w.Code, w.Body)
+	}
+	u, err := url.Parse(link.URL)
+	if err != nil || !strings.HasPrefix(u.Path, share.Prefix) || !strings.HasSuffix(u.Path, "/trip/") {
+		t.Fatalf("share URL = %s", link.URL)
+	}
+	base := strings.TrimSuffix(u.Path, "trip/")
+
+	page := do("GET", u.Path, "")
+	if page.Code != http.StatusOK {
+		t.Fatalf("GET shared folder = %d", page.Code)
+	}
+	body := page.Body.String()
+	if !strings.Contains(body, `href="day-2/index.html"`) || !strings.Contains(body, `src="a.jpg"`) {
+		t.Errorf("shared page lacks the folder's contents")
+	}
+	if strings.Contains(body, "../index.html") || strings.Contains(body, "other") {
+		t.Errorf("shared page links out of the shared folder")
+	}
+
+	tests := map[string]int{
+		u.Path + "a.jpg":                  http.StatusOK,
+		u.Path + "day-2/":                 http.StatusOK,
+		u.Path + "a.jpg?download":         http.StatusForbidden,
+		base + "other/c.jpg":              http.StatusForbidden,
+		base + "index.html":               http.StatusForbidden,
+		base + ".ima/share-key":           http.StatusNotFound,
+		share.Prefix + "0000.1.v.x/trip/": http.StatusNotFound,
+	}
+	for target, want := range tests {
+		if w := do("GET", target, ""); w.Code != want {
+			t.Errorf("GET %s = %d; want %d", target, w.Code, want)
+		}
+	}
+
+	// Only the token holder may issue links.
+	r := httptest.NewRequest("POST", "/_ima/api/share", strings.NewReader(`{"path": "other", "expires": "1d"}`))
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous POST share = %d; want 401", w.Code)
+	}
+}
+
+func TestShareWithoutDownload(t *testing.T) {
+	root := t.TempDir()
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 2100, 4)), nil)
+	os.MkdirAll(filepath.Join(root, "trip"), 0755)
+	os.WriteFile(filepath.Join(root, "trip", "wide.jpg"), buf.Bytes(), 0644)
+	small := writeJPEG(t, filepath.Join(root, "trip", "small.jpg"))
+	indexer.SplitCreate(root)
+	preview, err := os.ReadFile(filepath.Join(root, "trip", ".previews", "wide.jpg"))
+	if err != nil {
+		t.Fatalf("no preview of wide.jpg: %v", err)
+	}
+
+	l, urlPath, err := share.Create(root, "trip", time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(root, "secret")
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		return w
+	}
+
+	if body := get(urlPath).Body.String(); strings.Contains(body, "View original") || strings.Contains(body, "data-original") {
+		t.Errorf("view-only page links to originals:\n%s", body)
+	}
+	// Originals with a preview are replaced by it; smaller ones are
+	// already screen size.
+	if w := get(urlPath + "wide.jpg"); w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), preview) {
+		t.Errorf("GET wide.jpg with %s = %d, not the preview", l.ID, w.Code)
+	}
+	if w := get(urlPath + "small.jpg"); w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), small) {
+		t.Errorf("GET small.jpg = %d, not the original", w.Code)
+	}
+}
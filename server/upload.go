@@ -0,0 +1,286 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/image-archive/indexer"
+	"github.com/image-archive/state"
+)
+
+var maxUpload int64 // --max-upload, in megabytes
+
+// Upload is the outcome of uploading one file.
+type Upload struct {
+	Name      string `json:"name"`                // Name of the file as uploaded
+	Path      string `json:"path,omitempty"`      // Slash path it was saved under
+	Duplicate string `json:"duplicate,omitempty"` // Slash path of the same image already in the archive
+	Error     string `json:"error,omitempty"`
+}
+
+// errTooLarge is returned for files over --max-upload.
+var errTooLarge = errors.New("file too large")
+
+// uploadDir checks that the slash path rel names a folder of images that
+// uploads may go to and returns it cleaned.
+func (s *Server) uploadDir(rel string) (string, error) {
+	rel = strings.Trim(rel, "/")
+	if rel == "" {
+		rel = "."
+	}
+	clean := path.Clean(rel)
+	if clean != rel || clean == ".." || strings.HasPrefix(clean, "../") || indexer.IsGenerated(s.root, clean) {
+		return "", fmt.Errorf("invalid folder %q", rel)
+	}
+	for _, segment := range strings.Split(clean, "/") {
+		if strings.HasPrefix(segment, ".") && segment != "." {
+			return "", fmt.Errorf("invalid folder %q", rel)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(s.root, filepath.FromSlash(clean))); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("no folder at %q", rel)
+	}
+	return clean, nil
+}
+
+// handleUpload saves the images of a multipart form into the folder named
+// by the dir parameter. Files are streamed to disk as they arrive and each
+// is checked to be an image the indexer shows. Images already somewhere in
+// the archive are dropped. Protected folders take no uploads. The watcher
+// regenerates the folder's page.
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	dir, err := s.uploadDir(r.URL.Query().Get("dir"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Uploads would be published unencrypted next to the encrypted pages.
+	if indexer.IsProtected(s.root, dir) {
+		http.Error(w, fmt.Sprintf("folder %q is protected by a password", dir), http.StatusForbidden)
+		return
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	uploads := []Upload{}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if part.FileName() == "" {
+			continue // Not a file
+		}
+		u := s.upload(dir, part.FileName(), part)
+		if u.Error != "" {
+			log.Printf("Rejected upload of %s: %s", u.Name, u.Error)
+		} else if u.Path != "" {
+			log.Printf("Uploaded %s", u.Path)
+		}
+		uploads = append(uploads, u)
+		part.Close()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(uploads)
+}
+
+// upload saves one uploaded file into dir, a slash path relative to the
+// root.
+func (s *Server) upload(dir, name string, r io.Reader) Upload {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/")) // Browsers may send a full Windows path
+	u := Upload{Name: name}
+	if strings.HasPrefix(name, ".") || !indexer.IsImageFile(name) {
+		u.Error = "not a supported image format"
+		return u
+	}
+
+	tmp, sum, size, err := s.receive(filepath.Join(s.root, filepath.FromSlash(dir)), name, r)
+	if err != nil {
+		u.Error = err.Error()
+		return u
+	}
+	defer os.Remove(tmp)
+
+	// Images of the same size are hashed without holding the lock, so that
+	// other requests are not held up.
+	existing, digests, err := s.find(sum, size)
+	if err != nil {
+		u.Error = "failed to check for duplicates"
+		log.Printf("Checking %s for duplicates: %v", s.root, err)
+		return u
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	store, err := state.Open(s.root)
+	if err != nil {
+		log.Printf("Ignoring unreadable state of %s: %v", s.root, err)
+		store = nil
+	}
+	hashes := state.NewHashes(store, s.root)
+	for _, d := range digests {
+		hashes.Record(d.rel, d.size, d.modTime, d.sum)
+	}
+	defer func() {
+		if err := hashes.Save(); err != nil {
+			log.Printf("Failed to save hashes of %s: %v", s.root, err)
+		}
+	}()
+
+	// The same image may have been uploaded meanwhile, and not be indexed
+	// yet.
+	if existing == "" {
+		existing, _ = hashes.Find(sum)
+	}
+	if existing != "" {
+		u.Duplicate = existing
+		return u
+	}
+
+	rel := freeName(s.root, dir, name)
+	dst := filepath.Join(s.root, filepath.FromSlash(rel))
+	if err := os.Rename(tmp, dst); err != nil {
+		u.Error = "failed to save the file"
+		log.Printf("Failed to save %s: %v", dst, err)
+		return u
+	}
+	if info, err := os.Stat(dst); err == nil {
+		hashes.Record(rel, info.Size(), info.ModTime(), sum)
+	}
+	u.Path = rel
+	return u
+}
+
+// receive streams an upload into a temporary file in dir, checking its size
+// and that its content is of the type its name says, and returns the file
+// along with its digest and size.
+func (s *Server) receive(dir, name string, r io.Reader) (string, string, int64, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(512)
+	want, _, _ := mime.ParseMediaType(mime.TypeByExtension(path.Ext(name)))
+	if got := http.DetectContentType(head); got != want {
+		return "", "", 0, fmt.Errorf("content is %s, not %s", got, want)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".ima-upload-*")
+	if err != nil {
+		log.Printf("Failed to create a file in %s: %v", dir, err)
+		return "", "", 0, errors.New("failed to save the file")
+	}
+	h := sha256.New()
+	limit := maxUpload << 20
+	size, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(br, limit+1))
+	if err == nil && size > limit {
+		err = errTooLarge
+	}
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		if errors.Is(err, errTooLarge) {
+			return "", "", 0, fmt.Errorf("%w, the limit is %d MB", err, maxUpload)
+		}
+		log.Printf("Failed to receive %s: %v", name, err)
+		return "", "", 0, errors.New("failed to receive the file")
+	}
+	return tmp.Name(), hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// digest is the digest of an image computed while looking for duplicates,
+// to be cached once the lock is held.
+type digest struct {
+	rel     string
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+// find returns the slash path of an image of the archive with the given
+// digest and size, or "" if there is none, along with the digests it
+// computed. Only the indexed images of that size are hashed, or all images
+// of that size if the archive has not been indexed, and cached digests are
+// used for those that did not change.
+func (s *Server) find(sum string, size int64) (string, []digest, error) {
+	var candidates []string
+	var err error
+	if _, serr := os.Stat(filepath.Join(s.root, "index.html")); serr == nil {
+		candidates, err = indexer.CatalogedOfSize(s.root, size)
+	} else {
+		err = indexer.WalkImages(s.root, func(rel string, info fs.FileInfo) error {
+			if info.Size() == size {
+				candidates = append(candidates, rel)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	store, err := state.Open(s.root)
+	if err != nil {
+		return "", nil, err
+	}
+	hashes := state.NewHashes(store, s.root)
+
+	var digests []digest
+	for _, rel := range candidates {
+		info, err := os.Stat(filepath.Join(s.root, filepath.FromSlash(rel)))
+		if err != nil || info.Size() != size {
+			continue // Changed since it was indexed
+		}
+		other, err := hashes.Sum(rel, info.Size(), info.ModTime())
+		if err != nil {
+			return "", digests, err
+		}
+		digests = append(digests, digest{rel, info.Size(), info.ModTime(), other})
+		if other == sum {
+			return rel, digests, nil
+		}
+	}
+	return "", digests, nil
+}
+
+// freeName returns the slash path of name in dir, numbered as "name-2.jpg"
+// and so on if a file already has that name.
+func freeName(root, dir, name string) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 2; ; i++ {
+		rel := path.Join(dir, candidate)
+		if _, err := os.Lstat(filepath.Join(root, filepath.FromSlash(rel))); os.IsNotExist(err) {
+			return rel
+		}
+		candidate = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/image-archive/indexer"
+)
+
+// serveZip streams the ZIP of the images of the folder at the slash path
+// folder, and with ?recursive of the folders below it for which include
+// holds. With ?size=web, JPEG images are scaled down to screen size.
+func (s *Server) serveZip(w http.ResponseWriter, r *http.Request, folder string, include func(folder string) bool) {
+	dir := filepath.Join(s.root, filepath.FromSlash(folder))
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() || indexer.IsGenerated(s.root, folder) {
+		http.NotFound(w, r)
+		return
+	}
+	name := path.Base(folder)
+	if folder == "." {
+		abs, _ := filepath.Abs(s.root)
+		name = filepath.Base(abs)
+	}
+	q := r.URL.Query()
+	web := q.Get("size") == "web"
+	if web {
+		name += "-web"
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ".zip"}))
+	w.Header().Set("Cache-Control", "no-store")
+	if err := indexer.WriteZip(w, s.root, folder, q.Has("recursive"), web, include); err != nil {
+		// The response has started; the client sees a truncated archive.
+		log.Printf("Failed to send the ZIP of %s: %v", folder, err)
+	}
+}
+
+// zipScript offers the ZIP of the folder of a page, with or without its
+// subfolders, and with the originals or images scaled down to screen size.
+const zipScript = `<script>
+  (() => {
+    const title = document.querySelector('.content h1');
+    if (!title) return;
+    let link = document.querySelector('a.download');
+    if (!link) {
+      link = document.createElement('a');
+      link.className = 'download';
+      link.textContent = 'Download all';
+      (document.querySelector('.content .description') || title).after(link);
+    }
+    const options = document.createElement('span');
+    options.className = 'download';
+    options.innerHTML = '<label><input type="checkbox" name="recursive"> with subfolders</label> ' +
+      '<label><input type="checkbox" name="web"> screen size</label>';
+    link.after(options);
+    const update = () => {
+      const q = new URLSearchParams();
+      if (options.querySelector('[name=recursive]').checked) q.set('recursive', '1');
+      if (options.querySelector('[name=web]').checked) q.set('size', 'web');
+      link.href = 'index.zip' + (q.size ? '?' + q : '');
+    };
+    options.addEventListener('change', update);
+    update();
+  })();
+</script>
+`
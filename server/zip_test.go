@@ -0,0 +1,78 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/image-archive/indexer"
+	"github.com/image-archive/share"
+)
+
+func TestZip(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"trip/a.jpg", "trip/day-2/b.jpg", "trip/hr/c.jpg"} {
+		writeJPEG(t, filepath.Join(root, filepath.FromSlash(name)))
+	}
+	os.WriteFile(filepath.Join(root, "trip", "hr", accessFile), []byte("alice"), 0644)
+	indexer.SplitCreate(root)
+
+	s := New(root, "secret")
+	get := func(target string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		return w
+	}
+	names := func(w *httptest.ResponseRecorder) []string {
+		t.Helper()
+		r, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+		if err != nil {
+			t.Fatalf("response is no ZIP (%d): %v", w.Code, err)
+		}
+		var names []string
+		for _, f := range r.File {
+			names = append(names, f.Name)
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	w := get("/trip/index.zip")
+	if got := names(w); !slices.Equal(got, []string{"a.jpg"}) {
+		t.Errorf("GET /trip/index.zip holds %v", got)
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename=trip.zip` {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+	// Folders the viewer may not see are left out.
+	if got := names(get("/trip/index.zip?recursive=1")); !slices.Equal(got, []string{"a.jpg", "day-2/b.jpg"}) {
+		t.Errorf("recursive ZIP holds %v", got)
+	}
+	if w := get("/trip/hr/index.zip"); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /trip/hr/index.zip = %d; want 401", w.Code)
+	}
+	if w := get("/tags/index.zip"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /tags/index.zip = %d; want 404", w.Code)
+	}
+	if page := get("/trip/").Body.String(); !strings.Contains(page, "index.zip") {
+		t.Errorf("folder page does not offer the ZIP")
+	}
+
+	// Share links only give the ZIP with download permission.
+	_, view, _ := share.Create(root, "trip", time.Hour, false)
+	if w := get(view + "index.zip"); w.Code != http.StatusForbidden {
+		t.Errorf("GET ZIP of a view-only share = %d; want 403", w.Code)
+	}
+	_, download, _ := share.Create(root, "trip", time.Hour, true)
+	if got := names(get(download + "index.zip?recursive=1")); !slices.Equal(got, []string{"a.jpg", "day-2/b.jpg", "hr/c.jpg"}) {
+		t.Errorf("recursive ZIP of a share holds %v", got)
+	}
+}
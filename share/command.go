@@ -0,0 +1,108 @@
+package share
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	expires  string // --expires
+	download bool   // --download
+	baseURL  string // --url
+)
+
+// NewCommand returns the share subcommand.
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "share [directory] [path]",
+		Short: "Issue an expiring link to a folder or image of the served gallery",
+		Long: "Issue a signed link giving access to the folder or image at path, relative to\n" +
+			"the archive, until it expires or is revoked. Links are only accepted by the\n" +
+			"serve command of the same archive.",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ttl, err := ParseTTL(expires)
+			if err != nil {
+				return err
+			}
+			root := filepath.Clean(args[0])
+			l, urlPath, err := Create(root, filepath.ToSlash(args[1]), ttl, download)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%s%s\nExpires %s (ID %s)\n", strings.TrimSuffix(baseURL, "/"), urlPath, l.Expires.Format(time.DateTime), l.ID)
+			return nil
+		},
+	}
+	cmd.PersistentFlags().StringVar(&baseURL, "url", "http://127.0.0.1:8080", "Address the gallery is served at")
+	cmd.Flags().StringVar(&expires, "expires", "7d", "How long the link is valid, such as 12h or 30d")
+	cmd.Flags().BoolVar(&download, "download", false, "Allow downloading the originals, not only viewing them")
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list [directory]",
+		Short: "List the share links issued",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return printLinks(cmd.OutOrStdout(), filepath.Clean(args[0]))
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "revoke [directory] [id]",
+		Short: "Revoke a share link",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := Revoke(filepath.Clean(args[0]), args[1]); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Revoked %s\n", args[1])
+			return nil
+		},
+	})
+	return cmd
+}
+
+// ParseTTL parses a duration as accepted by time.ParseDuration, or a number
+// of days such as "30d".
+func ParseTTL(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func printLinks(w io.Writer, root string) error {
+	links, err := List(root)
+	if err != nil {
+		return err
+	}
+	if len(links) == 0 {
+		fmt.Fprintln(w, "No share links")
+		return nil
+	}
+	for _, l := range links {
+		status := "expires " + l.Expires.Format(time.DateTime)
+		if time.Now().After(l.Expires) {
+			status = "expired " + l.Expires.Format(time.DateTime)
+		}
+		perm := "view"
+		if l.Download {
+			perm = "download"
+		}
+		u, err := URL(root, baseURL, l)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s  %-8s  %s  %s\n  %s\n", l.ID, perm, status, l.Path, u)
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+// Package share issues links giving people outside the archive access to
+// one folder or image for a limited time. Links are signed with a key kept
+// in the archive's state directory, so only the server of that archive
+// accepts them, and every link issued is recorded so it can be revoked.
+package share
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/image-archive/indexer"
+	"github.com/image-archive/state"
+)
+
+// Prefix is the URL path below which the server serves shared files.
+const Prefix = "/_ima/share/"
+
+// linksFile and keyFile are the files in the state directory holding the
+// issued links and the signing key.
+const (
+	linksFile = "shares.json"
+	keyFile   = "share-key"
+)
+
+var (
+	// ErrInvalid is returned for links that were never issued, were
+	// revoked, or were tampered with.
+	ErrInvalid = errors.New("invalid share link")
+	// ErrExpired is returned for links past their expiry.
+	ErrExpired = errors.New("share link expired")
+)
+
+// Link grants access to a folder, and everything below it, or to one
+// image.
+type Link struct {
+	ID       string
+	Path     string // Slash path relative to the root
+	Folder   bool
+	Download bool // Originals may be downloaded, not only viewed
+	Created  time.Time
+	Expires  time.Time
+}
+
+// Covers reports whether the link grants access to the file or folder at
+// the slash path rel. The link of an image also covers its thumbnails and
+// preview.
+func (l Link) Covers(rel string) bool {
+	if l.Folder {
+		return l.Path == "." || rel == l.Path || strings.HasPrefix(rel, l.Path+"/")
+	}
+	dir, name := path.Split(l.Path)
+	if rel == l.Path || rel == path.Join(dir, ".previews", name) {
+		return true
+	}
+	return slices.ContainsFunc(indexer.ThumbnailNames(name), func(thumb string) bool {
+		return rel == path.Join(dir, ".thumbs", thumb)
+	})
+}
+
+// mu serializes changes to the links files.
+var mu sync.Mutex
+
+// Create issues a link to the folder or image at the slash path rel of the
+// archive at root, valid for ttl. It returns the link along with its URL
+// path.
+func Create(root, rel string, ttl time.Duration, download bool) (Link, string, error) {
+	rel = path.Clean(strings.Trim(rel, "/"))
+	if rel == ".." || strings.HasPrefix(rel, "../") || path.IsAbs(rel) {
+		return Link{}, "", fmt.Errorf("%s is outside the archive", rel)
+	}
+	info, err := os.Stat(filepath.Join(root, filepath.FromSlash(rel)))
+	if err != nil {
+		return Link{}, "", err
+	}
+	if ttl <= 0 {
+		return Link{}, "", errors.New("links must expire in the future")
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return Link{}, "", err
+	}
+	now := time.Now().Truncate(time.Second)
+	l := Link{
+		ID:       hex.EncodeToString(id),
+		Path:     rel,
+		Folder:   info.IsDir(),
+		Download: download,
+		Created:  now,
+		Expires:  now.Add(ttl),
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	key, err := signingKey(root)
+	if err != nil {
+		return Link{}, "", err
+	}
+	links, err := read(root)
+	if err != nil {
+		return Link{}, "", err
+	}
+	if err := write(root, append(links, l)); err != nil {
+		return Link{}, "", err
+	}
+	return l, l.URLPath(key), nil
+}
+
+// token returns the part of the URL identifying and authenticating the
+// link: its ID, expiry and permission, signed along with its path.
+func (l Link) token(key []byte) string {
+	perm := "v"
+	if l.Download {
+		perm = "d"
+	}
+	fields := l.ID + "." + strconv.FormatInt(l.Expires.Unix(), 10) + "." + perm
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(fields + "." + l.Path))
+	return fields + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// URLPath returns the path of the shared folder or image on the server.
+func (l Link) URLPath(key []byte) string {
+	p := Prefix + l.token(key) + "/"
+	if l.Path != "." {
+		p += (&url.URL{Path: l.Path}).EscapedPath()
+		if l.Folder {
+			p += "/"
+		}
+	}
+	return p
+}
+
+// Verify checks a token from a URL against the links issued for the
+// archive at root and returns its link.
+func Verify(root, token string) (Link, error) {
+	id, _, _ := strings.Cut(token, ".")
+
+	mu.Lock()
+	defer mu.Unlock()
+	links, err := read(root)
+	if err != nil {
+		return Link{}, err
+	}
+	i := slices.IndexFunc(links, func(l Link) bool { return l.ID == id })
+	if i < 0 {
+		return Link{}, ErrInvalid
+	}
+	key, err := signingKey(root)
+	if err != nil {
+		return Link{}, err
+	}
+	l := links[i]
+	if !hmac.Equal([]byte(token), []byte(l.token(key))) {
+		return Link{}, ErrInvalid
+	}
+	if time.Now().After(l.Expires) {
+		return Link{}, ErrExpired
+	}
+	return l, nil
+}
+
+// List returns the links issued for the archive at root, oldest first.
+func List(root string) ([]Link, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	return read(root)
+}
+
+// Revoke withdraws the link with the given ID, and drops expired links.
+func Revoke(root, id string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	links, err := read(root)
+	if err != nil {
+		return err
+	}
+	found := false
+	links = slices.DeleteFunc(links, func(l Link) bool {
+		found = found || l.ID == id
+		return l.ID == id || time.Now().After(l.Expires)
+	})
+	if !found {
+		return fmt.Errorf("no share link with ID %s", id)
+	}
+	return write(root, links)
+}
+
+// URL returns the address of the link on the server at base, such as
+// http://gallery.example:8080, signing it with the archive's key.
+func URL(root, base string, l Link) (string, error) {
+	mu.Lock()
+	key, err := signingKey(root)
+	mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(base, "/") + l.URLPath(key), nil
+}
+
+func read(root string) ([]Link, error) {
+	data, err := os.ReadFile(state.Path(root, linksFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var links []Link
+	return links, json.Unmarshal(data, &links)
+}
+
+func write(root string, links []Link) error {
+	data, err := json.MarshalIndent(links, "", "  ")
+	if err != nil {
+		return err
+	}
+	return state.WriteFile(state.Path(root, linksFile), data)
+}
+
+// signingKey returns the key signing the links of the archive at root,
+// creating it on first use.
+func signingKey(root string) ([]byte, error) {
+	file := state.Path(root, keyFile)
+	data, err := os.ReadFile(file)
+	if err == nil {
+		return hex.DecodeString(strings.TrimSpace(string(data)))
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, state.WriteFile(file, []byte(hex.EncodeToString(key)+"\n"))
+}
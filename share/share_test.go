@@ -0,0 +1,88 @@
+package share
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLinks(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "trip"), 0755)
+	os.WriteFile(filepath.Join(root, "trip", "a.jpg"), nil, 0644)
+
+	folder, folderURL, err := Create(root, "trip", time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, imageURL, err := Create(root, "trip/a.jpg", time.Hour, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(folderURL, Prefix) || !strings.HasSuffix(folderURL, "/trip/") || !strings.HasSuffix(imageURL, "/trip/a.jpg") {
+		t.Errorf("URLs = %s, %s", folderURL, imageURL)
+	}
+	if _, _, err := Create(root, "../etc", time.Hour, false); err == nil {
+		t.Errorf("Create accepted a path outside the archive")
+	}
+
+	token := func(urlPath string) string {
+		token, _, _ := strings.Cut(strings.TrimPrefix(urlPath, Prefix), "/")
+		return token
+	}
+	if l, err := Verify(root, token(folderURL)); err != nil || l.ID != folder.ID || !l.Folder {
+		t.Errorf("Verify(folder) = %+v, %v", l, err)
+	}
+	if l, err := Verify(root, token(imageURL)); err != nil || !l.Download || l.Folder {
+		t.Errorf("Verify(image) = %+v, %v", l, err)
+	}
+
+	// Turning a view link into a download link breaks its signature.
+	forged := strings.Replace(token(folderURL), ".v.", ".d.", 1)
+	if _, err := Verify(root, forged); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Verify(forged) = %v; want ErrInvalid", err)
+	}
+
+	for rel, want := range map[string]bool{"trip": true, "trip/b.jpg": true, "trip/.thumbs/b.jpg": true, "tripper/a.jpg": false, ".": false} {
+		if got := folder.Covers(rel); got != want {
+			t.Errorf("folder link covers %s = %v; want %v", rel, got, want)
+		}
+	}
+	for rel, want := range map[string]bool{"trip/a.jpg": true, "trip/.thumbs/a.jpg": true, "trip/b.jpg": false} {
+		if got := image.Covers(rel); got != want {
+			t.Errorf("image link covers %s = %v; want %v", rel, got, want)
+		}
+	}
+
+	if err := Revoke(root, folder.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(root, token(folderURL)); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Verify(revoked) = %v; want ErrInvalid", err)
+	}
+	if links, _ := List(root); len(links) != 1 || links[0].ID != image.ID {
+		t.Errorf("List after revoking = %+v", links)
+	}
+
+	// Create truncates the time of creation to the second, so this link has
+	// already expired.
+	_, expiredURL, _ := Create(root, "trip", time.Nanosecond, false)
+	if _, err := Verify(root, token(expiredURL)); !errors.Is(err, ErrExpired) {
+		t.Errorf("Verify(expired) = %v; want ErrExpired", err)
+	}
+}
+
+func TestParseTTL(t *testing.T) {
+	tests := map[string]time.Duration{"30d": 30 * 24 * time.Hour, "12h": 12 * time.Hour, "90m": 90 * time.Minute}
+	for s, want := range tests {
+		if got, err := ParseTTL(s); err != nil || got != want {
+			t.Errorf("ParseTTL(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+	if _, err := ParseTTL("xd"); err == nil {
+		t.Errorf("ParseTTL(\"xd\") succeeded")
+	}
+}
@@ -0,0 +1,145 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// hashBucket is the state bucket caching file digests.
+const hashBucket = "sha256"
+
+// hashEntry is the digest of a file along with the file version it was
+// computed from.
+type hashEntry struct {
+	Size    int64
+	ModTime time.Time
+	Sum     string
+}
+
+// Hashes computes SHA-256 digests of the files of an archive. Digests are
+// cached in the store by slash path, size and modification time, so only
+// new or changed files are read again. It is safe for concurrent use.
+type Hashes struct {
+	root  string
+	store *Store
+
+	mu      sync.Mutex
+	entries map[string]hashEntry
+}
+
+// NewHashes returns the digest cache of the archive at root. store may be
+// nil, in which case nothing is cached between runs.
+func NewHashes(store *Store, root string) *Hashes {
+	h := &Hashes{root: root, store: store, entries: make(map[string]hashEntry)}
+	if store != nil {
+		if err := store.Get(hashBucket, &h.entries); err != nil {
+			h.entries = make(map[string]hashEntry) // Start over rather than fail
+		}
+	}
+	return h
+}
+
+// Sum returns the hex SHA-256 digest of the file at the slash path rel,
+// whose size and modification time are given.
+func (h *Hashes) Sum(rel string, size int64, modTime time.Time) (string, error) {
+	h.mu.Lock()
+	e, ok := h.entries[rel]
+	h.mu.Unlock()
+	if ok && e.Size == size && e.ModTime.Equal(modTime) {
+		return e.Sum, nil
+	}
+
+	sum, err := FileSHA256(filepath.Join(h.root, filepath.FromSlash(rel)))
+	if err != nil {
+		return "", err
+	}
+
+	h.mu.Lock()
+	h.entries[rel] = hashEntry{Size: size, ModTime: modTime, Sum: sum}
+	h.mu.Unlock()
+	return sum, nil
+}
+
+// Record caches a digest computed elsewhere, such as while copying a file.
+func (h *Hashes) Record(rel string, size int64, modTime time.Time, sum string) {
+	h.mu.Lock()
+	h.entries[rel] = hashEntry{Size: size, ModTime: modTime, Sum: sum}
+	h.mu.Unlock()
+}
+
+// Find returns the slash path of a file whose cached digest is sum, if the
+// file still has the size and modification time the digest was computed
+// from.
+func (h *Hashes) Find(sum string) (string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for rel, e := range h.entries {
+		if e.Sum != sum {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(h.root, filepath.FromSlash(rel)))
+		if err == nil && info.Size() == e.Size && info.ModTime().Equal(e.ModTime) {
+			return rel, true
+		}
+	}
+	return "", false
+}
+
+// Move carries the cached digest of a file over to its new slash path
+// after a rename.
+func (h *Hashes) Move(from, to string) {
+	h.mu.Lock()
+	if e, ok := h.entries[from]; ok {
+		h.entries[to] = e
+		delete(h.entries, from)
+	}
+	h.mu.Unlock()
+}
+
+// Forget drops the cached digest of rel, such as after the file was moved.
+func (h *Hashes) Forget(rel string) {
+	h.mu.Lock()
+	delete(h.entries, rel)
+	h.mu.Unlock()
+}
+
+// Save stores the digests in the store, dropping those of files that no
+// longer exist.
+func (h *Hashes) Save() error {
+	if h.store == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	for rel := range h.entries {
+		if _, err := os.Lstat(filepath.Join(h.root, filepath.FromSlash(rel))); os.IsNotExist(err) {
+			delete(h.entries, rel)
+		}
+	}
+	err := h.store.Put(hashBucket, h.entries)
+	h.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return h.store.Save()
+}
+
+// FileSHA256 returns the hex SHA-256 digest of the file at path.
+func FileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	d := sha256.New()
+	if _, err := io.Copy(d, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(d.Sum(nil)), nil
+}
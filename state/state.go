@@ -0,0 +1,148 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Dir is the hidden directory under the archive root holding generated
+// state. The indexer and watcher never descend into it.
+const Dir = ".ima"
+
+const fileName = "state.json"
+
+// locks serialises access to each state file within the process, so the
+// watcher, the server and commands can share one archive.
+var locks sync.Map // path -> *sync.Mutex
+
+// Store is a set of named JSON buckets cached between runs, such as image
+// metadata or file hashes. Each user owns its bucket and decodes it into
+// its own type.
+type Store struct {
+	path    string
+	buckets map[string]json.RawMessage
+	dirty   map[string]bool
+}
+
+// Open loads the state of the archive at root. A missing state file yields
+// an empty store.
+func Open(root string) (*Store, error) {
+	s := &Store{
+		path:  filepath.Join(root, Dir, fileName),
+		dirty: make(map[string]bool),
+	}
+
+	mu := s.lock()
+	defer mu.Unlock()
+
+	buckets, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	s.buckets = buckets
+	return s, nil
+}
+
+// Path returns the path of a file inside the state directory of root.
+func Path(root, name string) string {
+	return filepath.Join(root, Dir, name)
+}
+
+func (s *Store) lock() *sync.Mutex {
+	mu, _ := locks.LoadOrStore(s.path, &sync.Mutex{})
+	m := mu.(*sync.Mutex)
+	m.Lock()
+	return m
+}
+
+func (s *Store) load() (map[string]json.RawMessage, error) {
+	buckets := make(map[string]json.RawMessage)
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return buckets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &buckets); err != nil {
+		return nil, err
+	}
+	return buckets, nil
+}
+
+// Get decodes the bucket into v. v is left untouched if the bucket does
+// not exist.
+func (s *Store) Get(bucket string, v any) error {
+	data, ok := s.buckets[bucket]
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Put replaces the bucket with the encoding of v. Changes are kept in
+// memory until Save is called.
+func (s *Store) Put(bucket string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.buckets[bucket] = data
+	s.dirty[bucket] = true
+	return nil
+}
+
+// Save writes the buckets changed through Put back to disk. Buckets written
+// by others since Open are preserved.
+func (s *Store) Save() error {
+	if len(s.dirty) == 0 {
+		return nil
+	}
+
+	mu := s.lock()
+	defer mu.Unlock()
+
+	current, err := s.load()
+	if err != nil {
+		return err
+	}
+	for bucket := range s.dirty {
+		current[bucket] = s.buckets[bucket]
+	}
+
+	data, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	if err := WriteFile(s.path, data); err != nil {
+		return err
+	}
+	s.dirty = make(map[string]bool)
+	return nil
+}
+
+// WriteFile atomically replaces the file at path, creating its directory
+// if needed.
+func WriteFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,123 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreRoundTrip(t *testing.T) {
+	root := t.TempDir()
+
+	s, err := Open(root)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if err := s.Put("numbers", map[string]int{"one": 1}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	s, err = Open(root)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	var got map[string]int
+	if err := s.Get("numbers", &got); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got["one"] != 1 {
+		t.Errorf("Get returned %v; want one=1", got)
+	}
+
+	var missing []string
+	if err := s.Get("missing", &missing); err != nil || missing != nil {
+		t.Errorf("Get(missing) = %v, %v; want nil, nil", missing, err)
+	}
+}
+
+func TestStoreSavePreservesOtherBuckets(t *testing.T) {
+	root := t.TempDir()
+
+	a, _ := Open(root)
+	b, _ := Open(root)
+
+	a.Put("a", "from a")
+	if err := a.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// b was opened before a saved, but must not drop a's bucket.
+	b.Put("b", "from b")
+	if err := b.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	s, _ := Open(root)
+	var va, vb string
+	s.Get("a", &va)
+	s.Get("b", &vb)
+	if va != "from a" || vb != "from b" {
+		t.Errorf("buckets = %q, %q; want both preserved", va, vb)
+	}
+}
+
+func TestStoreSaveWithoutChangesWritesNothing(t *testing.T) {
+	root := t.TempDir()
+
+	s, _ := Open(root)
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if _, err := os.Stat(Path(root, fileName)); !os.IsNotExist(err) {
+		t.Errorf("Save created a state file without changes")
+	}
+}
+
+func TestHashesCache(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "a.jpg")
+	os.WriteFile(path, []byte("hello"), 0644)
+	info, _ := os.Stat(path)
+
+	s, _ := Open(root)
+	h := NewHashes(s, root)
+	sum, err := h.Sum("a.jpg", info.Size(), info.ModTime())
+	if err != nil {
+		t.Fatalf("Sum failed: %v", err)
+	}
+	const want = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	if sum != want {
+		t.Errorf("Sum = %s; want %s", sum, want)
+	}
+	if err := h.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// A cached digest is trusted while the size and mtime match.
+	s, _ = Open(root)
+	h = NewHashes(s, root)
+	os.WriteFile(path, []byte("world"), 0644)
+	os.Chtimes(path, info.ModTime(), info.ModTime())
+	if sum, _ := h.Sum("a.jpg", info.Size(), info.ModTime()); sum != want {
+		t.Errorf("Sum = %s; want the cached %s", sum, want)
+	}
+	if rel, ok := h.Find(want); !ok || rel != "a.jpg" {
+		t.Errorf("Find = %q, %v; want a.jpg", rel, ok)
+	}
+
+	// A changed mtime forces a new read.
+	info, _ = os.Stat(path)
+	later := info.ModTime().Add(time.Second)
+	os.Chtimes(path, later, later)
+	info, _ = os.Stat(path)
+	if sum, _ := h.Sum("a.jpg", info.Size(), info.ModTime()); sum == want {
+		t.Errorf("Sum returned the stale digest after the file changed")
+	}
+	if _, ok := h.Find(want); ok {
+		t.Errorf("Find returned the stale digest after the file changed")
+	}
+}
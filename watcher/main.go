@@ -8,7 +8,9 @@ import (
 	"github.com/image-archive/indexer"
 )
 
-func EventConsumer(ctx context.Context, eventChan <-chan FileEvent) {
+// EventConsumer regenerates the pages of the archive rooted at root for
+// each event received until the context is cancelled.
+func EventConsumer(ctx context.Context, root string, eventChan <-chan FileEvent) {
 	for {
 		select {
 		case <-ctx.Done():
@@ -18,13 +20,13 @@ func EventConsumer(ctx context.Context, eventChan <-chan FileEvent) {
 			if !ok {
 				return
 			}
-			processEvent(event)
+			processEvent(root, event)
 
 		}
 	}
 }
 
-func processEvent(event FileEvent) {
+func processEvent(root string, event FileEvent) {
 	log.Printf("[EVENT] %-8s %q (Size: %d, Dir: %t)",
 		event.Op.String(),
 		event.Name,
@@ -32,8 +34,8 @@ func processEvent(event FileEvent) {
 		event.IsDir,
 	)
 	if event.IsDir {
-		indexer.SplitCreate(event.Name)
+		indexer.Update(root, event.Name)
 	} else {
-		indexer.SplitCreate(filepath.Dir(event.Name))
+		indexer.Update(root, filepath.Dir(event.Name))
 	}
 }
@@ -17,6 +17,11 @@ type FileEvent struct {
 	Additional interface{} // For custom metadata
 }
 
+// GeneratedFiles are the names of the files and directories written by the
+// indexer and other commands, whose changes must not trigger an update.
+// Temporary files are named .ima-* until they are complete.
+var GeneratedFiles = []string{"index.html", "index-*.html", "index.zip", "search-index.js", "manifest-sha256.txt", ".thumbs", ".previews", ".locked", ".ima", ".ima-*", ".trash"}
+
 // Config holds watcher configuration
 type Config struct {
 	Path         string